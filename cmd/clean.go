@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"github.com/kubepatch/kubepatch/internal/unstr"
+	"github.com/spf13/cobra"
+)
+
+type CleanCmdOptions struct {
+	Filenames []string
+	Recursive bool
+}
+
+func NewCleanCmd() *cobra.Command {
+	opts := CleanCmdOptions{}
+	outputOpts := OutputOptions{}
+	cmd := &cobra.Command{
+		Use:           "clean",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Short:         "Turn objects exported from a cluster into base manifests",
+		Long: `Clean reads objects exported with 'kubectl get -o yaml' (a List or multiple
+documents) and prints them without the fields added by the cluster: status,
+managedFields, uid, resourceVersion, generation, creationTimestamp, the
+kubectl last-applied-configuration annotation and the cluster IPs allocated
+to Services.`,
+
+		Example: `
+  # Start a base from what is running in the cluster
+  kubectl get deploy,svc,cm -n prod -o yaml | kubepatch clean -f - > base/myapp.yaml`,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			read := unstr.ReadSourcedDocs
+			if outputOpts.Preserve {
+				read = unstr.ReadPreservedDocs
+			}
+			docs, err := read(opts.Filenames, opts.Recursive)
+			if err != nil {
+				return err
+			}
+			objects := unstr.Objects(docs)
+			for _, obj := range objects {
+				unstr.Sanitize(obj)
+			}
+			return outputOpts.write(cmd.OutOrStdout(), objects, docs)
+		},
+	}
+	cmd.Flags().StringSliceVarP(&opts.Filenames, "filename", "f", nil, "Exported files, glob patterns, or directories; '-' reads stdin")
+	cmd.Flags().BoolVarP(&opts.Recursive, "recursive", "R", false, "Recurse into directories specified with --filename.")
+	outputOpts.addFlags(cmd)
+	_ = cmd.MarkFlagRequired("filename") //nolint:errcheck
+	return cmd
+}
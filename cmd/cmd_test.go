@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testBase = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: myapp
+spec:
+  replicas: 1
+`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// replicasPatch returns a patch-file rendering myapp as app with the replicas.
+func replicasPatch(app, replicas string) string {
+	return app + `:
+  deployment/myapp:
+    - op: replace
+      path: /spec/replicas
+      value: ` + replicas + "\n"
+}
+
+// execute runs the root command with args as given on the command line and
+// returns its output.
+func execute(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	root := NewRootCmd()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetErr(&out)
+	root.SetArgs(compareArgs(args))
+	err := root.Execute()
+	return out.String(), err
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubepatch/kubepatch/internal/diff"
+	"github.com/kubepatch/kubepatch/internal/patch"
+	"github.com/kubepatch/kubepatch/internal/unstr"
+	"github.com/spf13/cobra"
+)
+
+type CompareCmdOptions struct {
+	PatchCmdOptions
+
+	PatchFilePath2 string
+	Output         string
+}
+
+func NewCompareCmd() *cobra.Command {
+	opts := CompareCmdOptions{}
+	cmd := &cobra.Command{
+		Use:           "compare",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Short:         "Compare the manifests rendered by two patch-files",
+		Long: `Compare renders the base manifests with two patch-files and prints the fields
+that differ between both renders.
+
+Objects are aligned by their base manifest, not by the rendered name, so
+'myapp-dev' and 'myapp-prod' rendered from the same base Deployment are
+compared with each other.
+
+The second patch-file is given with --patchfile2, or its short form -p2.`,
+
+		Example: `
+  # Which fields differ between dev and prod?
+  kubepatch compare -f base/ -p patches/dev.yaml -p2 patches/prod.yaml
+
+  # Markdown table for a pull request comment
+  kubepatch compare -f base/ -p patches/dev.yaml --patchfile2 patches/prod.yaml -o markdown`,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			objects, err := opts.compare()
+			if err != nil {
+				return err
+			}
+			left, right := compareNames(opts.PatchFilePath, opts.PatchFilePath2)
+			return diff.WriteComparison(cmd.OutOrStdout(), opts.Output, left, right, objects)
+		},
+	}
+	opts.addFlags(cmd)
+	cmd.Flags().StringVar(&opts.PatchFilePath2, "patchfile2", "", "Patch file to compare the --patchfile render with (-p2)")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", diff.FormatText, "Output format: text, json or markdown")
+	_ = cmd.MarkFlagRequired("patchfile2") //nolint:errcheck
+	return cmd
+}
+
+// patchFile2Shorthand is the short form of --patchfile2. Flag shorthands are
+// single letters, so it is rewritten by compareArgs before the flags are parsed.
+const patchFile2Shorthand = "-p2"
+
+// compareArgs rewrites "-p2 x", "-p2=x" and "-p2x" in the arguments of the
+// compare command to --patchfile2, which the flag parser would otherwise read
+// as -p with the value "2", "2=x" or "2x".
+func compareArgs(args []string) []string {
+	if len(args) == 0 || args[0] != "compare" {
+		return args
+	}
+	out := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		switch {
+		case arg == patchFile2Shorthand:
+			arg = "--patchfile2"
+		case strings.HasPrefix(arg, patchFile2Shorthand):
+			arg = "--patchfile2=" + strings.TrimPrefix(strings.TrimPrefix(arg, patchFile2Shorthand), "=")
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+func (opts *CompareCmdOptions) compare() ([]diff.ObjectChanges, error) {
+	docs, leftPatchFile, err := opts.read()
+	if err != nil {
+		return nil, err
+	}
+	rightPatchFile, err := patch.ReadPatchFileWithOptions(opts.PatchFilePath2, opts.readOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	// rendering modifies the objects, every render gets its own copy
+	rightDocs := make([]unstr.Doc, len(docs))
+	bases := make([]string, len(docs))
+	for i, d := range docs {
+		rightDocs[i] = unstr.Doc{Source: d.Source, Object: d.Object.DeepCopy()}
+		bases[i] = objectName(d.Object.GetKind(), d.Object.GetName())
+	}
+	left, err := opts.renderDocs(docs, leftPatchFile, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", opts.PatchFilePath, err)
+	}
+	right, err := opts.renderDocs(rightDocs, rightPatchFile, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", opts.PatchFilePath2, err)
+	}
+
+	r := opts.redactor()
+	var objects []diff.ObjectChanges
+	for i := range bases {
+		changes := diff.Objects(r.Object(left[i]).Object, r.Object(right[i]).Object)
+		if len(changes) == 0 {
+			continue
+		}
+		objects = append(objects, diff.ObjectChanges{
+			Base:    bases[i],
+			Left:    objectName(left[i].GetKind(), left[i].GetName()),
+			Right:   objectName(right[i].GetKind(), right[i].GetName()),
+			Changes: changes,
+		})
+	}
+	return objects, nil
+}
+
+// compareNames labels both renders by their patch-file name without extension,
+// e.g. "dev" and "prod", falling back to the full paths when those are equal.
+func compareNames(left, right string) (string, string) {
+	l := strings.TrimSuffix(filepath.Base(left), filepath.Ext(left))
+	r := strings.TrimSuffix(filepath.Base(right), filepath.Ext(right))
+	if l == r {
+		return left, right
+	}
+	return l, r
+}
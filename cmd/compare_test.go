@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{"Separate value", []string{"compare", "-p", "dev.yaml", "-p2", "prod.yaml"}, []string{"compare", "-p", "dev.yaml", "--patchfile2", "prod.yaml"}},
+		{"Equals", []string{"compare", "-p2=prod.yaml"}, []string{"compare", "--patchfile2=prod.yaml"}},
+		{"Attached", []string{"compare", "-p2prod.yaml"}, []string{"compare", "--patchfile2=prod.yaml"}},
+		{"Long flag", []string{"compare", "--patchfile2", "prod.yaml"}, []string{"compare", "--patchfile2", "prod.yaml"}},
+		{"After --", []string{"compare", "--", "-p2"}, []string{"compare", "--", "-p2"}},
+		{"Other command", []string{"patch", "-p2.yaml"}, []string{"patch", "-p2.yaml"}},
+		{"No command", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, compareArgs(tt.args))
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", testBase)
+	dev := writeFile(t, dir, "dev.yaml", replicasPatch("myapp-dev", "1"))
+	prod := writeFile(t, dir, "prod.yaml", replicasPatch("myapp-prod", "3"))
+
+	for _, p2 := range [][]string{{"-p2", prod}, {"-p2=" + prod}, {"--patchfile2", prod}} {
+		args := append([]string{"compare", "-f", base, "-p", dev, "-o", "markdown"}, p2...)
+		out, err := execute(t, args...)
+		require.NoError(t, err, p2[0])
+		assert.Contains(t, out, "| Object | Field | dev | prod |\n|---|---|---|---|\n", p2[0])
+		assert.Contains(t, out, "| deployment/myapp | `/spec/replicas` | `1` | `3` |\n", p2[0])
+	}
+
+	out, err := execute(t, "compare", "-f", base, "-p", dev, "-p2", prod)
+	require.NoError(t, err)
+	assert.Contains(t, out, "# deployment/myapp (dev: deployment/myapp-dev, prod: deployment/myapp-prod)\n")
+	assert.Contains(t, out, "/spec/replicas\n  dev: 1\n  prod: 3\n")
+
+	_, err = execute(t, "compare", "-f", base, "-p", dev)
+	assert.EqualError(t, err, `required flag(s) "patchfile2" not set`)
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/kubepatch/kubepatch/internal/crypt"
+	"github.com/spf13/cobra"
+)
+
+type DecryptCmdOptions struct {
+	PatchFilePath string
+	AgeKeyFile    string
+}
+
+func NewDecryptCmd() *cobra.Command {
+	opts := DecryptCmdOptions{}
+	cmd := &cobra.Command{
+		Use:           "decrypt",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Short:         "Print the plaintext of an encrypted patch-file",
+		Long: `Decrypt prints a patch-file encrypted with 'kubepatch encrypt', sops or age
+in plain text, as the patch command reads it. SOPS files are verified
+against their MAC. Armored age values inside a plain patch-file are
+decrypted as well.`,
+
+		Example: `
+  # Review an encrypted patch-file
+  kubepatch decrypt -p patches/prod.yaml --age-key-file ~/.config/kubepatch/keys.txt`,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			data, err := os.ReadFile(opts.PatchFilePath)
+			if err != nil {
+				return err
+			}
+			plain, err := crypt.Decrypt(data, opts.AgeKeyFile)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(plain)
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&opts.PatchFilePath, "patchfile", "p", "", "Patch file to decrypt")
+	cmd.Flags().StringVar(&opts.AgeKeyFile, "age-key-file", "", "File of age identities (default $KUBEPATCH_AGE_KEY_FILE, $SOPS_AGE_KEY_FILE or the sops key file)")
+	_ = cmd.MarkFlagRequired("patchfile") //nolint:errcheck
+	return cmd
+}
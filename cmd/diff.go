@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kubepatch/kubepatch/internal/diff"
+	"github.com/kubepatch/kubepatch/internal/gitrev"
+	"github.com/kubepatch/kubepatch/internal/resolve"
+	"github.com/kubepatch/kubepatch/internal/unstr"
+	"github.com/spf13/cobra"
+)
+
+type DiffCmdOptions struct {
+	PatchCmdOptions
+
+	FromRef      string
+	Snapshots    []string
+	IgnoreFields []string
+
+	Structural bool
+	Context    int
+	Color      string
+	Stat       bool
+}
+
+func NewDiffCmd() *cobra.Command {
+	opts := DiffCmdOptions{}
+	cmd := &cobra.Command{
+		Use:           "diff",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Short:         "Show what the patch-file changes in every base manifest",
+		Long: `Diff renders the manifests like 'patch' does and prints, for every object,
+the difference between the base manifest and its rendered counterpart.
+
+Objects are compared with sorted keys, so key order in the base files never
+shows up as a change. By default a unified diff of the YAML is printed;
+--structural lists the changed fields as JSON pointers instead.
+
+With --from-ref, the base files and the patch-file are also read as they were
+at a git revision, and the manifests rendered from the revision are compared
+with the manifests rendered from the working tree. Objects are paired by API
+group, kind, namespace and rendered name.
+
+With --snapshot, the rendered manifests are compared with objects exported
+from a cluster ('kubectl get -o yaml', a List or multiple documents) to detect
+drift without cluster access. Fields populated by the API server (status,
+managedFields, uid, resourceVersion, generation, creationTimestamp) are not
+compared, neither are the fields given with --ignore-field. Exported objects
+that are not rendered are not reported.
+
+Exit status is 0 when nothing changed, 1 when any object changed and 2 on errors.`,
+
+		Example: `
+  # Review what prod changes
+  kubepatch diff -f base/ -p patches/prod.yaml
+
+  # Changed fields only, one line per field
+  kubepatch diff -f base/ -p patches/prod.yaml --structural
+
+  # What does this branch change in prod?
+  kubepatch diff -f base/ -p patches/prod.yaml --from-ref origin/main --structural
+
+  # Has the cluster drifted from prod?
+  kubectl get deploy,svc,cm -n prod -o yaml > live.yaml
+  kubepatch diff -f base/ -p patches/prod.yaml --snapshot live.yaml \
+      --ignore-field Deployment:/spec/progressDeadlineSeconds \
+      --ignore-field '/spec/template/spec/containers/*/terminationMessagePath'
+
+  # Summary per object
+  kubepatch diff -f base/ -p patches/prod.yaml --stat`,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			changed, err := opts.run(cmd.OutOrStdout())
+			if err != nil {
+				return &ExitError{Code: 2, Err: err}
+			}
+			if changed {
+				return &ExitError{Code: 1}
+			}
+			return nil
+		},
+	}
+	opts.addFlags(cmd)
+	opts.addDiffFlags(cmd)
+	return cmd
+}
+
+func (opts *DiffCmdOptions) addDiffFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.FromRef, "from-ref", "", "Compare with the manifests rendered from the base files and patch-file at this git revision")
+	cmd.Flags().StringSliceVar(&opts.Snapshots, "snapshot", nil, "Compare with objects exported from a cluster, e.g. by 'kubectl get -o yaml'")
+	cmd.Flags().StringArrayVar(&opts.IgnoreFields, "ignore-field", nil, "Field not compared with --snapshot, as [Kind:]/json/pointer; '*' matches any key or index")
+	cmd.Flags().BoolVar(&opts.Structural, "structural", false, "Print changed fields instead of a unified diff")
+	cmd.Flags().IntVar(&opts.Context, "context", 3, "Number of unchanged lines around every change of a unified diff")
+	cmd.Flags().StringVar(&opts.Color, "color", "auto", "Colorize the diff: auto, always or never")
+	cmd.Flags().BoolVar(&opts.Stat, "stat", false, "Print a summary of changed fields per object instead of the diff")
+}
+
+func (opts *DiffCmdOptions) run(w io.Writer) (bool, error) {
+	if opts.FromRef != "" && len(opts.Snapshots) > 0 {
+		return false, errors.New("--from-ref and --snapshot cannot be combined")
+	}
+	if opts.FromRef != "" {
+		return opts.runFromRef(w)
+	}
+	if len(opts.Snapshots) > 0 {
+		return opts.runSnapshot(w)
+	}
+
+	docs, patchFile, err := opts.read()
+	if err != nil {
+		return false, err
+	}
+
+	// rendering modifies the objects, keep the base for the comparison
+	pairs := make([]diff.Pair, len(docs))
+	for i, d := range docs {
+		pairs[i] = diff.Pair{
+			FromName: fmt.Sprintf("%s (%s)", objectName(d.Object.GetKind(), d.Object.GetName()), d.Source),
+			From:     d.Object.DeepCopy(),
+		}
+	}
+	rendered, err := opts.renderDocs(docs, patchFile, nil)
+	if err != nil {
+		return false, err
+	}
+	for i, obj := range rendered {
+		pairs[i].ToName = objectName(obj.GetKind(), obj.GetName()) + " (rendered)"
+		pairs[i].To = obj
+	}
+
+	return opts.write(w, pairs)
+}
+
+// runFromRef renders the working tree and the git revision and diffs both
+// renders, pairing objects by their rendered identity.
+func (opts *DiffCmdOptions) runFromRef(w io.Writer) (bool, error) {
+	rendered, _, err := opts.render(nil)
+	if err != nil {
+		return false, err
+	}
+
+	tree, err := gitrev.Checkout(opts.FromRef)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = tree.Close() //nolint:errcheck
+	}()
+
+	atRef := opts.PatchCmdOptions
+	atRef.Filenames = make([]string, len(opts.Filenames))
+	for i, f := range opts.Filenames {
+		// remote manifests are not versioned with the repository
+		if resolve.IsURL(f) {
+			atRef.Filenames[i] = f
+			continue
+		}
+		if atRef.Filenames[i], err = tree.Path(f); err != nil {
+			return false, err
+		}
+	}
+	if atRef.PatchFilePath, err = tree.Path(opts.PatchFilePath); err != nil {
+		return false, err
+	}
+	previous, _, err := atRef.render(nil)
+	if err != nil {
+		return false, fmt.Errorf("rendering %s: %w", opts.FromRef, err)
+	}
+
+	return opts.write(w, diff.Match(previous, rendered, opts.FromRef, "rendered"))
+}
+
+// runSnapshot diffs the objects of a cluster export with the rendered
+// objects, ignoring fields populated by the API server.
+func (opts *DiffCmdOptions) runSnapshot(w io.Writer) (bool, error) {
+	rules := append([]diff.IgnoreRule{}, diff.ServerFields...)
+	for _, f := range opts.IgnoreFields {
+		r, err := diff.ParseIgnoreRule(f)
+		if err != nil {
+			return false, err
+		}
+		rules = append(rules, r)
+	}
+
+	live, err := unstr.ReadDocs(opts.Snapshots, false)
+	if err != nil {
+		return false, err
+	}
+	rendered, _, err := opts.render(nil)
+	if err != nil {
+		return false, err
+	}
+	for i, obj := range live {
+		live[i] = diff.Strip(obj, rules)
+	}
+	for i, obj := range rendered {
+		rendered[i] = diff.Strip(obj, rules)
+	}
+
+	return opts.write(w, diff.MatchLive(live, rendered, "snapshot", "rendered"))
+}
+
+func (opts *DiffCmdOptions) write(w io.Writer, pairs []diff.Pair) (bool, error) {
+	color, err := useColor(opts.Color, w)
+	if err != nil {
+		return false, err
+	}
+	// masks are stable within a run, changed secrets still show as changed
+	r := opts.redactor()
+	for i := range pairs {
+		pairs[i].From = r.Object(pairs[i].From)
+		pairs[i].To = r.Object(pairs[i].To)
+	}
+	return diff.Write(w, pairs, diff.Options{
+		Structural: opts.Structural,
+		Context:    opts.Context,
+		Color:      color,
+		Stat:       opts.Stat,
+	})
+}
+
+func objectName(kind, name string) string {
+	return strings.ToLower(kind) + "/" + name
+}
+
+// useColor resolves the --color flag; "auto" colours terminals unless NO_COLOR is set.
+func useColor(mode string, w io.Writer) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		f, ok := w.(*os.File)
+		if !ok {
+			return false, nil
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			return false, nil
+		}
+		return fi.Mode()&os.ModeCharDevice != 0, nil
+	default:
+		return false, fmt.Errorf("invalid --color %q, expected auto, always or never", mode)
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exitCode(t *testing.T, err error) int {
+	t.Helper()
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr), "not an exit error: %v", err)
+	return exitErr.Code
+}
+
+func TestDiff_ExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", testBase)
+	prod := writeFile(t, dir, "prod.yaml", replicasPatch("myapp-prod", "3"))
+
+	rendered, err := execute(t, "patch", "-f", base, "-p", prod)
+	require.NoError(t, err)
+	snapshot := writeFile(t, dir, "snapshot.yaml", rendered)
+
+	t.Run("unchanged", func(t *testing.T) {
+		out, err := execute(t, "diff", "-f", base, "-p", prod, "--snapshot", snapshot)
+		assert.Equal(t, 0, exitCode(t, err))
+		assert.Empty(t, out)
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		out, err := execute(t, "diff", "-f", base, "-p", prod, "--color", "never")
+		assert.Equal(t, 1, exitCode(t, err))
+		assert.Contains(t, out, "--- deployment/myapp ("+base+")\n+++ deployment/myapp-prod (rendered)\n")
+		assert.Contains(t, out, "-  replicas: 1\n+  replicas: 3\n")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := execute(t, "diff", "-f", base, "-p", prod+".missing")
+		assert.Equal(t, 2, exitCode(t, err))
+		assert.ErrorContains(t, err, "no such file or directory")
+	})
+}
+
+func TestDiff_Stat(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", testBase)
+	prod := writeFile(t, dir, "prod.yaml", replicasPatch("myapp-prod", "3"))
+
+	out, err := execute(t, "diff", "-f", base, "-p", prod, "--stat")
+	assert.Equal(t, 1, exitCode(t, err))
+	assert.Equal(t, "deployment/myapp-prod (rendered) | 5 fields changed (+3 -0 ~2)\n"+
+		"1 of 1 objects changed, 5 fields changed (+3 -0 ~2)\n", out)
+}
+
+func TestDiff_Structural(t *testing.T) {
+	dir := t.TempDir()
+	base := writeFile(t, dir, "base.yaml", testBase)
+	prod := writeFile(t, dir, "prod.yaml", replicasPatch("myapp-prod", "3"))
+
+	out, err := execute(t, "diff", "-f", base, "-p", prod, "--structural", "--color", "never")
+	assert.Equal(t, 1, exitCode(t, err))
+	assert.Contains(t, out, "~ /metadata/name: \"myapp\" -> \"myapp-prod\"\n")
+	assert.Contains(t, out, "~ /spec/replicas: 1 -> 3\n")
+}
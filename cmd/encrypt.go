@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kubepatch/kubepatch/internal/crypt"
+	"github.com/kubepatch/kubepatch/internal/output"
+	"github.com/spf13/cobra"
+)
+
+// Encryption formats.
+const (
+	encryptFormatSops = "sops"
+	encryptFormatAge  = "age"
+)
+
+// defaultEncryptedRegex encrypts the operation values only, keeping op and
+// path readable, unless --unencrypted-regex is set.
+const defaultEncryptedRegex = "^value$"
+
+type EncryptCmdOptions struct {
+	PatchFilePath    string
+	Recipients       []string
+	RecipientsFile   string
+	Format           string
+	EncryptedRegex   string
+	UnencryptedRegex string
+	InPlace          bool
+}
+
+func NewEncryptCmd() *cobra.Command {
+	opts := EncryptCmdOptions{}
+	cmd := &cobra.Command{
+		Use:           "encrypt",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Short:         "Encrypt a patch-file with age",
+		Long: `Encrypt encrypts a patch-file for one or more age recipients, so that it
+can be committed next to the other patch-files. By default the operation
+values are encrypted one by one in the SOPS format, keeping the keys, op and
+path readable; with --format age the whole file is encrypted. The patch, explain, diff and
+compare commands decrypt it with the identities of --age-key-file.`,
+
+		Example: `
+  # Encrypt the values of all operations in place
+  kubepatch encrypt -p patches/prod.yaml -r age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p -i
+
+  # Encrypt every value, including op and path
+  kubepatch encrypt -p patches/prod.yaml -r age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p \
+      --encrypted-regex '.' -i
+
+  # Encrypt a whole file for the recipients of a file
+  kubepatch encrypt -p patches/prod.yaml --recipients-file recipients.txt --format age > patches/prod.yaml.age`,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			data, err := opts.encrypt()
+			if err != nil {
+				return err
+			}
+			if opts.InPlace {
+				return output.WriteFile(opts.PatchFilePath, data)
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+	cmd.Flags().StringVarP(&opts.PatchFilePath, "patchfile", "p", "", "Patch file to encrypt")
+	cmd.Flags().StringArrayVarP(&opts.Recipients, "recipient", "r", nil, "age recipient to encrypt for, e.g. age1...; can be repeated")
+	cmd.Flags().StringVar(&opts.RecipientsFile, "recipients-file", "", "File of age recipients, one per line")
+	cmd.Flags().StringVar(&opts.Format, "format", encryptFormatSops, "Encryption format: sops (value by value) or age (whole file)")
+	cmd.Flags().StringVar(&opts.EncryptedRegex, "encrypted-regex", "", "Only encrypt values below keys matching this regex (sops format); defaults to "+defaultEncryptedRegex+" without --unencrypted-regex")
+	cmd.Flags().StringVar(&opts.UnencryptedRegex, "unencrypted-regex", "", "Do not encrypt values below keys matching this regex (sops format)")
+	cmd.Flags().BoolVarP(&opts.InPlace, "in-place", "i", false, "Replace the patch-file instead of printing to stdout")
+	_ = cmd.MarkFlagRequired("patchfile") //nolint:errcheck
+	return cmd
+}
+
+func (opts *EncryptCmdOptions) encrypt() ([]byte, error) {
+	data, err := os.ReadFile(opts.PatchFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if crypt.IsEncrypted(data) {
+		return nil, fmt.Errorf("%s is already encrypted", opts.PatchFilePath)
+	}
+
+	recipients := opts.Recipients
+	if opts.RecipientsFile != "" {
+		content, err := os.ReadFile(opts.RecipientsFile)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, crypt.RecipientLines(content)...)
+	}
+
+	switch opts.Format {
+	case encryptFormatSops:
+		encryptedRegex := opts.EncryptedRegex
+		if encryptedRegex == "" && opts.UnencryptedRegex == "" {
+			encryptedRegex = defaultEncryptedRegex
+		}
+		return crypt.EncryptSops(data, recipients, crypt.SopsOptions{
+			EncryptedRegex:   encryptedRegex,
+			UnencryptedRegex: opts.UnencryptedRegex,
+		})
+	case encryptFormatAge:
+		if opts.EncryptedRegex != "" || opts.UnencryptedRegex != "" {
+			return nil, fmt.Errorf("--encrypted-regex and --unencrypted-regex require --format %s", encryptFormatSops)
+		}
+		parsed, err := crypt.ParseRecipients(recipients)
+		if err != nil {
+			return nil, err
+		}
+		return crypt.EncryptAge(data, parsed)
+	default:
+		return nil, fmt.Errorf("unknown encryption format %q, expected %s or %s", opts.Format, encryptFormatSops, encryptFormatAge)
+	}
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"github.com/kubepatch/kubepatch/internal/patch"
+	"github.com/spf13/cobra"
+)
+
+func NewExplainCmd() *cobra.Command {
+	opts := PatchCmdOptions{}
+	cmd := &cobra.Command{
+		Use:           "explain",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Short:         "Explain how every rendered object was produced",
+		Long: `Explain renders the manifests exactly like 'patch' does, but instead of the
+manifests it prints, for every output object, the file it was read from, the
+app and resource key that matched it, the injected labels and name, and every
+applied operation with the value at its path before and after.`,
+
+		Example: `
+  # Why is replicas 3 in prod?
+  kubepatch explain -f base/ -p patches/prod.yaml`,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			trace := &patch.Trace{Redactor: opts.redactor()}
+			rendered, docs, err := opts.render(trace)
+			if err != nil {
+				return err
+			}
+			return trace.Write(cmd.OutOrStdout(), rendered, sources(docs))
+		},
+	}
+	opts.addFlags(cmd)
+	return cmd
+}
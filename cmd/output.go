@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/kubepatch/kubepatch/internal/output"
+	"github.com/kubepatch/kubepatch/internal/sealed"
+	"github.com/kubepatch/kubepatch/internal/unstr"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// OutputOptions control where and how commands print manifests.
+type OutputOptions struct {
+	Format  string
+	File    string
+	Dir     string
+	GroupBy string
+	Sort    string
+
+	Preserve bool
+
+	Indent              int
+	LiteralBlocks       bool
+	DropNulls           bool
+	CanonicalQuantities bool
+
+	SealCert  string
+	SealScope string
+}
+
+func (opts *OutputOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&opts.Format, "output", "o", output.FormatYAML, "Output format: yaml, json, jsonl or list (a v1/List)")
+	cmd.Flags().StringVar(&opts.File, "output-file", "", "Write the manifests to this file instead of stdout, replacing it atomically")
+	cmd.Flags().StringVar(&opts.Dir, "output-dir", "", "Write every object to its own file <kind>-<name>.yaml in this directory")
+	cmd.Flags().StringVar(&opts.GroupBy, "group-by", "", "Sub-directories of --output-dir: namespace or app")
+	cmd.Flags().BoolVar(&opts.Preserve, "preserve", false, "Keep key order, comments and quoting of the base manifests in YAML output, changing only patched nodes")
+	cmd.Flags().IntVar(&opts.Indent, "indent", 2, "Indentation width of YAML output")
+	cmd.Flags().BoolVar(&opts.LiteralBlocks, "literal-blocks", false, "Print multi-line strings as YAML literal block scalars")
+	cmd.Flags().BoolVar(&opts.DropNulls, "drop-nulls", false, "Remove null values and an empty metadata.creationTimestamp")
+	cmd.Flags().BoolVar(&opts.CanonicalQuantities, "canonical-quantities", false, "Print resource quantities in canonical form, e.g. 0.5 CPUs as 500m")
+	cmd.Flags().StringVar(&opts.SealCert, "seal-cert", "", "Convert every Secret into a SealedSecret, encrypted with this sealed-secrets controller certificate")
+	cmd.Flags().StringVar(&opts.SealScope, "seal-scope", string(sealed.ScopeStrict), "Scope of the SealedSecrets: strict, namespace-wide or cluster-wide")
+	cmd.Flags().StringVar(&opts.Sort, "sort", "", "Order of the objects: install (dependencies first) or name; input order by default")
+}
+
+// write prints objects. docs are the base documents of the objects, indexed
+// like them; they are only used with --preserve and may be nil otherwise.
+func (opts *OutputOptions) write(w io.Writer, objects []*unstructured.Unstructured, docs []unstr.Doc) error {
+	if opts.File != "" && opts.Dir != "" {
+		return errors.New("--output-file and --output-dir cannot be combined")
+	}
+	if opts.GroupBy != "" && opts.Dir == "" {
+		return errors.New("--group-by requires --output-dir")
+	}
+
+	if opts.Preserve && opts.Format != output.FormatYAML {
+		return errors.New("--preserve requires yaml output")
+	}
+
+	// remember the base document of every object before sorting
+	baseOf := map[*unstructured.Unstructured]*yaml.Node{}
+	if opts.Preserve {
+		for i, obj := range objects {
+			if i < len(docs) {
+				baseOf[obj] = docs[i].Node
+			}
+		}
+	}
+
+	// sealed Secrets have no base document, they are printed as they are
+	objects, err := opts.seal(objects)
+	if err != nil {
+		return err
+	}
+
+	objects, err = unstr.SortObjects(objects, opts.Sort)
+	if err != nil {
+		return err
+	}
+	if opts.Indent < 2 || opts.Indent > 9 {
+		return errors.New("--indent must be between 2 and 9")
+	}
+	enc := output.Encoder{
+		Format:              opts.Format,
+		Indent:              opts.Indent,
+		LiteralBlocks:       opts.LiteralBlocks,
+		DropNulls:           opts.DropNulls,
+		CanonicalQuantities: opts.CanonicalQuantities,
+	}
+	if opts.Preserve {
+		enc.Bases = make([]*yaml.Node, len(objects))
+		for i, obj := range objects {
+			enc.Bases[i] = baseOf[obj]
+		}
+	}
+
+	if opts.Dir != "" {
+		_, err := enc.WriteDir(opts.Dir, objects, opts.GroupBy)
+		return err
+	}
+
+	out, err := enc.Marshal(objects)
+	if err != nil {
+		return err
+	}
+	if opts.File != "" {
+		return output.WriteFile(opts.File, out)
+	}
+	if opts.Format == output.FormatYAML {
+		// keep the trailing empty line the yaml stream always had
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// seal replaces the Secrets of objects by SealedSecrets with --seal-cert.
+func (opts *OutputOptions) seal(objects []*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	if opts.SealCert == "" {
+		return objects, nil
+	}
+	scope, err := sealed.ParseScope(opts.SealScope)
+	if err != nil {
+		return nil, err
+	}
+	key, err := sealed.ReadPublicKey(opts.SealCert)
+	if err != nil {
+		return nil, err
+	}
+	return sealed.NewSealer(key, scope).SealAll(objects)
+}
@@ -1,9 +1,9 @@
 package cmd
 
 import (
-	"fmt"
-
+	"github.com/kubepatch/kubepatch/internal/redact"
 	"github.com/kubepatch/kubepatch/internal/unstr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"github.com/kubepatch/kubepatch/internal/patch"
 	"github.com/spf13/cobra"
@@ -14,10 +14,28 @@ type PatchCmdOptions struct {
 	PatchFilePath    string
 	Recursive        bool
 	EnvsubstPrefixes []string
+	EnvsubstVars     []string
+	EnvFiles         []string
+	ProvidersConfig  string
+	AgeKeyFile       string
+
+	DropAutoscaledReplicas bool
+	ConfigChecksums        bool
+
+	Explain     bool
+	ShowSecrets bool
+	Verbose     bool
+
+	// preserve reads the YAML nodes of the base manifests, for --preserve
+	preserve bool
+
+	// secrets masks secret values in explanations, diffs and logs
+	secrets *redact.Redactor
 }
 
 func NewPatchCmd() *cobra.Command {
 	opts := PatchCmdOptions{}
+	outputOpts := OutputOptions{}
 	cmd := &cobra.Command{
 		Use:           "patch",
 		SilenceErrors: true,
@@ -42,38 +60,133 @@ for GitOps diffing.`,
   kubepatch patch \
       -f base/ \
       -p patches/ci.yaml \
-      --envsubst-prefixes CI_`,
+      --envsubst-prefixes CI_
 
-		RunE: func(_ *cobra.Command, _ []string) error {
-			// read manifests
-			manifests, err := unstr.ReadDocs(opts.Filenames, opts.Recursive)
-			if err != nil {
-				return err
+  # Take the values from dotenv files, the environment still wins
+  kubepatch patch -f base/ -p patches/dev.yaml \
+      --envsubst-vars DB_HOST,DB_PASSWORD \
+      --env-file .env --env-file .env.local
+
+  # One file per object, in a directory per namespace
+  kubepatch patch -f base/ -p patches/prod.yaml --output-dir rendered/ --group-by namespace`,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var trace *patch.Trace
+			if opts.Explain {
+				trace = &patch.Trace{Redactor: opts.redactor()}
 			}
 
-			// read patch-file, subst envs
-			patchFile, err := patch.ReadPatchFile(opts.PatchFilePath, opts.EnvsubstPrefixes)
+			opts.preserve = outputOpts.Preserve
+			rendered, docs, err := opts.render(trace)
 			if err != nil {
 				return err
 			}
 
-			// preform the job
-			rendered, err := patch.Run(manifests, patchFile)
-			if err != nil {
-				return nil
+			// explanation goes to stderr, keeping stdout pipeable to kubectl
+			if trace != nil {
+				if err := trace.Write(cmd.ErrOrStderr(), rendered, sources(docs)); err != nil {
+					return err
+				}
 			}
 
 			// print rendered
-			fmt.Println(string(rendered))
-			return nil
+			return outputOpts.write(cmd.OutOrStdout(), rendered, docs)
 		},
 	}
+	opts.addFlags(cmd)
+	outputOpts.addFlags(cmd)
+	cmd.Flags().BoolVar(&opts.Explain, "explain", false, "Print to stderr how every object was rendered")
+	return cmd
+}
+
+func (opts *PatchCmdOptions) addFlags(cmd *cobra.Command) {
 	cmd.Flags().StringSliceVarP(&opts.Filenames, "filename", "f", nil, "Manifest files, glob patterns, or directories to apply")
 	cmd.Flags().StringVarP(&opts.PatchFilePath, "patchfile", "p", "", "Patch file")
 	cmd.Flags().BoolVarP(&opts.Recursive, "recursive", "R", false, "Recurse into directories specified with --filename.")
 	cmd.Flags().StringSliceVar(&opts.EnvsubstPrefixes, "envsubst-prefixes", nil, "List of prefixes, allowed for envsubst in a patch-file")
+	cmd.Flags().StringSliceVar(&opts.EnvsubstVars, "envsubst-vars", nil, "List of variable names, allowed for envsubst in a patch-file")
+	cmd.Flags().StringArrayVar(&opts.EnvFiles, "env-file", nil, "Dotenv file with values for envsubst, used for variables not set in the environment; repeatable, later files win")
+	cmd.Flags().StringVar(&opts.ProvidersConfig, "providers-config", "", "Config file enabling ${file:...}, ${exec:...} and ${vault:...} references in a patch-file")
+	cmd.Flags().StringVar(&opts.AgeKeyFile, "age-key-file", "", "File of age identities to decrypt an encrypted patch-file (default $KUBEPATCH_AGE_KEY_FILE, $SOPS_AGE_KEY_FILE or the sops key file)")
+	cmd.Flags().BoolVar(&opts.ShowSecrets, "show-secrets", false, "Print Secret data and env var values in explanations and diffs instead of masking them")
+	cmd.Flags().BoolVarP(&opts.Verbose, "verbose", "v", false, "Log debug information, e.g. env var substitutions, to stderr")
+	cmd.Flags().BoolVar(&opts.ConfigChecksums, "config-checksums", false, "Annotate pod templates with a checksum of the ConfigMaps and Secrets they reference")
+	cmd.Flags().BoolVar(&opts.DropAutoscaledReplicas, "drop-autoscaled-replicas", false, "Remove spec.replicas from workloads targeted by a HorizontalPodAutoscaler or KEDA ScaledObject")
 
 	_ = cmd.MarkFlagRequired("filename")  //nolint:errcheck
 	_ = cmd.MarkFlagRequired("patchfile") //nolint:errcheck
-	return cmd
+}
+
+// render reads the base manifests and the patch-file and renders them.
+// It returns the rendered objects and the base documents they were rendered from.
+func (opts *PatchCmdOptions) render(trace *patch.Trace) ([]*unstructured.Unstructured, []unstr.Doc, error) {
+	docs, patchFile, err := opts.read()
+	if err != nil {
+		return nil, nil, err
+	}
+	rendered, err := opts.renderDocs(docs, patchFile, trace)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rendered, docs, nil
+}
+
+// read reads the base manifests and the patch-file.
+func (opts *PatchCmdOptions) read() ([]unstr.Doc, patch.FullPatchFile, error) {
+	// read manifests
+	read := unstr.ReadSourcedDocs
+	if opts.preserve {
+		read = unstr.ReadPreservedDocs
+	}
+	docs, err := read(opts.Filenames, opts.Recursive)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// read patch-file, decrypt, subst envs
+	patchFile, err := patch.ReadPatchFileWithOptions(opts.PatchFilePath, opts.readOptions())
+	if err != nil {
+		return nil, nil, err
+	}
+	return docs, patchFile, nil
+}
+
+func (opts *PatchCmdOptions) readOptions() patch.ReadOptions {
+	return patch.ReadOptions{
+		EnvsubstPrefixes: opts.EnvsubstPrefixes,
+		EnvsubstVars:     opts.EnvsubstVars,
+		EnvFiles:         opts.EnvFiles,
+		ProvidersConfig:  opts.ProvidersConfig,
+		AgeKeyFile:       opts.AgeKeyFile,
+		Redactor:         opts.redactor(),
+		Verbose:          opts.Verbose,
+	}
+}
+
+// redactor returns the Redactor of the command, nil with --show-secrets.
+func (opts *PatchCmdOptions) redactor() *redact.Redactor {
+	if opts.ShowSecrets {
+		return nil
+	}
+	if opts.secrets == nil {
+		opts.secrets = redact.New()
+	}
+	return opts.secrets
+}
+
+// renderDocs renders docs with the options of the command. The objects of docs are modified in place.
+func (opts *PatchCmdOptions) renderDocs(docs []unstr.Doc, patchFile patch.FullPatchFile, trace *patch.Trace) ([]*unstructured.Unstructured, error) {
+	return patch.Render(unstr.Objects(docs), patchFile, patch.Options{
+		DropAutoscaledReplicas: opts.DropAutoscaledReplicas,
+		ConfigChecksums:        opts.ConfigChecksums,
+		Trace:                  trace,
+	})
+}
+
+func sources(docs []unstr.Doc) []string {
+	out := make([]string, len(docs))
+	for i, d := range docs {
+		out[i] = d.Source
+	}
+	return out
 }
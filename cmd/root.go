@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/spf13/cobra"
 )
 
@@ -16,5 +18,13 @@ func NewRootCmd() *cobra.Command {
 		Hidden: true,
 	})
 	rootCmd.AddCommand(NewPatchCmd())
+	rootCmd.AddCommand(NewExplainCmd())
+	rootCmd.AddCommand(NewDiffCmd())
+	rootCmd.AddCommand(NewCompareCmd())
+	rootCmd.AddCommand(NewCleanCmd())
+	rootCmd.AddCommand(NewEncryptCmd())
+	rootCmd.AddCommand(NewDecryptCmd())
+	rootCmd.AddCommand(NewVarsCmd())
+	rootCmd.SetArgs(compareArgs(os.Args[1:]))
 	return rootCmd
 }
@@ -0,0 +1,17 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The root command takes no positional arguments, so a new subcommand like
+// vars cannot shadow an app or patch-file name given on the command line.
+func TestRoot_PositionalArgs(t *testing.T) {
+	_, err := execute(t, "myapp")
+	assert.EqualError(t, err, `unknown command "myapp" for "kubepatch"`)
+
+	_, err = execute(t, "vars")
+	assert.EqualError(t, err, `required flag(s) "patchfile" not set`)
+}
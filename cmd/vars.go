@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"github.com/kubepatch/kubepatch/internal/envs"
+	"github.com/kubepatch/kubepatch/internal/patch"
+	"github.com/spf13/cobra"
+)
+
+type VarsCmdOptions struct {
+	PatchFilePath    string
+	EnvsubstPrefixes []string
+	EnvsubstVars     []string
+	EnvFiles         []string
+	AgeKeyFile       string
+	Output           string
+}
+
+func NewVarsCmd() *cobra.Command {
+	opts := VarsCmdOptions{}
+	cmd := &cobra.Command{
+		Use:           "vars",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Short:         "List the variables a patch-file declares and references",
+		Long: `Vars lists the variables a patch-file declares in its variables section or
+references with placeholders, and whether they are set in the environment or
+in an env file. Values are never printed.
+
+With --envsubst-prefixes or --envsubst-vars, referenced variables outside of
+the allowlist are left out, as they are never substituted, and declared ones
+outside of it are reported as not allowed. '-o env' prints a .env.example
+template with an empty entry for every variable.`,
+
+		Example: `
+  # Which variables does prod need, and which are missing?
+  kubepatch vars -p patches/prod.yaml --envsubst-prefixes CI_,APP_ --env-file .env
+
+  # Generate a template for the env file
+  kubepatch vars -p patches/prod.yaml -o env > .env.example`,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			statuses, err := patch.ReadVariables(opts.PatchFilePath, patch.ReadOptions{
+				EnvsubstPrefixes: opts.EnvsubstPrefixes,
+				EnvsubstVars:     opts.EnvsubstVars,
+				EnvFiles:         opts.EnvFiles,
+				AgeKeyFile:       opts.AgeKeyFile,
+			})
+			if err != nil {
+				return err
+			}
+			return envs.WriteStatuses(cmd.OutOrStdout(), opts.Output, statuses)
+		},
+	}
+	cmd.Flags().StringVarP(&opts.PatchFilePath, "patchfile", "p", "", "Patch file")
+	cmd.Flags().StringSliceVar(&opts.EnvsubstPrefixes, "envsubst-prefixes", nil, "List of prefixes, allowed for envsubst in a patch-file")
+	cmd.Flags().StringSliceVar(&opts.EnvsubstVars, "envsubst-vars", nil, "List of variable names, allowed for envsubst in a patch-file")
+	cmd.Flags().StringArrayVar(&opts.EnvFiles, "env-file", nil, "Dotenv file with values for envsubst, used for variables not set in the environment; repeatable")
+	cmd.Flags().StringVar(&opts.AgeKeyFile, "age-key-file", "", "File of age identities to decrypt an encrypted patch-file (default $KUBEPATCH_AGE_KEY_FILE, $SOPS_AGE_KEY_FILE or the sops key file)")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", envs.FormatText, "Output format: text, json or env")
+	_ = cmd.MarkFlagRequired("patchfile") //nolint:errcheck
+	return cmd
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVars_EnvExample(t *testing.T) {
+	t.Setenv("KP_CMD_REPLICAS", "2")
+
+	dir := t.TempDir()
+	prod := writeFile(t, dir, "prod.yaml", replicasPatch("myapp-prod", "${KP_CMD_REPLICAS:-3}")+`    - op: add
+      path: /spec/host
+      value: ${KP_CMD_HOST}
+variables:
+  KP_CMD_REPLICAS:
+    description: Number of pods
+`)
+
+	out, err := execute(t, "vars", "-p", prod, "-o", "env", "--envsubst-prefixes", "KP_CMD_")
+	require.NoError(t, err)
+	assert.Equal(t, `# KP_CMD_HOST
+KP_CMD_HOST=
+# KP_CMD_REPLICAS: Number of pods (default "3")
+KP_CMD_REPLICAS=
+`, out)
+}
@@ -0,0 +1,334 @@
+// Package crypt decrypts and encrypts patch-files with age, either as a whole,
+// value by value as SOPS documents, or as single armored age values.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables naming the age identities used for decryption.
+const (
+	// KeyFileEnv names a file of age identities.
+	KeyFileEnv = "KUBEPATCH_AGE_KEY_FILE"
+	// SopsKeyFileEnv is the key file variable of sops, used when KeyFileEnv is not set.
+	SopsKeyFileEnv = "SOPS_AGE_KEY_FILE"
+	// SopsKeyEnv holds the identities themselves, like a key file.
+	SopsKeyEnv = "SOPS_AGE_KEY"
+)
+
+// binaryHeader starts every age file that is not armored.
+const binaryHeader = "age-encryption.org/v1\n"
+
+// maxPlaintext limits the size of decrypted data.
+const maxPlaintext = 64 << 20
+
+// IsEncrypted tells whether data is an age file, a SOPS document or contains
+// armored age values.
+func IsEncrypted(data []byte) bool {
+	return isAgeFile(data) || isSops(data) || bytes.Contains(data, []byte(armor.Header))
+}
+
+// Decrypt returns the plaintext of data, which is returned as-is when it is
+// not encrypted. A whole age file is decrypted to its content; a SOPS
+// document to YAML without the sops metadata; armored age values of a YAML
+// document are replaced by their plaintext. The identities are read from
+// keyFile, see LoadIdentities.
+func Decrypt(data []byte, keyFile string) ([]byte, error) {
+	plain, _, err := DecryptValues(data, keyFile)
+	return plain, err
+}
+
+// DecryptValues works like Decrypt, but also returns the strings decrypted
+// from a SOPS document or an armored age value that may be secrets, e.g. to
+// mask them in diagnostics. The op, path and from fields of patch operations,
+// numbers and booleans are not returned, as masking them would hide what a
+// patch does. Of a whole age file, the strings below operation value fields
+// are returned.
+func DecryptValues(data []byte, keyFile string) ([]byte, []string, error) {
+	if !IsEncrypted(data) {
+		return data, nil, nil
+	}
+	identities, err := LoadIdentities(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case isAgeFile(data):
+		plain, err := decryptAge(bytes.NewReader(data), identities)
+		if err != nil {
+			return nil, nil, err
+		}
+		return plain, operationValues(plain), nil
+	case isSops(data):
+		return decryptSops(data, identities)
+	default:
+		return decryptValues(data, identities)
+	}
+}
+
+// LoadIdentities reads age identities from keyFile or, if it is empty, from
+// the file named by KUBEPATCH_AGE_KEY_FILE or SOPS_AGE_KEY_FILE, the keys in
+// SOPS_AGE_KEY, or the default sops key file <config dir>/sops/age/keys.txt.
+func LoadIdentities(keyFile string) ([]age.Identity, error) {
+	if keyFile == "" {
+		keyFile = os.Getenv(KeyFileEnv)
+	}
+	if keyFile == "" {
+		keyFile = os.Getenv(SopsKeyFileEnv)
+	}
+	if keyFile == "" {
+		if keys := os.Getenv(SopsKeyEnv); keys != "" {
+			ids, err := age.ParseIdentities(strings.NewReader(keys))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", SopsKeyEnv, err)
+			}
+			return ids, nil
+		}
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("no age key file, set --age-key-file or %s", KeyFileEnv)
+		}
+		keyFile = filepath.Join(dir, "sops", "age", "keys.txt")
+		if _, err := os.Stat(keyFile); err != nil {
+			return nil, fmt.Errorf("no age key file, set --age-key-file or %s", KeyFileEnv)
+		}
+	}
+
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	return ids, nil
+}
+
+// ParseRecipients parses age recipients, e.g. "age1...". Each entry may be a
+// single recipient or the content of a recipients file.
+func ParseRecipients(entries []string) ([]age.Recipient, error) {
+	var out []age.Recipient
+	for _, e := range entries {
+		r, err := age.ParseRecipients(strings.NewReader(e))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient: %w", err)
+		}
+		out = append(out, r...)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("at least one age recipient is required")
+	}
+	return out, nil
+}
+
+// RecipientLines returns the recipients of a recipients file, without comments and blank lines.
+func RecipientLines(data []byte) []string {
+	var out []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// EncryptAge encrypts data to an armored age file.
+func EncryptAge(data []byte, recipients []age.Recipient) ([]byte, error) {
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isAgeFile(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryHeader)) ||
+		bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header))
+}
+
+// decryptAge decrypts a binary or armored age file.
+func decryptAge(src io.Reader, identities []age.Identity) ([]byte, error) {
+	br := bufio.NewReader(src)
+	var in io.Reader = br
+	if head, err := br.Peek(len(binaryHeader)); err != nil || string(head) != binaryHeader {
+		in = armor.NewReader(br)
+	}
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return nil, err
+	}
+	out, err := io.ReadAll(io.LimitReader(r, maxPlaintext+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxPlaintext {
+		return nil, errors.New("decrypted data is too large")
+	}
+	return out, nil
+}
+
+// decryptValues replaces the armored age string values of a YAML document by
+// their plaintext, which it also returns.
+func decryptValues(data []byte, identities []age.Identity) ([]byte, []string, error) {
+	docs, err := decodeYAML(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var values []string
+	for _, doc := range docs {
+		err := walkScalars(doc, nil, func(n *yaml.Node, path []string) error {
+			if n.ShortTag() != "!!str" || !strings.HasPrefix(strings.TrimSpace(n.Value), armor.Header) {
+				return nil
+			}
+			plain, err := decryptAge(strings.NewReader(strings.TrimSpace(n.Value)), identities)
+			if err != nil {
+				return fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+			}
+			if secretPath(path) {
+				values = append(values, string(plain))
+			}
+			return replaceScalar(n, string(plain))
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	plain, err := encodeYAML(docs)
+	return plain, values, err
+}
+
+// operationValues returns the strings below the value fields of a decrypted
+// patch-file. Content that is not YAML has none, its parse error is reported
+// by the reader of the patch-file.
+func operationValues(data []byte) []string {
+	docs, err := decodeYAML(data)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	for _, doc := range docs {
+		_ = walkScalars(doc, nil, func(n *yaml.Node, path []string) error { //nolint:errcheck
+			if n.ShortTag() != "!!str" {
+				return nil
+			}
+			for _, key := range path {
+				if key == "value" {
+					values = append(values, n.Value)
+					break
+				}
+			}
+			return nil
+		})
+	}
+	return values
+}
+
+// operationKeys are the fields of a patch operation that describe it rather
+// than hold a value.
+var operationKeys = map[string]bool{"op": true, "path": true, "from": true, "format": true, "resource": true}
+
+// secretPath tells whether the scalar at path may be a secret: anything but
+// an operation field, unless it is part of an operation value, e.g. the path
+// of a hostPath volume.
+func secretPath(path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+	for _, key := range path[:len(path)-1] {
+		if key == "value" {
+			return true
+		}
+	}
+	return !operationKeys[path[len(path)-1]]
+}
+
+func decodeYAML(data []byte) ([]*yaml.Node, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var docs []*yaml.Node
+	for {
+		doc := &yaml.Node{}
+		err := dec.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
+
+func encodeYAML(docs []*yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// walkScalars calls fn for every scalar value below n with the map keys
+// leading to it. List indexes are not part of the path, as in SOPS.
+func walkScalars(n *yaml.Node, path []string, fn func(n *yaml.Node, path []string) error) error {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range n.Content {
+			if err := walkScalars(c, path, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := append(append([]string{}, path...), n.Content[i].Value)
+			if err := walkScalars(n.Content[i+1], p, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		return fn(n, path)
+	}
+	return nil
+}
+
+// replaceScalar sets n to value, keeping its comments.
+func replaceScalar(n *yaml.Node, value interface{}) error {
+	var enc yaml.Node
+	if err := enc.Encode(value); err != nil {
+		return err
+	}
+	enc.HeadComment, enc.LineComment, enc.FootComment = n.HeadComment, n.LineComment, n.FootComment
+	*n = enc
+	return nil
+}
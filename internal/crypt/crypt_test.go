@@ -0,0 +1,277 @@
+package crypt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+)
+
+const plainPatch = `# prod patches
+app:
+  deployment/app:
+    - op: replace
+      path: /spec/replicas
+      value: 2
+    - op: add
+      path: /spec/template/spec/containers/0/env
+      value:
+        - name: PGPASS
+          value: "s3cr$t" # db password
+        - name: DEBUG
+          value: "false"
+  secret/db:
+    - op: add
+      path: /stringData/enabled
+      value: true
+    - op: add
+      path: /stringData/empty
+      value: ""
+    - op: add
+      path: /stringData/none
+      value: null
+`
+
+// newKey writes a new age identity to a key file and returns the file and its recipient.
+func newKey(t *testing.T) (string, string) {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# test key\n"+id.String()+"\n"), 0o600))
+	return path, id.Recipient().String()
+}
+
+func assertSameYAML(t *testing.T, want, got string) {
+	t.Helper()
+	var w, g interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(want), &w))
+	require.NoError(t, yaml.Unmarshal([]byte(got), &g))
+	assert.Equal(t, w, g)
+}
+
+func TestSopsRoundTrip(t *testing.T) {
+	keyFile, recipient := newKey(t)
+
+	tests := []struct {
+		name       string
+		opts       SopsOptions
+		plainValue string // a value expected in the encrypted file, or ""
+	}{
+		{name: "all values", opts: SopsOptions{}},
+		{name: "encrypted regex", opts: SopsOptions{EncryptedRegex: "^value$"}, plainValue: "op: replace"},
+		{name: "unencrypted regex", opts: SopsOptions{UnencryptedRegex: "^(op|path)$"}, plainValue: "path: /spec/replicas"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, err := EncryptSops([]byte(plainPatch), []string{recipient}, tt.opts)
+			require.NoError(t, err)
+			assert.True(t, IsEncrypted(enc))
+			assert.NotContains(t, string(enc), "s3cr$t")
+			assert.Contains(t, string(enc), "deployment/app:", "keys stay readable")
+			assert.Contains(t, string(enc), "type:bool]")
+			if tt.plainValue != "" {
+				assert.Contains(t, string(enc), tt.plainValue)
+			}
+
+			plain, err := Decrypt(enc, keyFile)
+			require.NoError(t, err)
+			assertSameYAML(t, plainPatch, string(plain))
+			assert.NotContains(t, string(plain), "sops:")
+		})
+	}
+}
+
+func TestSopsTampering(t *testing.T) {
+	keyFile, recipient := newKey(t)
+	enc, err := EncryptSops([]byte("a: one\nb: two\nc_unencrypted: three\n"), []string{recipient}, SopsOptions{})
+	require.NoError(t, err)
+	lines := strings.Split(string(enc), "\n")
+	require.True(t, strings.HasPrefix(lines[0], "a: ENC["))
+	require.True(t, strings.HasPrefix(lines[1], "b: ENC["))
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unencrypted value changed",
+			content: strings.Replace(string(enc), "c_unencrypted: three", "c_unencrypted: four", 1),
+			wantErr: "MAC mismatch",
+		},
+		{
+			name:    "value removed",
+			content: strings.Join(lines[1:], "\n"),
+			wantErr: "MAC mismatch",
+		},
+		{
+			name:    "values moved",
+			content: strings.Join(append([]string{"a: " + lines[1][3:], "b: " + lines[0][3:]}, lines[2:]...), "\n"),
+			wantErr: "modified or moved",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decrypt([]byte(tt.content), keyFile)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestSopsWrongKey(t *testing.T) {
+	_, recipient := newKey(t)
+	otherKey, _ := newKey(t)
+	enc, err := EncryptSops([]byte("a: one\n"), []string{recipient}, SopsOptions{})
+	require.NoError(t, err)
+
+	_, err = Decrypt(enc, otherKey)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no age identity can decrypt")
+}
+
+func TestEncryptSopsErrors(t *testing.T) {
+	_, recipient := newKey(t)
+	tests := []struct {
+		name       string
+		content    string
+		recipients []string
+		opts       SopsOptions
+	}{
+		{name: "no recipients", content: "a: b\n"},
+		{name: "invalid recipient", content: "a: b\n", recipients: []string{"age1invalid"}},
+		{name: "both regexes", content: "a: b\n", recipients: []string{recipient}, opts: SopsOptions{EncryptedRegex: "a", UnencryptedRegex: "b"}},
+		{name: "not a mapping", content: "- a\n", recipients: []string{recipient}},
+		{name: "already encrypted", content: "a: b\nsops:\n  mac: x\n", recipients: []string{recipient}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := EncryptSops([]byte(tt.content), tt.recipients, tt.opts)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAgeFile(t *testing.T) {
+	keyFile, recipient := newKey(t)
+	recipients, err := ParseRecipients([]string{recipient})
+	require.NoError(t, err)
+
+	enc, err := EncryptAge([]byte(plainPatch), recipients)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(enc), "-----BEGIN AGE ENCRYPTED FILE-----"))
+
+	plain, err := Decrypt(enc, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, plainPatch, string(plain))
+}
+
+func TestAgeValues(t *testing.T) {
+	keyFile, recipient := newKey(t)
+	recipients, err := ParseRecipients([]string{recipient})
+	require.NoError(t, err)
+	enc, err := EncryptAge([]byte("s3cr$t"), recipients)
+	require.NoError(t, err)
+
+	content := "app:\n  secret/db:\n    - op: add\n      path: /stringData/password\n      value: |\n        " +
+		strings.ReplaceAll(strings.TrimSpace(string(enc)), "\n", "\n        ") + "\n"
+	plain, err := Decrypt([]byte(content), keyFile)
+	require.NoError(t, err)
+	assertSameYAML(t, `
+app:
+  secret/db:
+    - op: add
+      path: /stringData/password
+      value: s3cr$t
+`, string(plain))
+}
+
+func TestDecrypt_Plain(t *testing.T) {
+	t.Setenv(KeyFileEnv, "/nonexistent")
+	plain, err := Decrypt([]byte(plainPatch), "")
+	require.NoError(t, err)
+	assert.Equal(t, plainPatch, string(plain))
+}
+
+func TestLoadIdentities(t *testing.T) {
+	keyFile, _ := newKey(t)
+	keys, err := os.ReadFile(keyFile)
+	require.NoError(t, err)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	tests := []struct {
+		name    string
+		keyFile string
+		env     map[string]string
+		wantErr bool
+	}{
+		{name: "argument", keyFile: keyFile},
+		{name: "kubepatch env", env: map[string]string{KeyFileEnv: keyFile, SopsKeyFileEnv: "/nonexistent"}},
+		{name: "sops env", env: map[string]string{SopsKeyFileEnv: keyFile}},
+		{name: "sops key", env: map[string]string{SopsKeyEnv: string(keys)}},
+		{name: "missing file", keyFile: "/nonexistent", wantErr: true},
+		{name: "no key", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{KeyFileEnv, SopsKeyFileEnv, SopsKeyEnv} {
+				t.Setenv(name, tt.env[name])
+			}
+			ids, err := LoadIdentities(tt.keyFile)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, ids, 1)
+		})
+	}
+}
+
+func TestRecipientLines(t *testing.T) {
+	assert.Equal(t, []string{"age1a", "age1b"}, RecipientLines([]byte("# team\nage1a\n\n  age1b  \n")))
+}
+
+func TestDecryptValues(t *testing.T) {
+	keyFile, recipient := newKey(t)
+	enc, err := EncryptSops([]byte("password: s3cr$t\nport: 5432\nname_unencrypted: db\n"), []string{recipient}, SopsOptions{})
+	require.NoError(t, err)
+
+	_, values, err := DecryptValues(enc, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s3cr$t"}, values)
+
+	enc, err = EncryptSops([]byte(plainPatch), []string{recipient}, SopsOptions{})
+	require.NoError(t, err)
+	_, values, err = DecryptValues(enc, keyFile)
+	require.NoError(t, err)
+	// neither the op and path fields, nor numbers and booleans
+	assert.ElementsMatch(t, []string{"PGPASS", "s3cr$t", "DEBUG", "false"}, values)
+
+	recipients, err := ParseRecipients([]string{recipient})
+	require.NoError(t, err)
+	armored, err := EncryptAge([]byte("hunter2"), recipients)
+	require.NoError(t, err)
+	content := "password: |\n  " + strings.ReplaceAll(strings.TrimSpace(string(armored)), "\n", "\n  ") + "\n"
+	_, values, err = DecryptValues([]byte(content), keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hunter2"}, values)
+
+	whole, err := EncryptAge([]byte(plainPatch), recipients)
+	require.NoError(t, err)
+	_, values, err = DecryptValues(whole, keyFile)
+	require.NoError(t, err)
+	// the string leaves of operation values only
+	assert.ElementsMatch(t, []string{"PGPASS", "s3cr$t", "DEBUG", "false", ""}, values)
+
+	_, values, err = DecryptValues([]byte(plainPatch), keyFile)
+	require.NoError(t, err)
+	assert.Nil(t, values)
+}
@@ -0,0 +1,475 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+// sopsKey is the top-level key of the SOPS metadata.
+const sopsKey = "sops"
+
+// sopsVersion is the SOPS file format version written by EncryptSops.
+const sopsVersion = "3.9.0"
+
+// macOnlyEncryptedInit starts the MAC of files with mac_only_encrypted set, as in SOPS.
+var macOnlyEncryptedInit = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0xb, 0xb, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+
+// defaultUnencryptedSuffix is the SOPS default: values below keys with this suffix are not encrypted.
+const defaultUnencryptedSuffix = "_unencrypted"
+
+var (
+	sopsMetadataRegex = regexp.MustCompile(`(?m)^sops:`)
+	sopsValueRegex    = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.+),iv:(.+),tag:(.+),type:(.+)\]$`)
+)
+
+// SopsOptions select the values EncryptSops encrypts. At most one field may be set;
+// by default every value is encrypted, except below keys ending in "_unencrypted".
+type SopsOptions struct {
+	// EncryptedRegex encrypts only the values below keys matching it, e.g. "^value$".
+	EncryptedRegex string
+	// UnencryptedRegex encrypts every value except those below keys matching it.
+	UnencryptedRegex string
+}
+
+// sopsAgeKey is the data key encrypted to one age recipient.
+type sopsAgeKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+// sopsMetadata is the part of the "sops" section kubepatch uses.
+type sopsMetadata struct {
+	Age               []sopsAgeKey `yaml:"age"`
+	LastModified      string       `yaml:"lastmodified"`
+	Mac               string       `yaml:"mac"`
+	UnencryptedSuffix string       `yaml:"unencrypted_suffix"`
+	EncryptedSuffix   string       `yaml:"encrypted_suffix"`
+	UnencryptedRegex  string       `yaml:"unencrypted_regex"`
+	EncryptedRegex    string       `yaml:"encrypted_regex"`
+	MacOnlyEncrypted  bool         `yaml:"mac_only_encrypted"`
+}
+
+// encrypted tells whether the value at path is encrypted, following the rules of SOPS.
+func (m *sopsMetadata) encrypted(path []string) (bool, error) {
+	encrypted := true
+	if m.UnencryptedSuffix != "" {
+		for _, k := range path {
+			if strings.HasSuffix(k, m.UnencryptedSuffix) {
+				encrypted = false
+				break
+			}
+		}
+	}
+	if m.EncryptedSuffix != "" {
+		encrypted = false
+		for _, k := range path {
+			if strings.HasSuffix(k, m.EncryptedSuffix) {
+				encrypted = true
+				break
+			}
+		}
+	}
+	if m.UnencryptedRegex != "" {
+		re, err := regexp.Compile(m.UnencryptedRegex)
+		if err != nil {
+			return false, err
+		}
+		for _, k := range path {
+			if re.MatchString(k) {
+				encrypted = false
+				break
+			}
+		}
+	}
+	if m.EncryptedRegex != "" {
+		re, err := regexp.Compile(m.EncryptedRegex)
+		if err != nil {
+			return false, err
+		}
+		encrypted = false
+		for _, k := range path {
+			if re.MatchString(k) {
+				encrypted = true
+				break
+			}
+		}
+	}
+	return encrypted, nil
+}
+
+func isSops(data []byte) bool {
+	return sopsMetadataRegex.Match(data)
+}
+
+// splitSops separates the document content from its sops metadata.
+func splitSops(data []byte) (*yaml.Node, *sopsMetadata, error) {
+	docs, err := decodeYAML(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(docs) != 1 || len(docs[0].Content) != 1 || docs[0].Content[0].Kind != yaml.MappingNode {
+		return nil, nil, errors.New("a SOPS file must be a single YAML mapping")
+	}
+	root := docs[0].Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != sopsKey {
+			continue
+		}
+		meta := &sopsMetadata{}
+		if err := root.Content[i+1].Decode(meta); err != nil {
+			return nil, nil, fmt.Errorf("invalid sops metadata: %w", err)
+		}
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+		return docs[0], meta, nil
+	}
+	return nil, nil, errors.New("missing sops metadata")
+}
+
+// decryptSops decrypts the values of a SOPS document and verifies its MAC. It
+// also returns the decrypted strings that may be secrets, see secretPath.
+func decryptSops(data []byte, identities []age.Identity) ([]byte, []string, error) {
+	doc, meta, err := splitSops(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(meta.Age) == 0 {
+		return nil, nil, errors.New("the SOPS file has no age recipients, other key types are not supported")
+	}
+	key, err := sopsDataKey(meta, identities)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var values []string
+	hash := sha512.New()
+	if meta.MacOnlyEncrypted {
+		hash.Write(macOnlyEncryptedInit)
+	}
+	err = walkScalars(doc, nil, func(n *yaml.Node, path []string) error {
+		encrypted, err := meta.encrypted(path)
+		if err != nil {
+			return err
+		}
+		value, err := scalarValue(n)
+		if err != nil {
+			return err
+		}
+		if encrypted && value != nil && value != "" {
+			s, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("%s: value is not encrypted", strings.Join(path, "."))
+			}
+			if value, err = decryptSopsValue(s, key, sopsAdditionalData(path)); err != nil {
+				return fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+			}
+			if err := replaceScalar(n, value); err != nil {
+				return err
+			}
+			if s, ok := value.(string); ok && secretPath(path) {
+				values = append(values, s)
+			}
+		}
+		if value != nil && (encrypted || !meta.MacOnlyEncrypted) {
+			hash.Write(sopsBytes(value))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lastModified, err := time.Parse(time.RFC3339, meta.LastModified)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid sops lastmodified: %w", err)
+	}
+	mac, err := decryptSopsValue(meta.Mac, key, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid sops MAC: %w", err)
+	}
+	macString, ok := mac.(string)
+	want := fmt.Sprintf("%X", hash.Sum(nil))
+	if !ok || subtle.ConstantTimeCompare([]byte(macString), []byte(want)) != 1 {
+		return nil, nil, errors.New("sops MAC mismatch, the file was modified after it was encrypted")
+	}
+	plain, err := encodeYAML([]*yaml.Node{doc})
+	return plain, values, err
+}
+
+// sopsDataKey decrypts the data key of the first age entry an identity matches.
+func sopsDataKey(meta *sopsMetadata, identities []age.Identity) ([]byte, error) {
+	var errs []error
+	for _, entry := range meta.Age {
+		key, err := decryptAge(strings.NewReader(entry.Enc), identities)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Recipient, err))
+			continue
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%s: invalid data key", entry.Recipient)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("no age identity can decrypt the SOPS data key: %w", errors.Join(errs...))
+}
+
+// EncryptSops encrypts the values of a YAML mapping as a SOPS document for
+// the recipients, which must be given as strings like "age1...".
+func EncryptSops(data []byte, recipients []string, opts SopsOptions) ([]byte, error) {
+	if opts.EncryptedRegex != "" && opts.UnencryptedRegex != "" {
+		return nil, errors.New("only one of the encrypted and unencrypted regex can be set")
+	}
+	if isSops(data) {
+		return nil, errors.New("the file is already encrypted with SOPS")
+	}
+	docs, err := decodeYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) != 1 || len(docs[0].Content) != 1 || docs[0].Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("only a single YAML mapping can be encrypted with SOPS")
+	}
+	doc := docs[0]
+
+	meta := &sopsMetadata{
+		UnencryptedRegex: opts.UnencryptedRegex,
+		EncryptedRegex:   opts.EncryptedRegex,
+		LastModified:     time.Now().UTC().Format(time.RFC3339),
+	}
+	if opts.EncryptedRegex == "" && opts.UnencryptedRegex == "" {
+		meta.UnencryptedSuffix = defaultUnencryptedSuffix
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	for _, r := range recipients {
+		parsed, err := ParseRecipients([]string{r})
+		if err != nil {
+			return nil, err
+		}
+		enc, err := EncryptAge(key, parsed)
+		if err != nil {
+			return nil, err
+		}
+		meta.Age = append(meta.Age, sopsAgeKey{Recipient: r, Enc: string(enc)})
+	}
+	if len(meta.Age) == 0 {
+		return nil, errors.New("at least one age recipient is required")
+	}
+
+	hash := sha512.New()
+	if meta.MacOnlyEncrypted {
+		hash.Write(macOnlyEncryptedInit)
+	}
+	err = walkScalars(doc, nil, func(n *yaml.Node, path []string) error {
+		encrypted, err := meta.encrypted(path)
+		if err != nil {
+			return err
+		}
+		value, err := scalarValue(n)
+		if err != nil || value == nil {
+			return err
+		}
+		if encrypted || !meta.MacOnlyEncrypted {
+			hash.Write(sopsBytes(value))
+		}
+		if !encrypted || value == "" {
+			return nil
+		}
+		enc, err := encryptSopsValue(value, key, sopsAdditionalData(path))
+		if err != nil {
+			return err
+		}
+		return replaceScalar(n, enc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if meta.Mac, err = encryptSopsValue(fmt.Sprintf("%X", hash.Sum(nil)), key, meta.LastModified); err != nil {
+		return nil, err
+	}
+
+	section, err := meta.node()
+	if err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: sopsKey}, section)
+	return encodeYAML([]*yaml.Node{doc})
+}
+
+// node returns the metadata in the key order of SOPS, without unset fields.
+func (m *sopsMetadata) node() (*yaml.Node, error) {
+	n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	var err error
+	add := func(key string, value interface{}) {
+		v := &yaml.Node{}
+		if e := v.Encode(value); e != nil {
+			err = e
+			return
+		}
+		n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	}
+	add("age", m.Age)
+	add("lastmodified", m.LastModified)
+	add("mac", m.Mac)
+	for _, f := range []struct{ key, value string }{
+		{"unencrypted_suffix", m.UnencryptedSuffix},
+		{"encrypted_suffix", m.EncryptedSuffix},
+		{"unencrypted_regex", m.UnencryptedRegex},
+		{"encrypted_regex", m.EncryptedRegex},
+	} {
+		if f.value != "" {
+			add(f.key, f.value)
+		}
+	}
+	if m.MacOnlyEncrypted {
+		add("mac_only_encrypted", true)
+	}
+	add("version", sopsVersion)
+	return n, err
+}
+
+// sopsAdditionalData binds an encrypted value to its path, e.g. "app:deployment/app:value:".
+func sopsAdditionalData(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
+
+// scalarValue decodes a scalar like SOPS does, into a string, int, float64,
+// bool, time.Time or nil.
+func scalarValue(n *yaml.Node) (interface{}, error) {
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return nil, err
+	}
+	switch v.(type) {
+	case nil, string, int, float64, bool, time.Time:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %q", n.Value)
+	}
+}
+
+// sopsBytes formats a value the way SOPS encrypts and hashes it.
+func sopsBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case int:
+		return []byte(strconv.Itoa(v))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		if v {
+			return []byte("True")
+		}
+		return []byte("False")
+	case time.Time:
+		return []byte(v.Format(time.RFC3339Nano))
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+func sopsType(value interface{}) string {
+	switch value.(type) {
+	case int:
+		return "int"
+	case float64:
+		return "float"
+	case bool:
+		return "bool"
+	case time.Time:
+		return "time"
+	default:
+		return "str"
+	}
+}
+
+func encryptSopsValue(value interface{}, key []byte, additionalData string) (string, error) {
+	gcm, iv, err := sopsCipher(key, nil)
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, sopsBytes(value), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+		sopsType(value)), nil
+}
+
+func decryptSopsValue(value string, key []byte, additionalData string) (interface{}, error) {
+	m := sopsValueRegex.FindStringSubmatch(value)
+	if m == nil {
+		return nil, errors.New("value is not in the SOPS format ENC[AES256_GCM,...]")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		b, err := base64.StdEncoding.DecodeString(m[i+1])
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = b
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	gcm, _, err := sopsCipher(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, errors.New("decryption failed, the value was modified or moved")
+	}
+	s := string(plain)
+	switch m[4] {
+	case "str", "bytes", "comment":
+		return s, nil
+	case "int":
+		return strconv.Atoi(s)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	case "time":
+		return time.Parse(time.RFC3339Nano, s)
+	default:
+		return nil, fmt.Errorf("unknown SOPS value type %q", m[4])
+	}
+}
+
+// sopsCipher returns AES-GCM with the 32 byte nonces of SOPS, and a random nonce if iv is nil.
+func sopsCipher(key, iv []byte) (cipher.AEAD, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if iv == nil {
+		iv = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(iv) == 0 {
+		return nil, nil, errors.New("empty iv")
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, nil, err
+	}
+	return gcm, iv, nil
+}
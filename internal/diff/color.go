@@ -0,0 +1,46 @@
+package diff
+
+import "strings"
+
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// Colorize adds ANSI colours to the output of Unified and FormatChanges.
+func Colorize(text string) string {
+	lines := strings.SplitAfter(text, "\n")
+	var b strings.Builder
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		body := strings.TrimSuffix(line, "\n")
+		color := ""
+		switch {
+		case strings.HasPrefix(body, "---"), strings.HasPrefix(body, "+++"):
+			color = colorBold
+		case strings.HasPrefix(body, "@@"):
+			color = colorCyan
+		case strings.HasPrefix(body, "+"):
+			color = colorGreen
+		case strings.HasPrefix(body, "-"):
+			color = colorRed
+		case strings.HasPrefix(body, "~"):
+			color = colorYellow
+		}
+		if color == "" {
+			b.WriteString(line)
+			continue
+		}
+		b.WriteString(color + body + colorReset)
+		if len(body) < len(line) {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
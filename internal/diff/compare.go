@@ -0,0 +1,87 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Comparison formats supported by WriteComparison.
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+// ObjectChanges are the fields that differ between two renders of the same base object.
+// Old values of the changes belong to the left render, new values to the right one.
+type ObjectChanges struct {
+	Base    string   `json:"base"`
+	Left    string   `json:"left"`
+	Right   string   `json:"right"`
+	Changes []Change `json:"changes"`
+}
+
+// WriteComparison prints objects in the given format. leftName and
+// rightName label the two renders, e.g. "dev" and "prod".
+func WriteComparison(w io.Writer, format, leftName, rightName string, objects []ObjectChanges) error {
+	var b strings.Builder
+	switch format {
+	case FormatText:
+		if len(objects) == 0 {
+			b.WriteString("no differences\n")
+		}
+		for i, o := range objects {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			fmt.Fprintf(&b, "# %s (%s: %s, %s: %s)\n", o.Base, leftName, o.Left, rightName, o.Right)
+			for _, c := range o.Changes {
+				fmt.Fprintf(&b, "%s\n  %s: %s\n  %s: %s\n", c.Path, leftName, sideValue(c.Old, c.Type != Added),
+					rightName, sideValue(c.New, c.Type != Removed))
+			}
+		}
+	case FormatJSON:
+		if objects == nil {
+			objects = []ObjectChanges{}
+		}
+		out, err := json.MarshalIndent(objects, "", "  ")
+		if err != nil {
+			return err
+		}
+		b.Write(out)
+		b.WriteByte('\n')
+	case FormatMarkdown:
+		fmt.Fprintf(&b, "| Object | Field | %s | %s |\n", escapeCell(leftName), escapeCell(rightName))
+		b.WriteString("|---|---|---|---|\n")
+		for _, o := range objects {
+			for _, c := range o.Changes {
+				fmt.Fprintf(&b, "| %s | `%s` | %s | %s |\n", escapeCell(o.Base), escapeCell(c.Path),
+					markdownValue(c.Old, c.Type != Added), markdownValue(c.New, c.Type != Removed))
+			}
+		}
+	default:
+		return fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatText, FormatJSON, FormatMarkdown)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sideValue(v interface{}, present bool) string {
+	if !present {
+		return "<unset>"
+	}
+	return FormatValue(v)
+}
+
+func markdownValue(v interface{}, present bool) string {
+	if !present {
+		return "_unset_"
+	}
+	return "`" + escapeCell(FormatValue(v)) + "`"
+}
+
+func escapeCell(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
@@ -0,0 +1,79 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteComparison(t *testing.T) {
+	objects := []ObjectChanges{{
+		Base:  "deployment/web",
+		Left:  "deployment/web-dev",
+		Right: "deployment/web-prod",
+		Changes: []Change{
+			{Path: "/metadata/annotations/a|b", Type: Added, New: "x"},
+			{Path: "/spec/replicas", Type: Modified, Old: int64(1), New: int64(3)},
+		},
+	}}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: FormatText,
+			expected: "# deployment/web (dev: deployment/web-dev, prod: deployment/web-prod)\n" +
+				"/metadata/annotations/a|b\n  dev: <unset>\n  prod: \"x\"\n" +
+				"/spec/replicas\n  dev: 1\n  prod: 3\n",
+		},
+		{
+			format: FormatMarkdown,
+			expected: "| Object | Field | dev | prod |\n|---|---|---|---|\n" +
+				"| deployment/web | `/metadata/annotations/a\\|b` | _unset_ | `\"x\"` |\n" +
+				"| deployment/web | `/spec/replicas` | `1` | `3` |\n",
+		},
+		{
+			format: FormatJSON,
+			expected: `[
+  {
+    "base": "deployment/web",
+    "left": "deployment/web-dev",
+    "right": "deployment/web-prod",
+    "changes": [
+      {
+        "path": "/metadata/annotations/a|b",
+        "type": "added",
+        "new": "x"
+      },
+      {
+        "path": "/spec/replicas",
+        "type": "modified",
+        "old": 1,
+        "new": 3
+      }
+    ]
+  }
+]
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out strings.Builder
+			require.NoError(t, WriteComparison(&out, tt.format, "dev", "prod", objects))
+			assert.Equal(t, tt.expected, out.String())
+		})
+	}
+
+	var out strings.Builder
+	require.NoError(t, WriteComparison(&out, FormatText, "dev", "prod", nil))
+	assert.Equal(t, "no differences\n", out.String())
+	out.Reset()
+	require.NoError(t, WriteComparison(&out, FormatJSON, "dev", "prod", nil))
+	assert.Equal(t, "[]\n", out.String())
+
+	assert.Error(t, WriteComparison(&out, "html", "dev", "prod", objects))
+}
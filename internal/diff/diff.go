@@ -0,0 +1,170 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ChangeType tells how a field differs between two objects.
+type ChangeType string
+
+const (
+	Added    ChangeType = "added"
+	Removed  ChangeType = "removed"
+	Modified ChangeType = "modified"
+)
+
+// Change is a single field that differs between two objects.
+type Change struct {
+	// Path is the RFC6901 JSON pointer of the field.
+	Path string      `json:"path"`
+	Type ChangeType  `json:"type"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Objects returns the fields that differ between a and b, ordered by path.
+// Maps are compared key by key and lists element by element, so key order
+// in the source documents never shows up as a difference.
+func Objects(a, b map[string]interface{}) []Change {
+	var changes []Change
+	compare("", a, b, &changes)
+	return changes
+}
+
+func compare(path string, a, b interface{}, changes *[]Change) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			compareMaps(path, av, bv, changes)
+			return
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			compareLists(path, av, bv, changes)
+			return
+		}
+	}
+	if !equalValues(a, b) {
+		*changes = append(*changes, Change{Path: path, Type: Modified, Old: a, New: b})
+	}
+}
+
+func compareMaps(path string, a, b map[string]interface{}, changes *[]Change) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + escapePointer(k)
+		av, inA := a[k]
+		bv, inB := b[k]
+		switch {
+		case !inB:
+			*changes = append(*changes, Change{Path: p, Type: Removed, Old: av})
+		case !inA:
+			*changes = append(*changes, Change{Path: p, Type: Added, New: bv})
+		default:
+			compare(p, av, bv, changes)
+		}
+	}
+}
+
+func compareLists(path string, a, b []interface{}, changes *[]Change) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		p := path + "/" + strconv.Itoa(i)
+		switch {
+		case i >= len(b):
+			*changes = append(*changes, Change{Path: p, Type: Removed, Old: a[i]})
+		case i >= len(a):
+			*changes = append(*changes, Change{Path: p, Type: Added, New: b[i]})
+		default:
+			compare(p, a[i], b[i], changes)
+		}
+	}
+}
+
+// equalValues compares scalars by their JSON form, so that e.g. int64(1)
+// and float64(1) decoded from different sources are equal.
+func equalValues(a, b interface{}) bool {
+	aj, errA := json.Marshal(a)
+	bj, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return fmt.Sprintf("%#v", a) == fmt.Sprintf("%#v", b)
+	}
+	return string(aj) == string(bj)
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+// Stat counts changes by type.
+type Stat struct {
+	Added, Removed, Modified int
+}
+
+// Count returns the number of changes of every type.
+func Count(changes []Change) Stat {
+	var s Stat
+	for _, c := range changes {
+		switch c.Type {
+		case Added:
+			s.Added++
+		case Removed:
+			s.Removed++
+		case Modified:
+			s.Modified++
+		}
+	}
+	return s
+}
+
+// Total is the number of changed fields.
+func (s Stat) Total() int {
+	return s.Added + s.Removed + s.Modified
+}
+
+// String formats the stat like "3 fields changed (+1 -0 ~2)".
+func (s Stat) String() string {
+	noun := "fields"
+	if s.Total() == 1 {
+		noun = "field"
+	}
+	return fmt.Sprintf("%d %s changed (+%d -%d ~%d)", s.Total(), noun, s.Added, s.Removed, s.Modified)
+}
+
+// FormatChanges prints changes one per line: "+" added, "-" removed, "~" modified.
+func FormatChanges(changes []Change) string {
+	var b strings.Builder
+	for _, c := range changes {
+		switch c.Type {
+		case Added:
+			fmt.Fprintf(&b, "+ %s: %s\n", c.Path, FormatValue(c.New))
+		case Removed:
+			fmt.Fprintf(&b, "- %s: %s\n", c.Path, FormatValue(c.Old))
+		case Modified:
+			fmt.Fprintf(&b, "~ %s: %s -> %s\n", c.Path, FormatValue(c.Old), FormatValue(c.New))
+		}
+	}
+	return b.String()
+}
+
+// FormatValue renders a value as compact JSON.
+func FormatValue(v interface{}) string {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(out)
+}
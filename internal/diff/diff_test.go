@@ -0,0 +1,210 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestObjects(t *testing.T) {
+	a := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "web", "a/b": "x"},
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+			"ports":    []interface{}{int64(80), int64(443)},
+		},
+	}
+	b := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "web-prod", "labels": map[string]interface{}{"app": "web"}},
+		"spec": map[string]interface{}{
+			"replicas": float64(1),
+			"ports":    []interface{}{int64(8080)},
+		},
+	}
+
+	assert.Equal(t, []Change{
+		{Path: "/metadata/a~1b", Type: Removed, Old: "x"},
+		{Path: "/metadata/labels", Type: Added, New: map[string]interface{}{"app": "web"}},
+		{Path: "/metadata/name", Type: Modified, Old: "web", New: "web-prod"},
+		{Path: "/spec/ports/0", Type: Modified, Old: int64(80), New: int64(8080)},
+		{Path: "/spec/ports/1", Type: Removed, Old: int64(443)},
+	}, Objects(a, b))
+
+	assert.Empty(t, Objects(a, a))
+}
+
+func TestCount(t *testing.T) {
+	s := Count([]Change{{Type: Added}, {Type: Modified}, {Type: Modified}})
+	assert.Equal(t, Stat{Added: 1, Modified: 2}, s)
+	assert.Equal(t, "3 fields changed (+1 -0 ~2)", s.String())
+	assert.Equal(t, "1 field changed (+0 -1 ~0)", Stat{Removed: 1}.String())
+}
+
+func TestFormatChanges(t *testing.T) {
+	out := FormatChanges([]Change{
+		{Path: "/a", Type: Added, New: "x"},
+		{Path: "/b", Type: Removed, Old: int64(1)},
+		{Path: "/c", Type: Modified, Old: true, New: false},
+	})
+	assert.Equal(t, "+ /a: \"x\"\n- /b: 1\n~ /c: true -> false\n", out)
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		context  int
+		expected string
+	}{
+		{
+			name: "equal",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+		},
+		{
+			name:    "modified line",
+			a:       "a\nb\nc\nd\ne\n",
+			b:       "a\nb\nX\nd\ne\n",
+			context: 1,
+			expected: "--- base\n+++ rendered\n" +
+				"@@ -2,3 +2,3 @@\n b\n-c\n+X\n d\n",
+		},
+		{
+			name:    "separate hunks",
+			a:       "1\n2\n3\n4\n5\n6\n7\n",
+			b:       "0\n1\n2\n3\n4\n5\n6\n",
+			context: 1,
+			expected: "--- base\n+++ rendered\n" +
+				"@@ -1 +1,2 @@\n+0\n 1\n" +
+				"@@ -6,2 +7 @@\n 6\n-7\n",
+		},
+		{
+			name:    "missing newline",
+			a:       "a\nb",
+			b:       "a\nc",
+			context: 3,
+			expected: "--- base\n+++ rendered\n" +
+				"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name:    "from empty",
+			a:       "",
+			b:       "a\n",
+			context: 3,
+			expected: "--- base\n+++ rendered\n" +
+				"@@ -0,0 +1 @@\n+a\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Unified("base", "rendered", tt.a, tt.b, tt.context))
+		})
+	}
+}
+
+func TestColorize(t *testing.T) {
+	out := Colorize("--- a\n+++ b\n@@ -1 +1 @@\n-x\n+y\n same\n~ /c: 1 -> 2\n")
+	assert.Equal(t, colorBold+"--- a"+colorReset+"\n"+
+		colorBold+"+++ b"+colorReset+"\n"+
+		colorCyan+"@@ -1 +1 @@"+colorReset+"\n"+
+		colorRed+"-x"+colorReset+"\n"+
+		colorGreen+"+y"+colorReset+"\n"+
+		" same\n"+
+		colorYellow+"~ /c: 1 -> 2"+colorReset+"\n", out)
+}
+
+func TestWrite(t *testing.T) {
+	from := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind":     "ConfigMap",
+		"metadata": map[string]interface{}{"name": "cm"},
+		"data":     map[string]interface{}{"a": "1", "b": "2"},
+	}}
+	to := from.DeepCopy()
+	to.Object["data"] = map[string]interface{}{"a": "1", "b": "3"}
+	pairs := []Pair{
+		{FromName: "configmap/cm (base)", ToName: "configmap/cm (rendered)", From: from, To: to},
+		{FromName: "configmap/same", ToName: "configmap/same", From: from, To: from},
+		{ToName: "configmap/new", To: from},
+	}
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name: "unified",
+			opts: Options{Context: 1},
+			expected: "--- configmap/cm (base)\n+++ configmap/cm (rendered)\n" +
+				"@@ -2,3 +2,3 @@\n   a: \"1\"\n-  b: \"2\"\n+  b: \"3\"\n kind: ConfigMap\n" +
+				"--- /dev/null\n+++ configmap/new\n" +
+				"@@ -0,0 +1,6 @@\n+data:\n+  a: \"1\"\n+  b: \"2\"\n+kind: ConfigMap\n+metadata:\n+  name: cm\n",
+		},
+		{
+			name: "structural",
+			opts: Options{Structural: true},
+			expected: "--- configmap/cm (base)\n+++ configmap/cm (rendered)\n" +
+				"~ /data/b: \"2\" -> \"3\"\n" +
+				"--- /dev/null\n+++ configmap/new\n" +
+				"+ : {\"data\":{\"a\":\"1\",\"b\":\"2\"},\"kind\":\"ConfigMap\",\"metadata\":{\"name\":\"cm\"}}\n",
+		},
+		{
+			name: "stat",
+			opts: Options{Stat: true},
+			expected: "configmap/cm (rendered) | 1 field changed (+0 -0 ~1)\n" +
+				"configmap/new | 1 field changed (+1 -0 ~0)\n" +
+				"2 of 3 objects changed, 2 fields changed (+1 -0 ~1)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			changed, err := Write(&out, pairs, tt.opts)
+			require.NoError(t, err)
+			assert.True(t, changed)
+			assert.Equal(t, tt.expected, out.String())
+		})
+	}
+
+	var out strings.Builder
+	changed, err := Write(&out, pairs[1:2], Options{})
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Empty(t, out.String())
+}
+
+func TestMatch(t *testing.T) {
+	obj := func(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion(apiVersion)
+		u.SetKind(kind)
+		u.SetNamespace(namespace)
+		u.SetName(name)
+		return u
+	}
+	from := []*unstructured.Unstructured{
+		obj("v1", "Service", "", "web"),
+		obj("apps/v1", "Deployment", "", "web"),
+		obj("v1", "ConfigMap", "", "old"),
+	}
+	to := []*unstructured.Unstructured{
+		obj("apps/v1", "Deployment", "", "web"),
+		obj("v1", "ConfigMap", "", "new"),
+		obj("v1", "Service", "prod", "web"),
+		obj("v1", "Service", "", "web"),
+	}
+
+	pairs := Match(from, to, "main", "rendered")
+	require.Len(t, pairs, 5)
+	assert.Same(t, from[1], pairs[0].From)
+	assert.Equal(t, "deployment/web (main)", pairs[0].FromName)
+	assert.Equal(t, "deployment/web (rendered)", pairs[0].ToName)
+	assert.Nil(t, pairs[1].From)
+	assert.Nil(t, pairs[2].From, "a namespace makes a different object")
+	assert.Same(t, from[0], pairs[3].From)
+	assert.Same(t, from[2], pairs[4].From)
+	assert.Nil(t, pairs[4].To)
+}
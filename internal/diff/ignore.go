@@ -0,0 +1,111 @@
+package diff
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kubepatch/kubepatch/internal/unstr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// IgnoreRule removes a field before objects are compared.
+type IgnoreRule struct {
+	// Kind limits the rule to objects of this kind, case-insensitive; empty matches all kinds.
+	Kind string
+	// Path is an RFC6901 JSON pointer; a "*" segment matches every key or list index.
+	Path string
+}
+
+// ServerFields ignore the fields populated by the API server, see unstr.ServerFields.
+var ServerFields = serverFieldRules()
+
+func serverFieldRules() []IgnoreRule {
+	rules := make([]IgnoreRule, len(unstr.ServerFields))
+	for i, field := range unstr.ServerFields {
+		segments := make([]string, len(field))
+		for k, s := range field {
+			segments[k] = strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+		}
+		rules[i] = IgnoreRule{Path: "/" + strings.Join(segments, "/")}
+	}
+	return rules
+}
+
+// ParseIgnoreRule parses "[Kind:]/json/pointer", e.g. "Deployment:/spec/progressDeadlineSeconds".
+func ParseIgnoreRule(s string) (IgnoreRule, error) {
+	var r IgnoreRule
+	if i := strings.Index(s, ":"); i >= 0 && !strings.HasPrefix(s, "/") {
+		r.Kind, s = s[:i], s[i+1:]
+	}
+	if !strings.HasPrefix(s, "/") {
+		return IgnoreRule{}, fmt.Errorf("invalid ignored field %q, expected [Kind:]/json/pointer", s)
+	}
+	r.Path = s
+	return r, nil
+}
+
+// Strip returns a copy of obj without the fields matched by rules.
+func Strip(obj *unstructured.Unstructured, rules []IgnoreRule) *unstructured.Unstructured {
+	out := obj.DeepCopy()
+	for _, r := range rules {
+		if r.Kind != "" && !strings.EqualFold(r.Kind, obj.GetKind()) {
+			continue
+		}
+		removePointer(out.Object, pointerSegments(r.Path))
+	}
+	return out
+}
+
+func pointerSegments(path string) []string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, p := range parts {
+		parts[i] = strings.ReplaceAll(strings.ReplaceAll(p, "~1", "/"), "~0", "~")
+	}
+	return parts
+}
+
+func removePointer(node interface{}, segments []string) {
+	if len(segments) == 0 {
+		return
+	}
+	seg, rest := segments[0], segments[1:]
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if seg == "*" {
+			for k := range v {
+				removeChild(v, k, rest)
+			}
+			return
+		}
+		if _, ok := v[seg]; ok {
+			removeChild(v, seg, rest)
+		}
+	case []interface{}:
+		// list elements are never removed, only fields inside of them
+		if len(rest) == 0 {
+			return
+		}
+		if seg == "*" {
+			for _, item := range v {
+				removePointer(item, rest)
+			}
+			return
+		}
+		if i, err := strconv.Atoi(seg); err == nil && i >= 0 && i < len(v) {
+			removePointer(v[i], rest)
+		}
+	}
+}
+
+func removeChild(m map[string]interface{}, key string, rest []string) {
+	if len(rest) == 0 {
+		delete(m, key)
+		return
+	}
+	removePointer(m[key], rest)
+	// drop maps emptied by the removal, e.g. metadata.annotations
+	if child, ok := m[key].(map[string]interface{}); ok && len(child) == 0 {
+		delete(m, key)
+	}
+}
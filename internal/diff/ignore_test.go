@@ -0,0 +1,118 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/kubepatch/kubepatch/internal/unstr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func mustObj(t *testing.T, s string) *unstructured.Unstructured {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	require.NoError(t, yaml.Unmarshal([]byte(s), &obj.Object))
+	return obj
+}
+
+func TestParseIgnoreRule(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected IgnoreRule
+		wantErr  bool
+	}{
+		{in: "/spec/x", expected: IgnoreRule{Path: "/spec/x"}},
+		{in: "Deployment:/spec/x", expected: IgnoreRule{Kind: "Deployment", Path: "/spec/x"}},
+		{in: "/metadata/annotations/a:b", expected: IgnoreRule{Path: "/metadata/annotations/a:b"}},
+		{in: "spec.x", wantErr: true},
+		{in: "Deployment:spec", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, err := ParseIgnoreRule(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, r)
+		})
+	}
+}
+
+func TestServerFields(t *testing.T) {
+	assert.Len(t, ServerFields, len(unstr.ServerFields))
+	assert.Contains(t, ServerFields, IgnoreRule{Path: "/status"})
+	assert.Contains(t, ServerFields, IgnoreRule{Path: "/metadata/managedFields"})
+}
+
+func TestStrip(t *testing.T) {
+	live := mustObj(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  uid: 123
+  resourceVersion: "42"
+  creationTimestamp: "2024-01-01T00:00:00Z"
+  annotations:
+    deployment.kubernetes.io/revision: "3"
+spec:
+  progressDeadlineSeconds: 600
+  template:
+    spec:
+      containers:
+        - name: web
+          terminationMessagePath: /dev/termination-log
+        - name: sidecar
+          terminationMessagePath: /dev/termination-log
+status:
+  replicas: 1
+`)
+	rules := append([]IgnoreRule{
+		{Kind: "deployment", Path: "/spec/progressDeadlineSeconds"},
+		{Kind: "StatefulSet", Path: "/spec/template"},
+		{Path: "/spec/template/spec/containers/*/terminationMessagePath"},
+		{Path: "/metadata/annotations/deployment.kubernetes.io~1revision"},
+		{Path: "/spec/missing/field"},
+	}, ServerFields...)
+
+	stripped := Strip(live, rules)
+	assert.Equal(t, mustObj(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+        - name: web
+        - name: sidecar
+`).Object, stripped.Object)
+	assert.Contains(t, live.Object, "status", "the input is not modified")
+}
+
+func TestMatchLive(t *testing.T) {
+	live := []*unstructured.Unstructured{
+		mustObj(t, "{apiVersion: v1, kind: Service, metadata: {name: web, namespace: prod}}"),
+		mustObj(t, "{apiVersion: v1, kind: Service, metadata: {name: other, namespace: prod}}"),
+		mustObj(t, "{apiVersion: v1, kind: ConfigMap, metadata: {name: cfg, namespace: prod}}"),
+	}
+	rendered := []*unstructured.Unstructured{
+		mustObj(t, "{apiVersion: v1, kind: Service, metadata: {name: web}}"),
+		mustObj(t, "{apiVersion: v1, kind: ConfigMap, metadata: {name: cfg, namespace: dev}}"),
+		mustObj(t, "{apiVersion: v1, kind: Secret, metadata: {name: new}}"),
+	}
+
+	pairs := MatchLive(live, rendered, "cluster", "rendered")
+	require.Len(t, pairs, 3)
+	require.NotNil(t, pairs[0].From)
+	assert.Equal(t, "service/web (cluster)", pairs[0].FromName)
+	assert.Empty(t, pairs[0].From.GetNamespace(), "namespace is not compared when not rendered")
+	assert.Equal(t, "prod", live[0].GetNamespace())
+	assert.Nil(t, pairs[1].From, "an explicit namespace has to match")
+	assert.Nil(t, pairs[2].From)
+}
@@ -0,0 +1,192 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Pair is an object before and after a change. From is nil for an added
+// object and To is nil for a removed one.
+type Pair struct {
+	FromName, ToName string
+	From, To         *unstructured.Unstructured
+}
+
+// Options control how Write prints pairs.
+type Options struct {
+	// Structural prints changed fields instead of a unified diff of the YAML.
+	Structural bool
+	// Context is the number of unchanged lines around unified diff hunks.
+	Context int
+	Color   bool
+	// Stat prints a one-line summary per changed object instead of the diff.
+	Stat bool
+}
+
+// Changes returns the fields that differ within a pair.
+func (p Pair) Changes() []Change {
+	switch {
+	case p.From == nil && p.To == nil:
+		return nil
+	case p.From == nil:
+		return []Change{{Path: "", Type: Added, New: p.To.Object}}
+	case p.To == nil:
+		return []Change{{Path: "", Type: Removed, Old: p.From.Object}}
+	}
+	return Objects(p.From.Object, p.To.Object)
+}
+
+// Write prints the differences of all pairs and reports whether any pair differs.
+func Write(w io.Writer, pairs []Pair, opts Options) (bool, error) {
+	var b strings.Builder
+	changedObjects := 0
+	var total Stat
+	for _, p := range pairs {
+		changes := p.Changes()
+		if len(changes) == 0 {
+			continue
+		}
+		changedObjects++
+		if p.FromName == "" {
+			p.FromName = "/dev/null"
+		}
+		if p.ToName == "" {
+			p.ToName = "/dev/null"
+		}
+
+		if opts.Stat {
+			s := Count(changes)
+			total.Added += s.Added
+			total.Removed += s.Removed
+			total.Modified += s.Modified
+			fmt.Fprintf(&b, "%s | %s\n", statName(p), s)
+			continue
+		}
+
+		if opts.Structural {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", p.FromName, p.ToName)
+			b.WriteString(FormatChanges(changes))
+			continue
+		}
+
+		from, err := marshal(p.From)
+		if err != nil {
+			return false, err
+		}
+		to, err := marshal(p.To)
+		if err != nil {
+			return false, err
+		}
+		b.WriteString(Unified(p.FromName, p.ToName, from, to, opts.Context))
+	}
+
+	if opts.Stat {
+		fmt.Fprintf(&b, "%d of %d objects changed, %s\n", changedObjects, len(pairs), total)
+	}
+
+	out := b.String()
+	if opts.Color {
+		out = Colorize(out)
+	}
+	_, err := io.WriteString(w, out)
+	return changedObjects > 0, err
+}
+
+func statName(p Pair) string {
+	if p.To != nil {
+		return p.ToName
+	}
+	return p.FromName
+}
+
+func marshal(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	// sigs.k8s.io/yaml sorts map keys, so key order never shows up in the diff
+	out, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Identity is the key under which Match aligns objects: API group, kind, namespace and name.
+func Identity(obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+	return strings.ToLower(gvk.Group+"/"+gvk.Kind) + "/" + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// Match pairs objects of two renders by their Identity. Pairs follow the
+// order of to; objects only present in from are appended as removals.
+// fromLabel and toLabel are appended to the "kind/name" of the objects.
+func Match(from, to []*unstructured.Unstructured, fromLabel, toLabel string) []Pair {
+	byIdentity := map[string][]int{}
+	for i, obj := range from {
+		id := Identity(obj)
+		byIdentity[id] = append(byIdentity[id], i)
+	}
+
+	matched := make([]bool, len(from))
+	pairs := make([]Pair, 0, len(to))
+	for _, obj := range to {
+		p := Pair{ToName: label(obj, toLabel), To: obj}
+		id := Identity(obj)
+		if candidates := byIdentity[id]; len(candidates) > 0 {
+			i := candidates[0]
+			byIdentity[id] = candidates[1:]
+			matched[i] = true
+			p.FromName, p.From = label(from[i], fromLabel), from[i]
+		}
+		pairs = append(pairs, p)
+	}
+	for i, obj := range from {
+		if !matched[i] {
+			pairs = append(pairs, Pair{FromName: label(obj, fromLabel), From: obj})
+		}
+	}
+	return pairs
+}
+
+// MatchLive pairs rendered objects with the objects of a cluster snapshot.
+// A rendered object without a namespace matches a snapshot object in any
+// namespace, whose namespace is then not compared. Snapshot objects that
+// were not rendered are not reported: a snapshot usually holds more than
+// one application.
+func MatchLive(live, rendered []*unstructured.Unstructured, liveLabel, renderedLabel string) []Pair {
+	byIdentity := map[string]*unstructured.Unstructured{}
+	byName := map[string]*unstructured.Unstructured{}
+	for _, obj := range live {
+		byIdentity[Identity(obj)] = obj
+		withoutNamespace := obj.DeepCopy()
+		withoutNamespace.SetNamespace("")
+		if _, ok := byName[Identity(withoutNamespace)]; !ok {
+			byName[Identity(withoutNamespace)] = obj
+		}
+	}
+
+	pairs := make([]Pair, 0, len(rendered))
+	for _, obj := range rendered {
+		p := Pair{ToName: label(obj, renderedLabel), To: obj}
+		match, ok := byIdentity[Identity(obj)]
+		if !ok && obj.GetNamespace() == "" {
+			if match, ok = byName[Identity(obj)]; ok {
+				match = match.DeepCopy()
+				match.SetNamespace("")
+			}
+		}
+		if ok {
+			p.FromName, p.From = label(match, liveLabel), match
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs
+}
+
+func label(obj *unstructured.Unstructured, suffix string) string {
+	return fmt.Sprintf("%s/%s (%s)", strings.ToLower(obj.GetKind()), obj.GetName(), suffix)
+}
@@ -0,0 +1,132 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unified returns a unified diff of two texts with the given number of
+// context lines, or an empty string when they are equal.
+func Unified(fromName, toName, a, b string, context int) string {
+	if a == b {
+		return ""
+	}
+	al := splitLines(a)
+	bl := splitLines(b)
+	edits := lineEdits(al, bl)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for _, h := range hunks(edits, context) {
+		writeHunk(&out, edits[h[0]:h[1]])
+	}
+	return out.String()
+}
+
+type editOp byte
+
+const (
+	opEqual  editOp = ' '
+	opDelete editOp = '-'
+	opInsert editOp = '+'
+)
+
+type edit struct {
+	op         editOp
+	line       string
+	aPos, bPos int // 1-based line numbers in a and b before this edit
+}
+
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineEdits computes the shortest edit script from the longest common
+// subsequence of lines. Manifests are small, so the quadratic table is fine.
+func lineEdits(a, b []string) []edit {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		e := edit{aPos: i + 1, bPos: j + 1}
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			e.op, e.line = opEqual, a[i]
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			e.op, e.line = opDelete, a[i]
+			i++
+		default:
+			e.op, e.line = opInsert, b[j]
+			j++
+		}
+		edits = append(edits, e)
+	}
+	return edits
+}
+
+// hunks groups changed edits with their context into [start, end) ranges.
+func hunks(edits []edit, context int) [][2]int {
+	var out [][2]int
+	for i, e := range edits {
+		if e.op == opEqual {
+			continue
+		}
+		start := max(i-context, 0)
+		end := min(i+context+1, len(edits))
+		if n := len(out); n > 0 && start <= out[n-1][1] {
+			out[n-1][1] = end
+			continue
+		}
+		out = append(out, [2]int{start, end})
+	}
+	return out
+}
+
+func writeHunk(out *strings.Builder, edits []edit) {
+	aCount, bCount := 0, 0
+	for _, e := range edits {
+		if e.op != opInsert {
+			aCount++
+		}
+		if e.op != opDelete {
+			bCount++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(edits[0].aPos, aCount), hunkRange(edits[0].bPos, bCount))
+	for _, e := range edits {
+		out.WriteByte(byte(e.op))
+		out.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start-- // an empty range refers to the line before it
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
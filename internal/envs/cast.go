@@ -0,0 +1,60 @@
+package envs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Casts of placeholders like ${VAR|int}.
+const (
+	// CastInt requires an integer and substitutes a YAML integer.
+	CastInt = "int"
+	// CastBool requires a boolean like true, 1 or F and substitutes a YAML boolean.
+	CastBool = "bool"
+	// CastBase64 substitutes the value encoded as standard base64, e.g. for Secret data.
+	CastBase64 = "base64"
+	// CastJSON requires a JSON document and substitutes it as a YAML value.
+	CastJSON = "json"
+)
+
+func isCast(s string) bool {
+	switch s {
+	case CastInt, CastBool, CastBase64, CastJSON:
+		return true
+	default:
+		return false
+	}
+}
+
+// castValue validates value for cast and returns it in the canonical form of
+// the cast, e.g. "true" for a boolean "1" and compact JSON for a JSON document.
+func castValue(cast, value string) (string, error) {
+	switch cast {
+	case CastInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", errors.New("the value is not an integer")
+		}
+		return strconv.FormatInt(n, 10), nil
+	case CastBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", errors.New("the value is not a boolean")
+		}
+		return strconv.FormatBool(b), nil
+	case CastBase64:
+		return base64.StdEncoding.EncodeToString([]byte(value)), nil
+	case CastJSON:
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, []byte(value)); err != nil {
+			return "", fmt.Errorf("the value is not valid JSON: %w", err)
+		}
+		return buf.String(), nil
+	default:
+		return "", fmt.Errorf("unknown cast |%s, expected |%s, |%s, |%s or |%s", cast, CastInt, CastBool, CastBase64, CastJSON)
+	}
+}
@@ -0,0 +1,133 @@
+package envs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadEnvFiles reads dotenv files into one map; a variable of a later file
+// overrides the same variable of an earlier one.
+func ReadEnvFiles(paths []string) (map[string]string, error) {
+	env := map[string]string{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		values, err := ParseDotenv(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for k, v := range values {
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
+// ParseDotenv parses the variables of a dotenv file:
+//
+//	# comment
+//	export NAME=value       # an optional export, a trailing comment
+//	NAME='literal $value'   # single quotes: no escapes, may span lines
+//	NAME="line 1\nline 2"   # double quotes: \n, \r, \t, \", \\ and \$ escapes, may span lines
+//
+// Values are never expanded: ${VAR} in a value is kept as it is.
+func ParseDotenv(data string) (map[string]string, error) {
+	env := map[string]string{}
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	line := 1
+	for data != "" {
+		var (
+			name, value string
+			lines       int
+			err         error
+		)
+		name, value, data, lines, err = parseDotenvLine(data)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if name != "" {
+			env[name] = value
+		}
+		line += lines
+	}
+	return env, nil
+}
+
+// parseDotenvLine parses the first entry of data, which may span several lines
+// when quoted. It returns the variable, or an empty name for a blank or comment
+// line, the rest of data and the number of lines consumed.
+func parseDotenvLine(data string) (name, value, rest string, lines int, err error) {
+	line, rest, _ := strings.Cut(data, "\n")
+	lines = 1
+
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return "", "", rest, lines, nil
+	}
+	trimmed = strings.TrimPrefix(trimmed, "export ")
+
+	name, value, ok := strings.Cut(trimmed, "=")
+	name = strings.TrimSpace(name)
+	if !ok {
+		return "", "", "", 0, fmt.Errorf("expected NAME=value, got %q", trimmed)
+	}
+	if scanName(name) != name {
+		return "", "", "", 0, fmt.Errorf("invalid variable name %q", name)
+	}
+	value = strings.TrimLeft(value, " \t")
+
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		// unquoted: a comment starts with a # after a space
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		return name, strings.TrimSpace(value), rest, lines, nil
+	}
+
+	// quoted values continue up to the closing quote, across lines
+	quote := value[0]
+	text := value[1:] + "\n" + rest
+	if rest == "" && !strings.Contains(data, "\n") {
+		text = value[1:]
+	}
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == quote:
+			after, next, _ := strings.Cut(text[i+1:], "\n")
+			if after = strings.TrimSpace(after); after != "" && !strings.HasPrefix(after, "#") {
+				return "", "", "", 0, fmt.Errorf("unexpected %q after the quoted value of %s", after, name)
+			}
+			return name, b.String(), next, lines, nil
+		case c == '\n':
+			lines++
+			b.WriteByte(c)
+		case c == '\\' && quote == '"' && i+1 < len(text):
+			i++
+			b.WriteString(unescape(text[i]))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", "", 0, fmt.Errorf("unterminated quoted value of %s", name)
+}
+
+// unescape returns the character of the escape sequence \c in a double-quoted value.
+func unescape(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case '"', '\\', '$':
+		return string(c)
+	default:
+		return "\\" + string(c)
+	}
+}
@@ -0,0 +1,132 @@
+package envs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	input := `# database
+DB_HOST=db.local
+export DB_PORT = 5432
+DB_USER=app # the user
+DB_URL=postgres://db.local/app#main
+EMPTY=
+SINGLE='literal $VAR \n # not a comment'
+DOUBLE="tab\tquote\" dollar\$ newline\n"
+MULTI="-----BEGIN CERTIFICATE-----
+MIIB
+-----END CERTIFICATE-----"   # trailing comment
+SINGLE_MULTI='a
+b'
+
+AFTER=multi-line values do not swallow the next entry
+WINDOWS=crlf` + "\r\n" + `LAST=no trailing newline`
+
+	got, err := ParseDotenv(input)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := map[string]string{
+		"DB_HOST":      "db.local",
+		"DB_PORT":      "5432",
+		"DB_USER":      "app",
+		"DB_URL":       "postgres://db.local/app#main",
+		"EMPTY":        "",
+		"SINGLE":       `literal $VAR \n # not a comment`,
+		"DOUBLE":       "tab\tquote\" dollar$ newline\n",
+		"MULTI":        "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----",
+		"SINGLE_MULTI": "a\nb",
+		"AFTER":        "multi-line values do not swallow the next entry",
+		"WINDOWS":      "crlf",
+		"LAST":         "no trailing newline",
+	}
+	if len(got) != len(want) {
+		t.Errorf("Expected %d variables, got %d: %v", len(want), len(got), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Expected %s=%q, got %q", k, v, got[k])
+		}
+	}
+}
+
+func TestParseDotenv_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "missing equals sign", input: "A=1\nJUST_A_NAME\n", wantErr: "line 2: expected NAME=value"},
+		{name: "invalid name", input: "1A=1\n", wantErr: `line 1: invalid variable name "1A"`},
+		{name: "unterminated quote", input: "A=\"x\n\nB=1\n", wantErr: "line 1: unterminated quoted value of A"},
+		{name: "text after the quote", input: "A='x' y\n", wantErr: "line 1: unexpected \"y\""},
+		{name: "line after a multi-line value", input: "A='x\ny'\n-\n", wantErr: "line 3: expected NAME=value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDotenv(tt.input)
+			if err == nil {
+				t.Fatalf("Expected error %q, got none", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %q", tt.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestReadEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, ".env")
+	second := filepath.Join(dir, ".env.local")
+	if err := os.WriteFile(first, []byte("A=1\nB=1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("B=2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := ReadEnvFiles([]string{first, second})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if env["A"] != "1" || env["B"] != "2" {
+		t.Errorf("Expected A=1 and B=2 from the later file, got %v", env)
+	}
+
+	if _, err := ReadEnvFiles([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+	if err := os.WriteFile(first, []byte("A\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadEnvFiles([]string{first}); err == nil || !strings.Contains(err.Error(), first+": line 1") {
+		t.Errorf("Expected an error with the file and line, got %v", err)
+	}
+}
+
+func TestSubstituteEnvs_FallbackEnv(t *testing.T) {
+	t.Setenv("APP_HOST", "from-environment")
+	t.Setenv("APP_EMPTY", "")
+
+	envsubst := NewEnvsubst([]string{"DB_USER"}, []string{"APP_"}, true)
+	envsubst.SetFallbackEnv(map[string]string{
+		"APP_HOST":  "from-file",
+		"APP_PORT":  "5432",
+		"APP_EMPTY": "from-file",
+		"DB_USER":   "app",
+		"OTHER":     "not allowed",
+	})
+
+	got, err := envsubst.SubstituteEnvs("$APP_HOST:$APP_PORT [$APP_EMPTY] $DB_USER $OTHER")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "from-environment:5432 [] app $OTHER"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
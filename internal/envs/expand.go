@@ -0,0 +1,280 @@
+package envs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Parameter expansion operators of ${VAR<op>word}. With a leading colon,
+// e.g. ${VAR:-word}, an empty variable is treated like an unset one.
+const (
+	opDefault = '-' // ${VAR:-word}: word if VAR is unset
+	opAssign  = '=' // ${VAR:=word}: word if VAR is unset, and VAR is set to word
+	opError   = '?' // ${VAR:?message}: fail with message if VAR is unset
+	opAlt     = '+' // ${VAR:+word}: word if VAR is set, else empty
+)
+
+// errUnset is the message of ${VAR:?} without a message, as in sh.
+const errUnset = "parameter null or not set"
+
+// expansion expands the placeholders of a text with the allowed variables.
+type expansion struct {
+	p   *Envsubst
+	env map[string]string
+
+	// unresolved are the names of placeholders left unchanged
+	unresolved []string
+	// unresolvedRefs are the provider references that were not found
+	unresolvedRefs []string
+	// resolved caches the values of provider references
+	resolved map[string]string
+}
+
+// param is a placeholder: $VAR, ${VAR} or ${VAR<op>word}, optionally with
+// a cast like ${VAR|int} or ${VAR:-1|int}.
+type param struct {
+	name  string
+	op    byte
+	colon bool
+	word  string
+	cast  string
+	// scheme is set for a provider reference ${scheme:ref}, whose ref is in word
+	scheme string
+	// raw is the placeholder as written
+	raw string
+}
+
+// expand returns s with its placeholders expanded. Placeholders of variables
+// outside the allowlist are left unchanged, as are malformed ones. $$ before a
+// placeholder escapes it, e.g. $${VAR} becomes ${VAR}; a $$ elsewhere is kept,
+// so that $$(VAR) of Kubernetes still works.
+func (e *expansion) expand(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '$' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		if i+2 < len(s) && s[i+1] == '$' && (s[i+2] == '{' || isNameStart(s[i+2])) {
+			if _, end, ok := parseParam(s, i+1); ok {
+				b.WriteString(s[i+1 : end])
+				i = end
+				continue
+			}
+		}
+		p, end, ok := parseParam(s, i)
+		if !ok {
+			b.WriteByte('$')
+			i++
+			continue
+		}
+		value, _, err := e.resolve(&p)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		i = end
+	}
+	return b.String(), nil
+}
+
+// resolve returns the value of a placeholder, cast if it has a cast.
+// resolved is false for a placeholder left unchanged.
+func (e *expansion) resolve(p *param) (value string, resolved bool, err error) {
+	value, err = e.value(p)
+	if err != nil || value == p.raw {
+		return value, false, err
+	}
+	if p.cast == "" {
+		return value, true, nil
+	}
+	cast, err := castValue(p.cast, value)
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %w", p.name, err)
+	}
+	if cast != value {
+		e.p.redactor.Add(cast)
+	}
+	return cast, true, nil
+}
+
+// value returns the value of a placeholder, or p.raw if it is left unchanged.
+func (e *expansion) value(p *param) (string, error) {
+	if p.scheme != "" {
+		return e.providerValue(p)
+	}
+	if !e.p.isInFilter(p.name) {
+		e.unresolved = append(e.unresolved, p.name)
+		return p.raw, nil
+	}
+	value, ok := e.env[p.name]
+	isSet := ok && (!p.colon || value != "")
+
+	switch p.op {
+	case opDefault, opAssign:
+		if isSet {
+			return e.p.substituted(p.name, value), nil
+		}
+		word, err := e.expand(p.word)
+		if err != nil {
+			return "", err
+		}
+		if p.op == opAssign {
+			e.env[p.name] = word
+		}
+		return word, nil
+	case opError:
+		if isSet {
+			return e.p.substituted(p.name, value), nil
+		}
+		msg, err := e.expand(p.word)
+		if err != nil {
+			return "", err
+		}
+		if msg == "" {
+			msg = errUnset
+		}
+		return "", fmt.Errorf("%s: %s", p.name, msg)
+	case opAlt:
+		if isSet {
+			return e.expand(p.word)
+		}
+		return "", nil
+	default:
+		if ok {
+			return e.p.substituted(p.name, value), nil
+		}
+		e.unresolved = append(e.unresolved, p.name)
+		return p.raw, nil
+	}
+}
+
+// providerValue returns the value of a provider reference, or p.raw if it is
+// left unchanged: without a provider for its scheme, or when it is not found.
+func (e *expansion) providerValue(p *param) (string, error) {
+	provider, ok := e.p.providers[p.scheme]
+	if !ok {
+		return p.raw, nil
+	}
+	ref, err := e.expand(p.word)
+	if err != nil {
+		return "", err
+	}
+	key := p.scheme + ":" + ref
+	if value, ok := e.resolved[key]; ok {
+		return value, nil
+	}
+	value, err := provider.Resolve(e.p.context(), ref)
+	if errors.Is(err, ErrNotFound) {
+		e.unresolvedRefs = append(e.unresolvedRefs, key)
+		return p.raw, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	if e.resolved == nil {
+		e.resolved = map[string]string{}
+	}
+	e.resolved[key] = e.p.substituted(key, value)
+	return value, nil
+}
+
+// parseParam parses the placeholder starting with the $ at s[i]. It returns
+// the placeholder and the index after it, ok is false for a malformed one.
+func parseParam(s string, i int) (p param, end int, ok bool) {
+	if i+1 >= len(s) {
+		return p, 0, false
+	}
+	if s[i+1] != '{' {
+		p.name = scanName(s[i+1:])
+		if p.name == "" {
+			return p, 0, false
+		}
+		end = i + 1 + len(p.name)
+		p.raw = s[i:end]
+		return p, end, true
+	}
+
+	p.name = scanName(s[i+2:])
+	if p.name == "" {
+		return p, 0, false
+	}
+	j := i + 2 + len(p.name)
+	if j < len(s) && s[j] == '}' {
+		p.raw = s[i : j+1]
+		return p, j + 1, true
+	}
+	if j < len(s) && s[j] == '|' {
+		closing := strings.IndexByte(s[j:], '}')
+		if closing < 0 {
+			return p, 0, false
+		}
+		p.cast = s[j+1 : j+closing]
+		p.raw = s[i : j+closing+1]
+		return p, j + closing + 1, true
+	}
+	if j < len(s) && s[j] == ':' {
+		p.colon = true
+		j++
+	}
+	if j >= len(s) || s[j] == '}' {
+		return p, 0, false
+	}
+	if p.colon && !strings.ContainsRune("-=?+", rune(s[j])) {
+		// ${scheme:ref}
+		p.scheme, p.colon = p.name, false
+		j--
+	} else if !strings.ContainsRune("-=?+", rune(s[j])) {
+		return p, 0, false
+	} else {
+		p.op = s[j]
+	}
+	closing := matchingBrace(s, j+1)
+	if closing < 0 {
+		return p, 0, false
+	}
+	p.word = s[j+1 : closing]
+	p.raw = s[i : closing+1]
+	// the word may contain a |, only a known cast is split off
+	if k := strings.LastIndexByte(p.word, '|'); k >= 0 && isCast(p.word[k+1:]) {
+		p.word, p.cast = p.word[:k], p.word[k+1:]
+	}
+	return p, closing + 1, true
+}
+
+// matchingBrace returns the index of the } closing a placeholder whose word
+// starts at s[i], skipping nested placeholders, or -1.
+func matchingBrace(s string, i int) int {
+	depth := 0
+	for ; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// scanName returns the variable name s starts with, or "".
+func scanName(s string) string {
+	if s == "" || !isNameStart(s[0]) {
+		return ""
+	}
+	n := 1
+	for n < len(s) && (isNameStart(s[n]) || (s[n] >= '0' && s[n] <= '9')) {
+		n++
+	}
+	return s[:n]
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
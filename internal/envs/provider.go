@@ -0,0 +1,213 @@
+package envs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ErrNotFound is returned by a Provider for a reference without a value.
+// It is handled like an unset variable: an error in strict mode, else the
+// placeholder is left unchanged.
+var ErrNotFound = errors.New("not found")
+
+// Provider resolves provider references, placeholders like ${scheme:ref}.
+type Provider interface {
+	// Resolve returns the value of ref, the part after the scheme, or
+	// ErrNotFound. It stops when ctx is canceled.
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// Provider types of a ProviderConfig.
+const (
+	ProviderFile  = "file"
+	ProviderExec  = "exec"
+	ProviderVault = "vault"
+	ProviderLocal = "local"
+)
+
+// defaultExecTimeout limits the run time of an exec reference.
+const defaultExecTimeout = 30 * time.Second
+
+// execWaitDelay is how long a killed command's children may keep its output open.
+const execWaitDelay = time.Second
+
+// Config enables providers. No provider is enabled without it.
+type Config struct {
+	// Providers by the scheme of their references.
+	Providers map[string]ProviderConfig `json:"providers"`
+}
+
+// ProviderConfig configures the provider of a scheme.
+type ProviderConfig struct {
+	// Type is file, exec, vault or local; by default the scheme.
+	Type string `json:"type,omitempty"`
+
+	// Dir is the directory relative paths of file references and exec
+	// commands start in, relative to the config file. By default the
+	// directory of the patch-file.
+	Dir string `json:"dir,omitempty"`
+	// Timeout limits every exec command or Vault request, e.g. "10s".
+	Timeout string `json:"timeout,omitempty"`
+
+	// Address of the Vault server, by default $VAULT_ADDR.
+	Address string `json:"address,omitempty"`
+	// TokenEnv names the variable holding the Vault token, by default
+	// VAULT_TOKEN; without it ~/.vault-token is used.
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	// Namespace is the Vault Enterprise namespace, by default $VAULT_NAMESPACE.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Values of a local provider by reference.
+	Values map[string]string `json:"values,omitempty"`
+}
+
+// ReadConfig reads a provider config file. Dir of the providers is made
+// relative to the directory of the config file.
+func ReadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for scheme, pc := range c.Providers {
+		if pc.Dir != "" && !filepath.IsAbs(pc.Dir) {
+			pc.Dir = filepath.Join(filepath.Dir(path), pc.Dir)
+			c.Providers[scheme] = pc
+		}
+	}
+	return &c, nil
+}
+
+// NewProviders returns the providers of a config by scheme. dir is the
+// default directory of file references and exec commands.
+func (c *Config) NewProviders(dir string) (map[string]Provider, error) {
+	providers := map[string]Provider{}
+	for scheme, pc := range c.Providers {
+		if scanName(scheme) != scheme {
+			return nil, fmt.Errorf("invalid provider scheme %q", scheme)
+		}
+		provider, err := pc.newProvider(scheme, dir)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", scheme, err)
+		}
+		providers[scheme] = provider
+	}
+	return providers, nil
+}
+
+func (pc *ProviderConfig) newProvider(scheme, dir string) (Provider, error) {
+	if pc.Dir != "" {
+		dir = pc.Dir
+	}
+	var timeout time.Duration
+	if pc.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(pc.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+
+	typ := pc.Type
+	if typ == "" {
+		typ = scheme
+	}
+	switch typ {
+	case ProviderFile:
+		return &FileProvider{Dir: dir}, nil
+	case ProviderExec:
+		if timeout == 0 {
+			timeout = defaultExecTimeout
+		}
+		return &ExecProvider{Dir: dir, Timeout: timeout}, nil
+	case ProviderVault:
+		return newVaultProvider(pc, timeout)
+	case ProviderLocal:
+		return LocalProvider(pc.Values), nil
+	default:
+		return nil, fmt.Errorf("unknown type %q, expected %s, %s, %s or %s", typ, ProviderFile, ProviderExec, ProviderVault, ProviderLocal)
+	}
+}
+
+// LocalProvider serves fixed values by reference. It stands in for a remote
+// provider, e.g. Vault, in tests and local development.
+type LocalProvider map[string]string
+
+// Resolve returns the value of ref.
+func (p LocalProvider) Resolve(_ context.Context, ref string) (string, error) {
+	value, ok := p[ref]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// FileProvider resolves ${file:path} to the content of a file, without
+// trailing newlines, like $(cat path) in sh.
+type FileProvider struct {
+	// Dir is the directory relative paths start in.
+	Dir string
+}
+
+// Resolve returns the content of the file ref.
+func (p *FileProvider) Resolve(_ context.Context, ref string) (string, error) {
+	path := ref
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.Dir, path)
+	}
+	data, err := os.ReadFile(path) //nolint:gosec // reading files is what the provider is enabled for
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+// ExecProvider resolves ${exec:command} to the output of a sh command,
+// without trailing newlines, like $(command) in sh.
+type ExecProvider struct {
+	// Dir is the directory the command runs in.
+	Dir string
+	// Timeout kills a command running longer.
+	Timeout time.Duration
+}
+
+// Resolve runs the command ref and returns its output.
+func (p *ExecProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", ref) //nolint:gosec // running commands is what the provider is enabled for
+	cmd.Dir = p.Dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = execWaitDelay
+	if err := cmd.Run(); err != nil {
+		if err := parent.Err(); err != nil {
+			return "", err
+		}
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("timed out after %s", p.Timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimRight(stdout.String(), "\n"), nil
+}
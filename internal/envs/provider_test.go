@@ -0,0 +1,315 @@
+package envs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubepatch/kubepatch/internal/redact"
+)
+
+func TestSubstituteEnvs_Providers(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "password.txt"), []byte("s3cret\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "replicas"), []byte("3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APP_ENV", "prod")
+
+	providers := map[string]Provider{
+		"file":  &FileProvider{Dir: dir},
+		"exec":  &ExecProvider{Dir: dir, Timeout: 10 * time.Second},
+		"vault": LocalProvider{"secret/data/prod#password": "vault-s3cret"},
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "file", input: "password: ${file:password.txt}", want: "password: s3cret"},
+		{name: "absolute file", input: "${file:" + filepath.Join(dir, "password.txt") + "}", want: "s3cret"},
+		{name: "exec", input: "${exec:printf 'a b\\n'}", want: "a b"},
+		{name: "exec in dir", input: "${exec:cat password.txt}", want: "s3cret"},
+		{name: "vault", input: "${vault:secret/data/prod#password}", want: "vault-s3cret"},
+		{name: "nested variable", input: "${vault:secret/data/${APP_ENV}#password}", want: "vault-s3cret"},
+		{name: "cast", input: "${file:replicas|int} ${file:password.txt|base64}", want: "3 czNjcmV0"},
+		{name: "unknown scheme is unchanged", input: "${aws:secret#key}", want: "${aws:secret#key}"},
+		{name: "escaped", input: "$${vault:secret/data/prod#password}", want: "${vault:secret/data/prod#password}"},
+		{name: "parameter expansion still works", input: "${APP_PORT:-80}", want: "80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envsubst := NewEnvsubst([]string{}, []string{"APP_"}, true)
+			envsubst.SetProviders(providers)
+			got, err := envsubst.SubstituteEnvs(tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSubstituteEnvs_ProvidersNotFound(t *testing.T) {
+	providers := map[string]Provider{
+		"file":  &FileProvider{Dir: t.TempDir()},
+		"vault": LocalProvider{},
+	}
+	input := "${vault:secret/data/app#password} ${file:missing.txt}"
+
+	strict := NewEnvsubst([]string{}, []string{}, true)
+	strict.SetProviders(providers)
+	_, err := strict.SubstituteEnvs(input)
+	want := "unresolved references: [file:missing.txt, vault:secret/data/app#password]"
+	if err == nil || err.Error() != want {
+		t.Errorf("Expected error %q, got %v", want, err)
+	}
+
+	lenient := NewEnvsubst([]string{}, []string{}, false)
+	lenient.SetProviders(providers)
+	lenient.SetVerbose(true)
+	logBuffer := strings.Builder{}
+	log.SetOutput(&logBuffer)
+	defer log.SetOutput(os.Stderr)
+	got, err := lenient.SubstituteEnvs(input)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != input {
+		t.Errorf("Expected %q, got %q", input, got)
+	}
+	if !strings.Contains(logBuffer.String(), "DEBUG: a reference that was not found remains unchanged: file:missing.txt") {
+		t.Errorf("Expected a debug log for the reference, got %q", logBuffer.String())
+	}
+}
+
+func TestSubstituteEnvs_ProviderErrors(t *testing.T) {
+	providers := map[string]Provider{
+		"exec":  &ExecProvider{Dir: t.TempDir(), Timeout: 100 * time.Millisecond},
+		"vault": &VaultProvider{},
+	}
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "failing command", input: "${exec:echo broken >&2; exit 3}", wantErr: "exec:echo broken >&2; exit 3: exit status 3: broken"},
+		{name: "timeout", input: "${exec:sleep 5}", wantErr: "timed out after 100ms"},
+		{name: "vault reference without key", input: "${vault:secret/data/app}", wantErr: `expected path#key, got "secret/data/app"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// provider errors fail also without strict mode
+			envsubst := NewEnvsubst([]string{}, []string{}, false)
+			envsubst.SetProviders(providers)
+			_, err := envsubst.SubstituteEnvs(tt.input)
+			if err == nil {
+				t.Fatalf("Expected error %q, got none", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %q", tt.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+type countingProvider struct {
+	calls int
+}
+
+func (p *countingProvider) Resolve(_ context.Context, ref string) (string, error) {
+	p.calls++
+	return "value of " + ref, nil
+}
+
+func TestSubstituteYAML_Providers(t *testing.T) {
+	counter := &countingProvider{}
+	r := redact.New()
+	envsubst := NewEnvsubst([]string{}, []string{}, true)
+	envsubst.SetProviders(map[string]Provider{
+		"vault": LocalProvider{"secret/data/app#password": "p@ss: word\n- x", "secret/data/app#replicas": "2"},
+		"count": counter,
+	})
+	envsubst.SetRedactor(r)
+
+	out, err := envsubst.SubstituteYAML([]byte(`
+password: ${vault:secret/data/app#password}
+replicas: ${vault:secret/data/app#replicas|int}
+a: ${count:x}
+b: ${count:x}
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := `password: |-
+  p@ss: word
+  - x
+replicas: 2
+a: value of x
+b: value of x
+`
+	if string(out) != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, out)
+	}
+	if counter.calls != 1 {
+		t.Errorf("Expected a reference to be resolved once, got %d calls", counter.calls)
+	}
+	if got := r.String("p@ss: word\n- x"); got != r.Mask("p@ss: word\n- x") {
+		t.Errorf("Expected provider values to be masked, got %q", got)
+	}
+}
+
+func TestReferences_SkipProviders(t *testing.T) {
+	got := References(parseNode(t, "a: ${vault:secret/data/${APP_ENV}#password}\nb: ${file:x}\n"))
+	if len(got) != 1 || got[0].Name != "APP_ENV" {
+		t.Errorf("Expected only APP_ENV, got %v", got)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "providers.yaml")
+	if err := os.WriteFile(path, []byte(`
+providers:
+  file:
+    dir: secrets
+  exec:
+    timeout: 5s
+  vault:
+    type: local
+    values:
+      secret/data/app#password: s3cret
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	providers, err := config.NewProviders("/patches")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(providers) != 3 {
+		t.Fatalf("Expected 3 providers, got %v", providers)
+	}
+	if p, ok := providers["file"].(*FileProvider); !ok || p.Dir != filepath.Join(dir, "secrets") {
+		t.Errorf("Expected a file provider in the secrets directory, got %#v", providers["file"])
+	}
+	if p, ok := providers["exec"].(*ExecProvider); !ok || p.Dir != "/patches" || p.Timeout != 5*time.Second {
+		t.Errorf("Expected an exec provider in the patch-file directory, got %#v", providers["exec"])
+	}
+	if value, err := providers["vault"].Resolve(context.Background(), "secret/data/app#password"); err != nil || value != "s3cret" {
+		t.Errorf("Expected the local value for vault, got %q, %v", value, err)
+	}
+}
+
+func TestReadConfig_Errors(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{name: "unknown field", config: "providers:\n  file:\n    directory: x\n", wantErr: "unknown field"},
+		{name: "unknown type", config: "providers:\n  aws: {}\n", wantErr: `provider aws: unknown type "aws"`},
+		{name: "invalid timeout", config: "providers:\n  exec:\n    timeout: soon\n", wantErr: "provider exec: invalid timeout"},
+		{name: "vault without address", config: "providers:\n  vault: {}\n", wantErr: "provider vault: no address"},
+		{name: "invalid scheme", config: "providers:\n  my-file:\n    type: file\n", wantErr: `invalid provider scheme "my-file"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "providers.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			config, err := ReadConfig(path)
+			if err == nil {
+				_, err = config.NewProviders(".")
+			}
+			if err == nil {
+				t.Fatalf("Expected error %q, got none", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %q", tt.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Vault-Token") != "test-token" || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var data interface{}
+		switch r.URL.Path {
+		case "/v1/secret/data/app":
+			data = map[string]interface{}{
+				"data":     map[string]interface{}{"password": "s3cret", "ports": []int{80, 443}},
+				"metadata": map[string]interface{}{"version": 3},
+			}
+		case "/v1/kv/app":
+			data = map[string]interface{}{"password": "v1-s3cret"}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"data": data}); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("MY_VAULT_TOKEN", "test-token")
+	provider, err := newVaultProvider(&ProviderConfig{TokenEnv: "MY_VAULT_TOKEN", Namespace: "team"}, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for ref, want := range map[string]string{
+		"secret/data/app#password": "s3cret",
+		"secret/data/app#ports":    "[80,443]",
+		"/kv/app#password":         "v1-s3cret",
+	} {
+		got, err := provider.Resolve(context.Background(), ref)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", ref, err)
+		}
+		if got != want {
+			t.Errorf("Expected %q for %s, got %q", want, ref, got)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Expected a secret to be read once, got %d requests", requests)
+	}
+
+	for _, ref := range []string{"secret/data/app#missing", "secret/data/other#password"} {
+		if _, err := provider.Resolve(context.Background(), ref); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for %s, got %v", ref, err)
+		}
+	}
+
+	provider.Token = "wrong"
+	provider.secrets = nil
+	_, err = provider.Resolve(context.Background(), "secret/data/app#password")
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden") {
+		t.Errorf("Expected a 403 error, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package envs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// Formats supported by WriteStatuses.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	// FormatEnv is a dotenv template like .env.example, without values.
+	FormatEnv = "env"
+)
+
+// States of a variable.
+const (
+	StateSet        = "set"
+	StateDefault    = "default"
+	StateUnset      = "unset"
+	StateMissing    = "missing"
+	StateInvalid    = "invalid"
+	StateNotAllowed = "not allowed"
+)
+
+// Sources of a set variable.
+const (
+	SourceEnvironment = "environment"
+	SourceEnvFile     = "env file"
+)
+
+// VariableStatus is a variable a document declares or references.
+type VariableStatus struct {
+	Name string `json:"name"`
+	Variable
+	Declared   bool `json:"declared"`
+	Referenced bool `json:"referenced"`
+	// Default is the default of a placeholder, if it has one.
+	Default    string `json:"default,omitempty"`
+	HasDefault bool   `json:"hasDefault,omitempty"`
+	// State is one of the State constants.
+	State string `json:"state"`
+	// Source is SourceEnvironment or SourceEnvFile for a set variable.
+	Source string `json:"source,omitempty"`
+}
+
+// Statuses returns the status of the declared and referenced variables,
+// sorted by name. With an allowlist, referenced variables outside of it are
+// left out, as they are never substituted.
+func (p *Envsubst) Statuses(vars map[string]Variable, refs []Reference) []VariableStatus {
+	all := map[string]*VariableStatus{}
+	for name, v := range vars {
+		all[name] = &VariableStatus{Name: name, Variable: v, Declared: true}
+	}
+	filtered := len(p.allowedVars) > 0 || len(p.allowedPrefixes) > 0
+	for _, ref := range refs {
+		s, ok := all[ref.Name]
+		if !ok {
+			if filtered && !p.isInFilter(ref.Name) {
+				continue
+			}
+			s = &VariableStatus{Name: ref.Name}
+			all[ref.Name] = s
+		}
+		s.Referenced = true
+		s.Default, s.HasDefault = ref.Default, ref.HasDefault
+		s.Required = s.Required || ref.Required
+	}
+
+	out := make([]VariableStatus, 0, len(all))
+	for _, name := range sortedNames(all) {
+		s := all[name]
+		value, source := p.lookup(name)
+		if source != "" {
+			s.Source = source
+		}
+		switch {
+		case filtered && !p.isInFilter(name):
+			s.State = StateNotAllowed
+		case s.Required && value == "":
+			s.State = StateMissing
+		case source != "" && s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(value):
+			s.State = StateInvalid
+		case source != "":
+			s.State = StateSet
+		case s.HasDefault:
+			s.State = StateDefault
+		default:
+			s.State = StateUnset
+		}
+		out = append(out, *s)
+	}
+	return out
+}
+
+// lookup returns the value of a variable and where it is set, ignoring the
+// allowlist; source is empty for an unset variable.
+func (p *Envsubst) lookup(name string) (value, source string) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, SourceEnvironment
+	}
+	if value, ok := p.fallbackEnv[name]; ok {
+		return value, SourceEnvFile
+	}
+	return "", ""
+}
+
+// WriteStatuses prints the statuses in the given format. Values of variables
+// are never printed, only defaults from the document.
+func WriteStatuses(w io.Writer, format string, statuses []VariableStatus) error {
+	var b strings.Builder
+	switch format {
+	case FormatText:
+		tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSTATE\tREQUIRED\tDEFAULT\tDESCRIPTION")
+		for i := range statuses {
+			s := &statuses[i]
+			state := s.State
+			if s.Source != "" && s.State == StateSet {
+				state += " (" + s.Source + ")"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Name, state, yesNo(s.Required), orDash(s.Default, s.HasDefault), orDash(s.Description, s.Description != ""))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	case FormatJSON:
+		if statuses == nil {
+			statuses = []VariableStatus{}
+		}
+		out, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return err
+		}
+		b.Write(out)
+		b.WriteByte('\n')
+	case FormatEnv:
+		for i := range statuses {
+			writeEnvExample(&b, &statuses[i])
+		}
+	default:
+		return fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatText, FormatJSON, FormatEnv)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeEnvExample writes a variable as a commented, empty dotenv entry.
+func writeEnvExample(b *strings.Builder, s *VariableStatus) {
+	var notes []string
+	if s.Required {
+		notes = append(notes, "required")
+	}
+	if s.Pattern != "" {
+		notes = append(notes, "pattern "+s.Pattern)
+	}
+	if s.HasDefault {
+		notes = append(notes, fmt.Sprintf("default %q", s.Default))
+	}
+	comment := s.Name
+	if s.Description != "" {
+		comment += ": " + strings.ReplaceAll(strings.TrimSpace(s.Description), "\n", "\n# ")
+	}
+	if len(notes) > 0 {
+		comment += " (" + strings.Join(notes, ", ") + ")"
+	}
+	fmt.Fprintf(b, "# %s\n%s=\n", comment, s.Name)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func orDash(s string, ok bool) string {
+	if !ok {
+		return "-"
+	}
+	if s == "" {
+		return `""`
+	}
+	return s
+}
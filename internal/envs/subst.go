@@ -1,22 +1,29 @@
 package envs
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
-	"regexp"
 	"sort"
 	"strings"
-)
 
-// Match placeholders like ${VAR} or $VAR
-var envVarRegex = regexp.MustCompile(`\$\{?([a-zA-Z_][a-zA-Z0-9_]*)\}?`)
+	"github.com/kubepatch/kubepatch/internal/redact"
+)
 
 type Envsubst struct {
 	allowedVars     []string
 	allowedPrefixes []string
 	strict          bool
 	verbose         bool
+	redactor        *redact.Redactor
+
+	// fallbackEnv holds variables used when they are not in the environment
+	fallbackEnv map[string]string
+	// providers resolve ${scheme:ref} placeholders by scheme
+	providers map[string]Provider
+	// ctx cancels the provider calls, context.Background() if nil
+	ctx context.Context
 }
 
 func NewEnvsubst(allowedVars, allowedPrefixes []string, strict bool) *Envsubst {
@@ -27,60 +34,109 @@ func NewEnvsubst(allowedVars, allowedPrefixes []string, strict bool) *Envsubst {
 	}
 }
 
+// SubstituteEnvs expands the placeholders of allowed variables in text:
+// $VAR, ${VAR}, ${VAR:-default}, ${VAR:=default}, ${VAR:?message} and
+// ${VAR:+alternative}, also without the colon as in sh. $$ escapes a placeholder.
 func (p *Envsubst) SubstituteEnvs(text string) (string, error) {
 	// Collect allowed environment variables
-	envMap := p.collectAllowedEnvVars()
-
-	// Perform substitution using regex
-	substituted := envVarRegex.ReplaceAllStringFunc(text, func(match string) string {
-		// Extract the variable name
-		// alternate: varName := envVarRegex.FindStringSubmatch(match)[1]
-		varName := strings.Trim(match, "${}")
-
-		// get value, according to filters
-		if value, ok := envMap[varName]; ok {
-			return value
-		}
+	e := &expansion{p: p, env: p.collectAllowedEnvVars()}
 
-		return match
-	})
+	substituted, err := e.expand(text)
+	if err != nil {
+		return "", err
+	}
+	if err := p.finish(e); err != nil {
+		return "", err
+	}
+	return substituted, nil
+}
 
+// finish checks and logs the placeholders an expansion left unchanged.
+func (p *Envsubst) finish(e *expansion) error {
 	// Handle unresolved variables in strict mode
 	// Returns error, if and only if an unresolved variable is from one of the filter-list.
 	// Ignoring other unexpanded variables, that may be a parts of config-maps, etc...
 	//
-	if err := p.checkUnresolvedStrictMode(substituted); err != nil {
-		return "", err
+	if err := p.checkUnresolved(e.unresolved); err != nil {
+		return err
+	}
+	if len(e.unresolvedRefs) > 0 && p.strict {
+		return fmt.Errorf("unresolved references: [%s]", strings.Join(p.sortUnresolved(e.unresolvedRefs), ", "))
 	}
 
 	// Log unresolved variables in verbose mode
 	// if there are unexpanded placeholders, it's not an error, just debug-info
 	// it's not an error, because these placeholders are not in filter lists, so they remain unchanged
-	unresolved := envVarRegex.FindAllString(substituted, -1)
-	p.logUnresolvedVariables(unresolved)
-
-	return substituted, nil
+	p.logUnresolvedVariables(e.unresolved)
+	if p.verbose {
+		for _, ref := range p.sortUnresolved(e.unresolvedRefs) {
+			log.Printf("DEBUG: a reference that was not found remains unchanged: %s", ref)
+		}
+	}
+	return nil
 }
 
 func (p *Envsubst) SetVerbose(value bool) {
 	p.verbose = value
 }
 
+// SetRedactor registers every substituted value with r, so that it is masked
+// in diagnostics, and masks the values in debug logs with it.
+func (p *Envsubst) SetRedactor(r *redact.Redactor) {
+	p.redactor = r
+}
+
+// SetFallbackEnv sets variables, e.g. read from env files, that are used
+// when they are not set in the environment. The allowlist applies to them
+// like to the environment; a variable set in the environment, even empty,
+// takes precedence.
+func (p *Envsubst) SetFallbackEnv(env map[string]string) {
+	p.fallbackEnv = env
+}
+
+// SetProviders enables provider references like ${vault:secret/data/app#key},
+// resolved by the provider of their scheme. Placeholders of other schemes are
+// left unchanged.
+func (p *Envsubst) SetProviders(providers map[string]Provider) {
+	p.providers = providers
+}
+
+// SetContext sets the context provider references are resolved with, so
+// that canceling it stops running commands and requests.
+func (p *Envsubst) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
 // Helper Functions
 
+func (p *Envsubst) context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
 // collectAllowedEnvVars collects variables and prefixes allowed for substitution
 func (p *Envsubst) collectAllowedEnvVars() map[string]string {
 	envMap := make(map[string]string)
 
+	// Variables of the environment override the fallback ones
+	globalEnv := make(map[string]string, len(p.fallbackEnv))
+	for key, value := range p.fallbackEnv {
+		globalEnv[key] = value
+	}
+	for key, value := range preprocessEnv() {
+		globalEnv[key] = value
+	}
+
 	// Collect variables in the allowedVars list
 	for _, env := range p.allowedVars {
-		if value, exists := os.LookupEnv(env); exists {
+		if value, exists := globalEnv[env]; exists {
 			envMap[env] = value
 		}
 	}
 
 	// Collect variables matching allowed prefixes
-	globalEnv := preprocessEnv()
 	for _, prefix := range p.allowedPrefixes {
 		for key, value := range globalEnv {
 			if strings.HasPrefix(key, prefix) {
@@ -104,9 +160,8 @@ func preprocessEnv() map[string]string {
 	return envMap
 }
 
-// checkUnresolvedStrictMode checks unresolved variables in strict mode
-func (p *Envsubst) checkUnresolvedStrictMode(substituted string) error {
-	unresolved := envVarRegex.FindAllString(substituted, -1)
+// checkUnresolved checks the names of unresolved placeholders in strict mode
+func (p *Envsubst) checkUnresolved(unresolved []string) error {
 	if p.strict {
 		filtered := p.filterUnresolvedByAllowedLists(unresolved)
 		if len(filtered) > 0 {
@@ -116,6 +171,25 @@ func (p *Envsubst) checkUnresolvedStrictMode(substituted string) error {
 	return nil
 }
 
+// substituted records the value substituted for a variable and returns it
+func (p *Envsubst) substituted(name, value string) string {
+	p.redactor.Add(value)
+	p.logSubstituted(name, value)
+	return value
+}
+
+// logSubstituted logs a substitution in verbose mode, never with the plain value
+func (p *Envsubst) logSubstituted(name, value string) {
+	if !p.verbose {
+		return
+	}
+	masked := redact.MaskPrefix
+	if p.redactor != nil {
+		masked = p.redactor.Mask(value)
+	}
+	log.Printf("DEBUG: substituted %s with %s", name, masked)
+}
+
 // logUnresolvedVariables logs unresolved variables in verbose mode
 func (p *Envsubst) logUnresolvedVariables(unresolved []string) {
 	if p.verbose {
@@ -5,6 +5,8 @@ import (
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/kubepatch/kubepatch/internal/redact"
 )
 
 func TestEnvsubst(t *testing.T) {
@@ -223,41 +225,6 @@ spec:
 	}
 }
 
-func TestRegex(t *testing.T) {
-	testCases := []struct {
-		input    string
-		expected []string
-	}{
-		{"$VAR", []string{"VAR"}},
-		{"${VAR}", []string{"VAR"}},
-		{"$1VAR", nil},
-		{"$(VAR)", nil},
-		{"${VAR1_2}", []string{"VAR1_2"}},
-		{"No variable here", nil},
-	}
-
-	for _, tc := range testCases {
-		matches := envVarRegex.FindAllStringSubmatch(tc.input, -1)
-		var result []string
-		for _, match := range matches {
-			if len(match) > 1 {
-				result = append(result, match[1])
-			}
-		}
-
-		if len(result) != len(tc.expected) {
-			t.Errorf("For input '%s', expected %v, got %v", tc.input, tc.expected, result)
-			continue
-		}
-		for i, v := range result {
-			if v != tc.expected[i] {
-				t.Errorf("For input '%s', expected %v, got %v", tc.input, tc.expected, result)
-				break
-			}
-		}
-	}
-}
-
 func TestStrictMode(t *testing.T) {
 	os.Setenv("USER", "Alice")
 	defer os.Unsetenv("USER")
@@ -990,12 +957,12 @@ func TestCollectAllowedEnvVars(t *testing.T) {
 	}
 }
 
-// Test for checkUnresolvedStrictMode
-func TestCheckUnresolvedStrictMode(t *testing.T) {
+// Test for checkUnresolved
+func TestCheckUnresolved(t *testing.T) {
 	envsubst := NewEnvsubst([]string{"VAR1"}, []string{"PREFIX_"}, true)
-	input := "Hello ${VAR1}!"
+	input := []string{"${VAR1}"}
 
-	err := envsubst.checkUnresolvedStrictMode(input)
+	err := envsubst.checkUnresolved(input)
 	if err == nil {
 		t.Fatal("Expected an error for unresolved variables in strict mode, but got none")
 	}
@@ -1139,3 +1106,138 @@ $E_$X$C$E$P$T$E$U$R $S$I$N$T $O$C$C$A$E$C$A$T $C$U$P$I$D$A$T$A$T $N$O$N $P$R$O$I
 		t.Fatal("Texts are diff")
 	}
 }
+
+func TestSubstituteEnvs_RedactsSubstitutedValues(t *testing.T) {
+	t.Setenv("APP_PASSWORD", "s3cret-value")
+
+	r := redact.New()
+	envsubst := NewEnvsubst([]string{}, []string{"APP_"}, true)
+	envsubst.SetRedactor(r)
+	envsubst.SetVerbose(true)
+
+	logBuffer := strings.Builder{}
+	log.SetOutput(&logBuffer)
+	defer log.SetOutput(os.Stderr)
+
+	out, err := envsubst.SubstituteEnvs("password: ${APP_PASSWORD}")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out != "password: s3cret-value" {
+		t.Errorf("Expected the real value in the output, got %q", out)
+	}
+
+	logBuf := logBuffer.String()
+	if strings.Contains(logBuf, "s3cret-value") {
+		t.Errorf("Expected the value to be masked in debug logs, got %q", logBuf)
+	}
+	if !strings.Contains(logBuf, "DEBUG: substituted APP_PASSWORD with "+r.Mask("s3cret-value")) {
+		t.Errorf("Expected a debug log for the substitution, got %q", logBuf)
+	}
+	if got := r.String("pw=s3cret-value"); got != "pw="+r.Mask("s3cret-value") {
+		t.Errorf("Expected the redactor to mask the substituted value, got %q", got)
+	}
+}
+
+func TestSubstituteEnvs_ParameterExpansion(t *testing.T) {
+	t.Setenv("APP_HOST", "db.local")
+	t.Setenv("APP_EMPTY", "")
+	t.Setenv("OTHER_HOST", "other.local")
+
+	tests := []struct {
+		name   string
+		input  string
+		strict bool
+		want   string
+	}{
+		{name: "default of a set variable", input: "${APP_HOST:-localhost}", want: "db.local"},
+		{name: "default of an unset variable", input: "${APP_PORT:-5432}", strict: true, want: "5432"},
+		{name: "default of an empty variable", input: "${APP_EMPTY:-x}", want: "x"},
+		{name: "default without colon keeps an empty variable", input: "[${APP_EMPTY-x}]", want: "[]"},
+		{name: "empty default", input: "[${APP_PORT:-}]", strict: true, want: "[]"},
+		{name: "default with spaces and colons", input: "${APP_URL:-http://localhost:80/a b}", want: "http://localhost:80/a b"},
+		{name: "nested default", input: "${APP_URL:-${APP_HOST}:${APP_PORT:-5432}}", strict: true, want: "db.local:5432"},
+		{name: "assign", input: "${APP_PORT:=5432} ${APP_PORT}", strict: true, want: "5432 5432"},
+		{name: "assign of a set variable", input: "${APP_HOST:=x} $APP_HOST", want: "db.local db.local"},
+		{name: "alternative of a set variable", input: "${APP_HOST:+tls}", want: "tls"},
+		{name: "alternative of an unset variable", input: "[${APP_PORT:+tls}]", strict: true, want: "[]"},
+		{name: "alternative of an empty variable", input: "[${APP_EMPTY:+tls}]", want: "[]"},
+		{name: "alternative without colon of an empty variable", input: "${APP_EMPTY+tls}", want: "tls"},
+		{name: "required and set", input: "${APP_HOST:?host is required}", strict: true, want: "db.local"},
+		{name: "not allowed default is unchanged", input: "${OTHER_HOST:-x}", strict: true, want: "${OTHER_HOST:-x}"},
+		{name: "not allowed required is unchanged", input: "${OTHER_PORT:?required}", strict: true, want: "${OTHER_PORT:?required}"},
+		{name: "escaped braced placeholder", input: "$${APP_HOST} $APP_HOST", strict: true, want: "${APP_HOST} db.local"},
+		{name: "escaped placeholder", input: "$$APP_PORT", strict: true, want: "$APP_PORT"},
+		{name: "escaped expansion", input: "$${APP_PORT:-5432}", strict: true, want: "${APP_PORT:-5432}"},
+		{name: "kubernetes escape is kept", input: "$$(APP_HOST) $(APP_HOST)", strict: true, want: "$$(APP_HOST) $(APP_HOST)"},
+		{name: "lone dollars are kept", input: "$ $$ 5$", want: "$ $$ 5$"},
+		{name: "unknown operator is unchanged", input: "${APP_HOST#db}", want: "${APP_HOST#db}"},
+		{name: "unterminated expansion is unchanged", input: "${APP_HOST:-x", want: "${APP_HOST:-x"},
+		{name: "cast in text", input: "auth: ${APP_HOST|base64}", want: "auth: ZGIubG9jYWw="},
+		{name: "pipe in a default", input: "${APP_PORT:-a|b}", strict: true, want: "a|b"},
+		{name: "values are not expanded again", input: "${APP_X:-$$APP_HOST}", strict: true, want: "$APP_HOST"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envsubst := NewEnvsubst([]string{}, []string{"APP_"}, tt.strict)
+			got, err := envsubst.SubstituteEnvs(tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSubstituteEnvs_ParameterExpansionErrors(t *testing.T) {
+	t.Setenv("APP_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		input   string
+		strict  bool
+		wantErr string
+	}{
+		{name: "required with message", input: "${APP_HOST:?set the database host}", wantErr: "APP_HOST: set the database host"},
+		{name: "required without message", input: "${APP_HOST:?}", wantErr: "APP_HOST: parameter null or not set"},
+		{name: "required empty variable", input: "${APP_EMPTY:?empty}", wantErr: "APP_EMPTY: empty"},
+		{name: "message with variables", input: "${APP_HOST:?no host in ${APP_ENV:-dev}}", wantErr: "APP_HOST: no host in dev"},
+		{name: "unset in alternative in strict mode", input: "${APP_EMPTY+$APP_HOST}", strict: true, wantErr: "undefined variables: [APP_HOST]"},
+		{name: "unset in default in strict mode", input: "${APP_PORT:-${APP_HOST}}", strict: true, wantErr: "undefined variables: [APP_HOST]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envsubst := NewEnvsubst([]string{}, []string{"APP_"}, tt.strict)
+			_, err := envsubst.SubstituteEnvs(tt.input)
+			if err == nil {
+				t.Fatalf("Expected error %q, got none", tt.wantErr)
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("Expected error %q, got %q", tt.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestSubstituteEnvs_ParameterExpansionRedaction(t *testing.T) {
+	t.Setenv("APP_PASSWORD", "s3cret-value")
+
+	r := redact.New()
+	envsubst := NewEnvsubst([]string{}, []string{"APP_"}, true)
+	envsubst.SetRedactor(r)
+
+	out, err := envsubst.SubstituteEnvs("${APP_PASSWORD:-changeme} ${APP_USER:-admin}")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out != "s3cret-value admin" {
+		t.Errorf("Expected %q, got %q", "s3cret-value admin", out)
+	}
+	if got := r.String("s3cret-value admin"); got != r.Mask("s3cret-value")+" admin" {
+		t.Errorf("Expected only the env value to be masked, got %q", got)
+	}
+}
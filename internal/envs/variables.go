@@ -0,0 +1,169 @@
+package envs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Variable declares a variable a document consumes.
+type Variable struct {
+	// Required variables must be set to a non-empty value.
+	Required bool `yaml:"required" json:"required,omitempty"`
+	// Pattern is a regular expression the value must match, if it is set.
+	Pattern string `yaml:"pattern" json:"pattern,omitempty"`
+	// Description tells what the variable is for.
+	Description string `yaml:"description" json:"description,omitempty"`
+}
+
+// variableFields are the fields of a declaration.
+var variableFields = map[string]bool{"required": true, "pattern": true, "description": true}
+
+// ParseVariables parses declarations, a mapping of variable names to their
+// Variable, and checks the names, fields and patterns.
+func ParseVariables(n *yaml.Node) (map[string]Variable, error) {
+	vars := map[string]Variable{}
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return vars, nil
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping of variable names", n.Line)
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		name, decl := n.Content[i].Value, n.Content[i+1]
+		if scanName(name) != name {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", n.Content[i].Line, name)
+		}
+		if decl.Kind == yaml.MappingNode {
+			for j := 0; j+1 < len(decl.Content); j += 2 {
+				if key := decl.Content[j]; !variableFields[key.Value] {
+					return nil, fmt.Errorf("line %d: unknown field %q of variable %s, expected required, pattern or description", key.Line, key.Value, name)
+				}
+			}
+		}
+		var v Variable
+		if err := decl.Decode(&v); err != nil {
+			return nil, fmt.Errorf("variable %s: %w", name, err)
+		}
+		if v.Pattern != "" {
+			if _, err := regexp.Compile(v.Pattern); err != nil {
+				return nil, fmt.Errorf("variable %s: invalid pattern: %w", name, err)
+			}
+		}
+		vars[name] = v
+	}
+	return vars, nil
+}
+
+// Validate checks the declared variables against the allowed variables:
+// a declared variable must be allowed, a required one must be set and not
+// empty, and a set one must match its pattern. All problems are reported.
+func (p *Envsubst) Validate(vars map[string]Variable) error {
+	env := p.collectAllowedEnvVars()
+	var errs []error
+	for _, name := range sortedNames(vars) {
+		v := vars[name]
+		value, ok := env[name]
+		switch {
+		case !p.isInFilter(name):
+			errs = append(errs, fmt.Errorf("%s: declared, but not allowed for substitution", name))
+		case v.Required && value == "":
+			errs = append(errs, fmt.Errorf("%s: required%s", name, describe(v)))
+		case ok && v.Pattern != "" && !regexp.MustCompile(v.Pattern).MatchString(value):
+			errs = append(errs, fmt.Errorf("%s: the value does not match %s%s", name, v.Pattern, describe(v)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func describe(v Variable) string {
+	if v.Description == "" {
+		return ""
+	}
+	return " (" + v.Description + ")"
+}
+
+// Reference is a variable referenced by placeholders.
+type Reference struct {
+	Name string `json:"name"`
+	// Default is the default of a ${VAR:-default} or ${VAR:=default}
+	// placeholder, if it has no placeholders itself.
+	Default    string `json:"default,omitempty"`
+	HasDefault bool   `json:"hasDefault,omitempty"`
+	// Required is set for a ${VAR:?message} placeholder.
+	Required bool `json:"required,omitempty"`
+}
+
+// References returns the variables referenced by placeholders in the scalars
+// of a document, including those in defaults, sorted by name. Escaped
+// placeholders are not references.
+func References(doc *yaml.Node) []Reference {
+	refs := map[string]*Reference{}
+	collectReferences(doc, refs)
+	out := make([]Reference, 0, len(refs))
+	for _, name := range sortedNames(refs) {
+		out = append(out, *refs[name])
+	}
+	return out
+}
+
+func collectReferences(n *yaml.Node, refs map[string]*Reference) {
+	if n.Kind == yaml.ScalarNode {
+		scanReferences(n.Value, refs)
+		return
+	}
+	for _, c := range n.Content {
+		collectReferences(c, refs)
+	}
+}
+
+// scanReferences adds the placeholders of s to refs, like expansion.expand parses them.
+func scanReferences(s string, refs map[string]*Reference) {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			continue
+		}
+		if i+2 < len(s) && s[i+1] == '$' && (s[i+2] == '{' || isNameStart(s[i+2])) {
+			if _, end, ok := parseParam(s, i+1); ok {
+				i = end - 1
+				continue
+			}
+		}
+		p, end, ok := parseParam(s, i)
+		if !ok {
+			continue
+		}
+		if p.scheme != "" {
+			// a provider reference is no variable, but its ref may hold placeholders
+			scanReferences(p.word, refs)
+			i = end - 1
+			continue
+		}
+		ref, found := refs[p.name]
+		if !found {
+			ref = &Reference{Name: p.name}
+			refs[p.name] = ref
+		}
+		if (p.op == opDefault || p.op == opAssign) && !ref.HasDefault && !strings.Contains(p.word, "$") {
+			ref.Default, ref.HasDefault = p.word, true
+		}
+		if p.op == opError {
+			ref.Required = true
+		}
+		scanReferences(p.word, refs)
+		i = end - 1
+	}
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,237 @@
+package envs
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parseNode(t *testing.T, s string) *yaml.Node {
+	t.Helper()
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatalf("Invalid YAML: %v", err)
+	}
+	return doc.Content[0]
+}
+
+func TestParseVariables(t *testing.T) {
+	vars, err := ParseVariables(parseNode(t, `
+IMAGE_TAG:
+  required: true
+  pattern: '^[0-9.]+$'
+  description: the image tag
+APP_DEBUG: {}
+APP_EMPTY:
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := map[string]Variable{
+		"IMAGE_TAG": {Required: true, Pattern: "^[0-9.]+$", Description: "the image tag"},
+		"APP_DEBUG": {},
+		"APP_EMPTY": {},
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("Expected %v, got %v", want, vars)
+	}
+
+	vars, err = ParseVariables(parseNode(t, `~`))
+	if err != nil || len(vars) != 0 {
+		t.Errorf("Expected no variables, got %v, %v", vars, err)
+	}
+}
+
+func TestParseVariables_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "list", input: "- IMAGE_TAG", wantErr: "expected a mapping of variable names"},
+		{name: "invalid name", input: "IMAGE-TAG: {}", wantErr: `invalid variable name "IMAGE-TAG"`},
+		{name: "unknown field", input: "IMAGE_TAG:\n  requried: true", wantErr: `line 2: unknown field "requried" of variable IMAGE_TAG`},
+		{name: "invalid field type", input: "IMAGE_TAG:\n  required: sometimes", wantErr: "variable IMAGE_TAG"},
+		{name: "invalid pattern", input: "IMAGE_TAG:\n  pattern: '[0-9'", wantErr: "variable IMAGE_TAG: invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseVariables(parseNode(t, tt.input))
+			if err == nil {
+				t.Fatalf("Expected error %q, got none", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %q", tt.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Setenv("APP_TAG", "1.2.3")
+	t.Setenv("APP_BAD_TAG", "latest")
+	t.Setenv("APP_EMPTY", "")
+	t.Setenv("OTHER", "x")
+
+	envsubst := NewEnvsubst([]string{"APP_FILE"}, []string{"APP_"}, true)
+	envsubst.SetFallbackEnv(map[string]string{"APP_FILE": "from-file"})
+
+	valid := map[string]Variable{
+		"APP_TAG":      {Required: true, Pattern: `^[0-9.]+$`},
+		"APP_FILE":     {Required: true},
+		"APP_OPTIONAL": {Pattern: `^[0-9]+$`},
+	}
+	if err := envsubst.Validate(valid); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	err := envsubst.Validate(map[string]Variable{
+		"APP_BAD_TAG": {Pattern: `^[0-9.]+$`, Description: "the image tag"},
+		"APP_EMPTY":   {Required: true},
+		"APP_UNSET":   {Required: true, Description: "must be set"},
+		"OTHER":       {},
+	})
+	if err == nil {
+		t.Fatal("Expected an error, got none")
+	}
+	want := "APP_BAD_TAG: the value does not match ^[0-9.]+$ (the image tag)\n" +
+		"APP_EMPTY: required\n" +
+		"APP_UNSET: required (must be set)\n" +
+		"OTHER: declared, but not allowed for substitution"
+	if err.Error() != want {
+		t.Errorf("Expected error\n%s\ngot\n%s", want, err.Error())
+	}
+	if strings.Contains(err.Error(), "latest") {
+		t.Errorf("Expected no values in the error, got %q", err.Error())
+	}
+}
+
+func TestReferences(t *testing.T) {
+	doc := parseNode(t, `
+image: registry/app:${APP_TAG}
+port: ${APP_PORT:-8080|int}
+url: ${APP_URL:-http://${APP_HOST}:${APP_PORT}}
+password: ${APP_PASSWORD:?set the password}
+escaped: $${APP_ESCAPED} $$APP_ESCAPED2
+shell: echo $HOME $(POD_NAME)
+${APP_KEY}: key
+`)
+	got := References(doc)
+	want := []Reference{
+		{Name: "APP_HOST"},
+		{Name: "APP_KEY"},
+		{Name: "APP_PASSWORD", Required: true},
+		{Name: "APP_PORT", Default: "8080", HasDefault: true},
+		{Name: "APP_TAG"},
+		{Name: "APP_URL"},
+		{Name: "HOME"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	t.Setenv("APP_TAG", "latest")
+	t.Setenv("APP_HOST", "db")
+	t.Setenv("OTHER", "x")
+
+	envsubst := NewEnvsubst([]string{}, []string{"APP_"}, true)
+	envsubst.SetFallbackEnv(map[string]string{"APP_USER": "admin"})
+	vars := map[string]Variable{
+		"APP_TAG":   {Required: true, Pattern: `^[0-9.]+$`, Description: "the image tag"},
+		"APP_TOKEN": {Required: true},
+		"OTHER":     {},
+	}
+	refs := []Reference{
+		{Name: "APP_HOST"},
+		{Name: "APP_PORT", Default: "8080", HasDefault: true},
+		{Name: "APP_TAG"},
+		{Name: "APP_UNSET"},
+		{Name: "APP_USER"},
+		{Name: "HOME"},
+	}
+
+	got := envsubst.Statuses(vars, refs)
+	states := map[string]string{}
+	for _, s := range got {
+		states[s.Name] = s.State + "/" + s.Source
+	}
+	want := map[string]string{
+		"APP_HOST":  "set/environment",
+		"APP_PORT":  "default/",
+		"APP_TAG":   "invalid/environment",
+		"APP_TOKEN": "missing/",
+		"APP_UNSET": "unset/",
+		"APP_USER":  "set/env file",
+		"OTHER":     "not allowed/environment",
+	}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("Expected %v, got %v", want, states)
+	}
+	if got[0].Name != "APP_HOST" || got[len(got)-1].Name != "OTHER" {
+		t.Errorf("Expected the statuses sorted by name, got %v", got)
+	}
+}
+
+func TestWriteStatuses(t *testing.T) {
+	statuses := []VariableStatus{
+		{
+			Name:       "APP_TAG",
+			Variable:   Variable{Required: true, Pattern: `^[0-9.]+$`, Description: "the image tag"},
+			Declared:   true,
+			Referenced: true,
+			State:      StateSet,
+			Source:     SourceEnvironment,
+		},
+		{Name: "APP_PORT", Referenced: true, Default: "8080", HasDefault: true, State: StateDefault},
+	}
+
+	var text bytes.Buffer
+	if err := WriteStatuses(&text, FormatText, statuses); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantText := `NAME      STATE              REQUIRED  DEFAULT  DESCRIPTION
+APP_TAG   set (environment)  yes       -        the image tag
+APP_PORT  default            no        8080     -
+`
+	if text.String() != wantText {
+		t.Errorf("Expected\n%s\ngot\n%s", wantText, text.String())
+	}
+
+	var env bytes.Buffer
+	if err := WriteStatuses(&env, FormatEnv, statuses); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantEnv := `# APP_TAG: the image tag (required, pattern ^[0-9.]+$)
+APP_TAG=
+# APP_PORT (default "8080")
+APP_PORT=
+`
+	if env.String() != wantEnv {
+		t.Errorf("Expected\n%s\ngot\n%s", wantEnv, env.String())
+	}
+	if _, err := ParseDotenv(env.String()); err != nil {
+		t.Errorf("Expected a valid env file, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := WriteStatuses(&out, FormatJSON, statuses); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var decoded []VariableStatus
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, statuses) {
+		t.Errorf("Expected %v, got %v", statuses, decoded)
+	}
+
+	if err := WriteStatuses(&out, "yaml", statuses); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
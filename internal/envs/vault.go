@@ -0,0 +1,159 @@
+package envs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultVaultTimeout limits a Vault request.
+const defaultVaultTimeout = 30 * time.Second
+
+// maxVaultResponse limits the size of a Vault response.
+const maxVaultResponse = 10 << 20
+
+// VaultProvider resolves ${vault:path#key} to the key of a Vault secret, read
+// with GET /v1/<path>. Both KV versions are supported: for a KV v2 path like
+// secret/data/app the key is looked up in data.data, else in data.
+type VaultProvider struct {
+	Address   string
+	Token     string
+	Namespace string
+	Client    *http.Client
+
+	mu      sync.Mutex
+	secrets map[string]map[string]interface{}
+}
+
+func newVaultProvider(pc *ProviderConfig, timeout time.Duration) (*VaultProvider, error) {
+	address := pc.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return nil, errors.New("no address, set it in the config or $VAULT_ADDR")
+	}
+	namespace := pc.Namespace
+	if namespace == "" {
+		namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	token, err := vaultToken(pc.TokenEnv)
+	if err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = defaultVaultTimeout
+	}
+	return &VaultProvider{
+		Address:   address,
+		Token:     token,
+		Namespace: namespace,
+		Client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// vaultToken returns the token of the variable tokenEnv, VAULT_TOKEN by
+// default, or else of ~/.vault-token like the vault CLI.
+func vaultToken(tokenEnv string) (string, error) {
+	if tokenEnv == "" {
+		tokenEnv = "VAULT_TOKEN"
+	}
+	if token := os.Getenv(tokenEnv); token != "" {
+		return token, nil
+	}
+	home, err := os.UserHomeDir()
+	if err == nil {
+		data, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+		if err == nil {
+			return strings.TrimSpace(string(data)), nil
+		}
+	}
+	return "", fmt.Errorf("no token, set $%s or log in with the vault CLI", tokenEnv)
+}
+
+// Resolve returns the key of the secret ref, written path#key.
+func (p *VaultProvider) Resolve(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("expected path#key, got %q", ref)
+	}
+	secret, err := p.read(ctx, strings.Trim(path, "/"))
+	if err != nil {
+		return "", err
+	}
+	value, ok := secret[key]
+	if !ok || value == nil {
+		return "", ErrNotFound
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	out, err := json.Marshal(value)
+	return string(out), err
+}
+
+// read returns the data of a secret, read once per path.
+func (p *VaultProvider) read(ctx context.Context, path string) (map[string]interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if secret, ok := p.secrets[path]; ok {
+		return secret, nil
+	}
+
+	u, err := url.JoinPath(p.Address, "v1", path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVaultResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading %s: %s", path, resp.Status)
+	}
+
+	var payload struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	secret := payload.Data
+	// KV v2 nests the secret in data.data, next to data.metadata
+	if nested, ok := secret["data"].(map[string]interface{}); ok {
+		if _, ok := secret["metadata"]; ok {
+			secret = nested
+		}
+	}
+	if p.secrets == nil {
+		p.secrets = map[string]map[string]interface{}{}
+	}
+	p.secrets[path] = secret
+	return secret, nil
+}
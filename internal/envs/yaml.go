@@ -0,0 +1,159 @@
+package envs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/kubepatch/kubepatch/internal/unstr"
+	"gopkg.in/yaml.v3"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// SubstituteYAML expands the placeholders of allowed variables in the scalars
+// of a YAML document, never in its structure: a value cannot add keys or
+// items, whatever it contains, and the document is written back with the
+// quoting the values need.
+//
+// An unquoted scalar that is just a placeholder is typed like YAML reads the
+// value: "replicas: ${REPLICAS}" with REPLICAS=3 is the integer 3, and "on" a
+// boolean. Any other substituted scalar, like "${VAR}" or "v${VAR}", is a
+// string, written with the quotes the value needs. A placeholder with a cast
+// gets its type whatever the value: ${VAR|int} an integer, ${VAR|bool} a
+// boolean and ${VAR|json} the JSON value, e.g. an object or a list.
+func (p *Envsubst) SubstituteYAML(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		return data, nil
+	}
+
+	if err := p.SubstituteNode(&doc); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SubstituteNode is SubstituteYAML for a parsed document, which is modified in place.
+func (p *Envsubst) SubstituteNode(doc *yaml.Node) error {
+	e := &expansion{p: p, env: p.collectAllowedEnvVars()}
+	if err := e.node(doc); err != nil {
+		return err
+	}
+	return p.finish(e)
+}
+
+// node expands the scalars of n and its children.
+func (e *expansion) node(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		if err := e.scalar(n); err != nil {
+			return fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		return nil
+	}
+	// an alias shares its anchor node, which is expanded once
+	if n.Kind == yaml.AliasNode {
+		return nil
+	}
+	for _, c := range n.Content {
+		if err := e.node(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scalar expands the placeholders of a scalar node.
+func (e *expansion) scalar(n *yaml.Node) error {
+	p, end, ok := parseParam(n.Value, 0)
+	var value string
+	var err error
+	if ok && end == len(n.Value) {
+		var resolved bool
+		if value, resolved, err = e.resolve(&p); err != nil || !resolved {
+			return err
+		}
+		if p.cast != "" {
+			return setTyped(n, p.cast, value)
+		}
+		if typed, ok := plainScalar(value); ok && n.Style == 0 {
+			return setTyped(n, CastJSON, typed)
+		}
+	} else if value, err = e.expand(n.Value); err != nil || value == n.Value {
+		return err
+	}
+	n.Value = value
+	// a substituted value is a string, unless the scalar has an explicit tag
+	if n.Style&yaml.TaggedStyle == 0 {
+		n.Tag = "!!str"
+		if n.Style == 0 && unstr.Retyped(value) {
+			n.Style = yaml.DoubleQuotedStyle
+		}
+	}
+	return nil
+}
+
+// plainScalar returns the JSON of value if YAML reads it unquoted as a
+// number, a boolean or null, e.g. 3 for "3", true for "on" and 511 for
+// "0777". A value with spaces, a comment or several lines is not, so a
+// substitution never drops part of the value.
+func plainScalar(value string) (string, bool) {
+	if value == "" || strings.ContainsAny(value, " \t\n#") {
+		return "", false
+	}
+	data, err := sigsyaml.YAMLToJSON([]byte(value))
+	if err != nil || len(data) == 0 {
+		return "", false
+	}
+	switch data[0] {
+	case '"', '{', '[':
+		return "", false
+	}
+	return string(data), true
+}
+
+// setTyped sets a scalar node to the value of a cast placeholder.
+func setTyped(n *yaml.Node, cast, value string) error {
+	switch cast {
+	case CastInt:
+		n.Tag, n.Value, n.Style = "!!int", value, 0
+	case CastBool:
+		n.Tag, n.Value, n.Style = "!!bool", value, 0
+	case CastJSON:
+		var v yaml.Node
+		// JSON is YAML, its scalars are resolved as in JSON
+		if err := yaml.Unmarshal([]byte(value), &v); err != nil {
+			return err
+		}
+		if len(v.Content) == 1 {
+			line, column := n.Line, n.Column
+			*n = *v.Content[0]
+			n.Line, n.Column = line, column
+			clearStyle(n)
+		}
+	default:
+		n.Tag, n.Value = "!!str", value
+	}
+	return nil
+}
+
+// clearStyle lets the encoder choose the style of n and its children,
+// instead of the flow style of JSON.
+func clearStyle(n *yaml.Node) {
+	n.Style &^= yaml.FlowStyle
+	for _, c := range n.Content {
+		clearStyle(c)
+	}
+}
@@ -0,0 +1,186 @@
+package envs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+func substituteYAML(t *testing.T, input string) map[string]interface{} {
+	t.Helper()
+	envsubst := NewEnvsubst([]string{}, []string{"APP_"}, true)
+	out, err := envsubst.SubstituteYAML([]byte(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var got map[string]interface{}
+	if err := yaml.Unmarshal(out, &got); err != nil {
+		t.Fatalf("Invalid YAML %q: %v", out, err)
+	}
+	return got
+}
+
+func TestSubstituteYAML(t *testing.T) {
+	t.Setenv("APP_PASSWORD", "p@ss: word\n  injected: true\n# comment")
+	t.Setenv("APP_REPLICAS", "3")
+	t.Setenv("APP_DEBUG", "1")
+	t.Setenv("APP_CONFIG", `{"level": "info", "ports": [80, 443], "tls": null}`)
+	t.Setenv("APP_LIST", "- a\n- b")
+	t.Setenv("APP_USER", "admin")
+
+	got := substituteYAML(t, `
+password: ${APP_PASSWORD}
+quoted: "${APP_PASSWORD}"
+single: '${APP_PASSWORD}'
+literal: |
+  ${APP_PASSWORD}
+list: ${APP_LIST}
+plainReplicas: ${APP_REPLICAS}
+quotedReplicas: "${APP_REPLICAS}"
+replicas: ${APP_REPLICAS|int}
+quotedCast: "${APP_REPLICAS|int}"
+debug: ${APP_DEBUG|bool}
+config: ${APP_CONFIG|json}
+auth: ${APP_USER|base64}
+header: Basic ${APP_USER|base64}
+defaultCast: ${APP_PORT:-8080|int}
+pipe: ${APP_PORT:-a|b}
+items:
+  - ${APP_USER}
+  - name: ${APP_USER}
+`)
+	want := map[string]interface{}{
+		"password":       "p@ss: word\n  injected: true\n# comment",
+		"quoted":         "p@ss: word\n  injected: true\n# comment",
+		"single":         "p@ss: word\n  injected: true\n# comment",
+		"literal":        "p@ss: word\n  injected: true\n# comment\n",
+		"list":           "- a\n- b",
+		"plainReplicas":  3,
+		"quotedReplicas": "3",
+		"replicas":       3,
+		"quotedCast":     3,
+		"debug":          true,
+		"config": map[string]interface{}{
+			"level": "info",
+			"ports": []interface{}{80, 443},
+			"tls":   nil,
+		},
+		"auth":        "YWRtaW4=",
+		"header":      "Basic YWRtaW4=",
+		"defaultCast": 8080,
+		"pipe":        "a|b",
+		"items":       []interface{}{"admin", map[string]interface{}{"name": "admin"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected\n%#v\ngot\n%#v", want, got)
+	}
+}
+
+func TestSubstituteYAML_Typing(t *testing.T) {
+	tests := []struct {
+		value string
+		plain interface{}
+	}{
+		{value: "3", plain: float64(3)},
+		{value: "true", plain: true},
+		{value: "on", plain: true},
+		{value: "no", plain: false},
+		{value: "0777", plain: float64(511)},
+		{value: "1e3", plain: float64(1000)},
+		{value: "~", plain: nil},
+		{value: "null", plain: nil},
+		{value: "admin", plain: "admin"},
+		{value: "3 # comment", plain: "3 # comment"},
+		{value: "a: b", plain: "a: b"},
+		{value: "[1]", plain: "[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("APP_VALUE", tt.value)
+			envsubst := NewEnvsubst([]string{}, []string{"APP_"}, true)
+			out, err := envsubst.SubstituteYAML([]byte("plain: ${APP_VALUE}\nquoted: \"${APP_VALUE}\"\nprefixed: v${APP_VALUE}\n"))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			// patch-files are read with YAML 1.1 rules, which resolve "on" to a boolean
+			var got map[string]interface{}
+			if err := sigsyaml.Unmarshal(out, &got); err != nil {
+				t.Fatalf("Invalid YAML %q: %v", out, err)
+			}
+			want := map[string]interface{}{"plain": tt.plain, "quoted": tt.value, "prefixed": "v" + tt.value}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected %#v, got %#v from %q", want, got, out)
+			}
+		})
+	}
+}
+
+func TestSubstituteYAML_Keys(t *testing.T) {
+	t.Setenv("APP_NAME", "myapp:\n  injected: {}")
+
+	got := substituteYAML(t, `
+${APP_NAME}:
+  key: value
+`)
+	if len(got) != 1 {
+		t.Fatalf("Expected a single key, got %v", got)
+	}
+	if _, ok := got["myapp:\n  injected: {}"]; !ok {
+		t.Errorf("Expected the value as the key, got %v", got)
+	}
+}
+
+func TestSubstituteYAML_KeepsOtherPlaceholders(t *testing.T) {
+	envsubst := NewEnvsubst([]string{}, []string{"APP_"}, true)
+	input := "# ${APP_IN_COMMENT} is not expanded\ncommand: [sh, -c, 'echo $HOME $(POD_NAME)']\n"
+	out, err := envsubst.SubstituteYAML([]byte(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(out) != input {
+		t.Errorf("Expected %q, got %q", input, out)
+	}
+
+	out, err = envsubst.SubstituteYAML([]byte(""))
+	if err != nil || len(out) != 0 {
+		t.Errorf("Expected an empty document, got %q, %v", out, err)
+	}
+}
+
+func TestSubstituteYAML_Errors(t *testing.T) {
+	t.Setenv("APP_REPLICAS", "three")
+	t.Setenv("APP_DEBUG", "maybe")
+	t.Setenv("APP_CONFIG", "{broken")
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "int", input: "a: 1\nreplicas: ${APP_REPLICAS|int}", wantErr: "line 2: APP_REPLICAS: the value is not an integer"},
+		{name: "bool", input: "debug: ${APP_DEBUG|bool}", wantErr: "APP_DEBUG: the value is not a boolean"},
+		{name: "json", input: "config: ${APP_CONFIG|json}", wantErr: "APP_CONFIG: the value is not valid JSON"},
+		{name: "unknown cast", input: "a: ${APP_DEBUG|yaml}", wantErr: "unknown cast |yaml"},
+		{name: "undefined", input: "a: ${APP_UNSET|int}", wantErr: "undefined variables: [APP_UNSET]"},
+		{name: "required", input: "a: ${APP_UNSET:?is required}", wantErr: "APP_UNSET: is required"},
+		{name: "invalid yaml", input: "a: [", wantErr: "yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envsubst := NewEnvsubst([]string{}, []string{"APP_"}, true)
+			_, err := envsubst.SubstituteYAML([]byte(tt.input))
+			if err == nil {
+				t.Fatalf("Expected error %q, got none", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %q", tt.wantErr, err.Error())
+			}
+			if strings.Contains(err.Error(), "three") || strings.Contains(err.Error(), "maybe") {
+				t.Errorf("Expected the value not to be in the error, got %q", err.Error())
+			}
+		})
+	}
+}
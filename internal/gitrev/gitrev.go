@@ -0,0 +1,186 @@
+package gitrev
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Tree is a read-only copy of the files of a git revision in a temporary directory.
+type Tree struct {
+	// Dir is the temporary directory holding the revision.
+	Dir string
+	// top is the root of the working tree, prefix the current directory relative to it.
+	top, prefix string
+}
+
+// Checkout extracts ref of the git repository containing the current
+// directory. The caller has to Close the tree.
+func Checkout(ref string) (*Tree, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid revision %q", ref)
+	}
+	top, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := git("rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := git("rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		return nil, fmt.Errorf("unknown revision %q", ref)
+	}
+
+	dir, err := os.MkdirTemp("", "kubepatch-ref-")
+	if err != nil {
+		return nil, err
+	}
+	t := &Tree{Dir: dir, top: top, prefix: prefix}
+
+	cmd := exec.Command("git", "archive", "--format=tar", ref)
+	cmd.Dir = top
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		_ = t.Close() //nolint:errcheck
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		_ = t.Close() //nolint:errcheck
+		return nil, fmt.Errorf("git archive: %w", err)
+	}
+	extractErr := extract(out, dir)
+	// drain the pipe, so that git does not block when extraction failed early
+	_, _ = io.Copy(io.Discard, out) //nolint:errcheck
+	if err := cmd.Wait(); err != nil {
+		_ = t.Close() //nolint:errcheck
+		return nil, fmt.Errorf("git archive %s: %w: %s", ref, err, strings.TrimSpace(stderr.String()))
+	}
+	if extractErr != nil {
+		_ = t.Close() //nolint:errcheck
+		return nil, fmt.Errorf("extracting %s: %w", ref, extractErr)
+	}
+	return t, nil
+}
+
+// Path maps a path of the working tree to the same path in the checked out revision.
+// Remote URLs and stdin ("-") are not part of the repository and are rejected.
+func (t *Tree) Path(path string) (string, error) {
+	if path == "-" {
+		return "", errors.New("stdin cannot be read at a git revision")
+	}
+
+	var rel string
+	if filepath.IsAbs(path) {
+		resolved, err := realPath(path)
+		if err != nil {
+			return "", err
+		}
+		rel, err = filepath.Rel(t.top, resolved)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		rel = filepath.Join(filepath.FromSlash(t.prefix), path)
+	}
+	rel = filepath.Clean(rel)
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the git repository %s", path, t.top)
+	}
+	return filepath.Join(t.Dir, rel), nil
+}
+
+// Close removes the temporary directory.
+func (t *Tree) Close() error {
+	return os.RemoveAll(t.Dir)
+}
+
+// realPath resolves symlinks of the longest existing parent of path,
+// so that it can be compared with the top level directory reported by git.
+func realPath(path string) (string, error) {
+	var rest []string
+	for p := path; ; p = filepath.Dir(p) {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if filepath.Dir(p) == p {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(p)}, rest...)
+	}
+}
+
+func extract(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("unexpected path %q in archive", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o750); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close() //nolint:errcheck
+		return err
+	}
+	return f.Close()
+}
+
+func git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), msg)
+		}
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,82 @@
+package gitrev
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	run("init", "-q")
+	write("k8s/base/cm.yaml", "old\n")
+	write("k8s/patches/prod.yaml", "old\n")
+	run("add", "-A")
+	run("commit", "-q", "-m", "first")
+	run("tag", "v1")
+	write("k8s/base/cm.yaml", "new\n")
+	run("commit", "-q", "-a", "-m", "second")
+	return dir
+}
+
+func TestCheckout(t *testing.T) {
+	dir := initRepo(t)
+	t.Chdir(filepath.Join(dir, "k8s"))
+
+	tree, err := Checkout("v1")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, tree.Close())
+		_, err := os.Stat(tree.Dir)
+		assert.True(t, os.IsNotExist(err))
+	}()
+
+	path, err := tree.Path("base/cm.yaml")
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "old\n", string(content))
+
+	path, err = tree.Path(filepath.Join(dir, "k8s", "patches", "prod.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tree.Dir, "k8s", "patches", "prod.yaml"), path)
+
+	_, err = tree.Path("../../outside.yaml")
+	assert.ErrorContains(t, err, "outside of the git repository")
+	_, err = tree.Path("-")
+	assert.Error(t, err)
+}
+
+func TestCheckout_UnknownRevision(t *testing.T) {
+	dir := initRepo(t)
+	t.Chdir(dir)
+
+	_, err := Checkout("does-not-exist")
+	assert.ErrorContains(t, err, `unknown revision "does-not-exist"`)
+	_, err = Checkout("--output=/tmp/x")
+	assert.ErrorContains(t, err, "invalid revision")
+}
@@ -79,6 +79,29 @@ var labelFieldSpecs = []fieldSpec{
 	{Path: "spec/egress/to/podSelector/matchLabels", Create: false, Kind: "NetworkPolicy", Group: "networking.k8s.io"},
 }
 
+// podTemplateLabels is the suffix of label paths that live inside a pod template.
+const podTemplateLabels = "template/metadata/labels"
+
+// PodSpecPaths returns the location of every pod spec in obj: the pod templates
+// of the workload kinds listed in labelFieldSpecs, or the spec of a bare Pod.
+func PodSpecPaths(obj *unstructured.Unstructured) [][]string {
+	if obj.GetKind() == "Pod" && obj.GetAPIVersion() == "v1" {
+		return [][]string{{"spec"}}
+	}
+	var paths [][]string
+	for _, spec := range labelFieldSpecs {
+		if spec.Path != podTemplateLabels && !strings.HasSuffix(spec.Path, "/"+podTemplateLabels) {
+			continue
+		}
+		if !matchGVK(obj, spec) {
+			continue
+		}
+		templatePath := strings.TrimSuffix(spec.Path, "/metadata/labels")
+		paths = append(paths, append(strings.Split(templatePath, "/"), "spec"))
+	}
+	return paths
+}
+
 func matchGVK(obj *unstructured.Unstructured, spec fieldSpec) bool {
 	if obj.GetKind() != spec.Kind {
 		return false
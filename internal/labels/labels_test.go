@@ -597,3 +597,31 @@ spec:
 // 	assert.NoError(t, err)
 // 	assert.YAMLEq(t, expected, string(out))
 // }
+
+func TestPodSpecPaths(t *testing.T) {
+	tests := []struct {
+		apiVersion string
+		kind       string
+		expected   [][]string
+	}{
+		{"apps/v1", "Deployment", [][]string{{"spec", "template", "spec"}}},
+		{"apps/v1", "StatefulSet", [][]string{{"spec", "template", "spec"}}},
+		{"apps/v1", "DaemonSet", [][]string{{"spec", "template", "spec"}}},
+		{"apps/v1", "ReplicaSet", [][]string{{"spec", "template", "spec"}}},
+		{"v1", "ReplicationController", [][]string{{"spec", "template", "spec"}}},
+		{"batch/v1", "Job", [][]string{{"spec", "template", "spec"}}},
+		{"batch/v1", "CronJob", [][]string{{"spec", "jobTemplate", "spec", "template", "spec"}}},
+		{"v1", "Pod", [][]string{{"spec"}}},
+		{"v1", "Service", nil},
+		{"v1", "ConfigMap", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			obj := &unstructured.Unstructured{}
+			obj.SetAPIVersion(tt.apiVersion)
+			obj.SetKind(tt.kind)
+			assert.Equal(t, tt.expected, PodSpecPaths(obj))
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats.
+const (
+	// FormatYAML is a stream of "---" separated YAML documents.
+	FormatYAML = "yaml"
+	// FormatJSON is a stream of indented JSON documents.
+	FormatJSON = "json"
+	// FormatJSONL is one compact JSON document per line.
+	FormatJSONL = "jsonl"
+	// FormatList is a single v1/List object in YAML.
+	FormatList = "list"
+)
+
+// Directory groupings.
+const (
+	GroupByNamespace = "namespace"
+	GroupByApp       = "app"
+)
+
+// AppLabel is the label objects are grouped by with GroupByApp.
+const AppLabel = "app.kubernetes.io/name"
+
+// Encoder encodes objects in one of the output formats.
+type Encoder struct {
+	Format string
+	// Bases are the YAML documents the objects were read from, indexed like
+	// the objects; entries may be nil. YAML output of an object with a base
+	// keeps the key order, comments and quoting of the base and only changes
+	// the nodes that differ.
+	Bases []*yamlv3.Node
+
+	// Indent is the indentation width of YAML output; 0 means 2.
+	Indent int
+	// LiteralBlocks prints multi-line strings of YAML output as literal block scalars.
+	LiteralBlocks bool
+	// DropNulls removes null values from maps and an empty metadata.creationTimestamp.
+	DropNulls bool
+	// CanonicalQuantities rewrites resource quantities in canonical form, e.g. "0.5" CPUs as "500m".
+	CanonicalQuantities bool
+}
+
+// Marshal encodes objects in the given format.
+func Marshal(objects []*unstructured.Unstructured, format string) ([]byte, error) {
+	return Encoder{Format: format}.Marshal(objects)
+}
+
+// Marshal encodes objects.
+func (e Encoder) Marshal(objects []*unstructured.Unstructured) ([]byte, error) {
+	var buf bytes.Buffer
+	switch e.Format {
+	case FormatYAML:
+		for i, obj := range objects {
+			out, err := e.yaml(i, obj)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString("---\n")
+			buf.Write(out)
+		}
+	case FormatJSON:
+		for _, obj := range objects {
+			out, err := json.MarshalIndent(e.normalize(obj).Object, "", "  ")
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(out)
+			buf.WriteByte('\n')
+		}
+	case FormatJSONL:
+		for _, obj := range objects {
+			out, err := json.Marshal(e.normalize(obj).Object)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(out)
+			buf.WriteByte('\n')
+		}
+	case FormatList:
+		items := make([]interface{}, len(objects))
+		for i, obj := range objects {
+			items[i] = e.normalize(obj).Object
+		}
+		out, err := yaml.Marshal(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"items":      items,
+		})
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(out)
+	default:
+		return nil, fmt.Errorf("unknown output format %q, expected %s, %s, %s or %s",
+			e.Format, FormatYAML, FormatJSON, FormatJSONL, FormatList)
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFile replaces path with data atomically: data is written to a
+// temporary file in the same directory, which is then renamed to path,
+// so readers never see a partially written file. An existing file keeps its
+// mode, a new one is only readable by the user as it may hold Secrets.
+func WriteFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// no-op once renamed
+		_ = os.Remove(tmp.Name()) //nolint:errcheck
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// the temporary file is created with mode 0600
+	if info, err := os.Stat(path); err == nil {
+		if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteDir writes every object to its own file "<kind>-<name>.<ext>" below
+// dir, optionally in a sub-directory per namespace or app (see GroupByNamespace
+// and GroupByApp). Objects without a namespace or app label are written to
+// dir itself. It returns the written paths.
+func (e Encoder) WriteDir(dir string, objects []*unstructured.Unstructured, groupBy string) ([]string, error) {
+	var ext string
+	switch e.Format {
+	case FormatYAML:
+		ext = ".yaml"
+	case FormatJSON:
+		ext = ".json"
+	default:
+		return nil, fmt.Errorf("output format %q cannot be written to a directory, use %s or %s", e.Format, FormatYAML, FormatJSON)
+	}
+
+	files := make([]string, len(objects))
+	seen := map[string]int{}
+	for i, obj := range objects {
+		group, err := groupOf(obj, groupBy)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.ToLower(obj.GetKind()) + "-" + obj.GetName() + ext
+		if strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("%q cannot be used as a file name", name)
+		}
+		path := filepath.Join(dir, group, name)
+		if prev, ok := seen[path]; ok {
+			return nil, fmt.Errorf("objects %d and %d would both be written to %s, group them with a namespace or app directory", prev+1, i+1, path)
+		}
+		seen[path] = i
+		files[i] = path
+	}
+
+	for i, obj := range objects {
+		data, err := e.marshalOne(i, obj)
+		if err != nil {
+			return nil, err
+		}
+		if err := WriteFile(files[i], data); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func (e Encoder) marshalOne(i int, obj *unstructured.Unstructured) ([]byte, error) {
+	if e.Format == FormatJSON {
+		return e.Marshal([]*unstructured.Unstructured{obj})
+	}
+	return e.yaml(i, obj)
+}
+
+// yaml encodes the i-th object, merged into its base document if there is one.
+func (e Encoder) yaml(i int, obj *unstructured.Unstructured) ([]byte, error) {
+	obj = e.normalize(obj)
+	if i < len(e.Bases) && e.Bases[i] != nil {
+		doc, err := MergeNode(e.Bases[i], obj.Object)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", obj.GetKind(), obj.GetName(), err)
+		}
+		return e.encodeYAML(doc)
+	}
+	if e.styled() {
+		doc := &yamlv3.Node{}
+		if err := doc.Encode(obj.Object); err != nil {
+			return nil, err
+		}
+		return e.encodeYAML(&yamlv3.Node{Kind: yamlv3.DocumentNode, Content: []*yamlv3.Node{doc}})
+	}
+	return yaml.Marshal(obj)
+}
+
+func groupOf(obj *unstructured.Unstructured, groupBy string) (string, error) {
+	var group string
+	switch groupBy {
+	case "":
+		return "", nil
+	case GroupByNamespace:
+		group = obj.GetNamespace()
+	case GroupByApp:
+		group = obj.GetLabels()[AppLabel]
+	default:
+		return "", fmt.Errorf("unknown grouping %q, expected %s or %s", groupBy, GroupByNamespace, GroupByApp)
+	}
+	if strings.ContainsAny(group, `/\`) || group == "." || group == ".." {
+		return "", fmt.Errorf("%q cannot be used as a directory name", group)
+	}
+	return group, nil
+}
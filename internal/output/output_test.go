@@ -0,0 +1,154 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func testObjects() []*unstructured.Unstructured {
+	obj := func(kind, namespace, name, app string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion("v1")
+		u.SetKind(kind)
+		u.SetName(name)
+		u.SetNamespace(namespace)
+		if app != "" {
+			u.SetLabels(map[string]string{AppLabel: app})
+		}
+		return u
+	}
+	return []*unstructured.Unstructured{
+		obj("ConfigMap", "prod", "web", "web"),
+		obj("Namespace", "", "prod", ""),
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: FormatYAML,
+			expected: "---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  labels:\n    app.kubernetes.io/name: web\n  name: web\n  namespace: prod\n" +
+				"---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: prod\n",
+		},
+		{
+			format: FormatJSONL,
+			expected: `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"labels":{"app.kubernetes.io/name":"web"},"name":"web","namespace":"prod"}}` + "\n" +
+				`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"prod"}}` + "\n",
+		},
+		{
+			format: FormatJSON,
+			expected: "{\n  \"apiVersion\": \"v1\",\n  \"kind\": \"ConfigMap\",\n  \"metadata\": {\n    \"labels\": {\n      \"app.kubernetes.io/name\": \"web\"\n    },\n" +
+				"    \"name\": \"web\",\n    \"namespace\": \"prod\"\n  }\n}\n" +
+				"{\n  \"apiVersion\": \"v1\",\n  \"kind\": \"Namespace\",\n  \"metadata\": {\n    \"name\": \"prod\"\n  }\n}\n",
+		},
+		{
+			format: FormatList,
+			expected: "apiVersion: v1\nitems:\n" +
+				"- apiVersion: v1\n  kind: ConfigMap\n  metadata:\n    labels:\n      app.kubernetes.io/name: web\n    name: web\n    namespace: prod\n" +
+				"- apiVersion: v1\n  kind: Namespace\n  metadata:\n    name: prod\nkind: List\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			out, err := Marshal(testObjects(), tt.format)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(out))
+		})
+	}
+
+	_, err := Marshal(testObjects(), "toml")
+	assert.Error(t, err)
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out", "manifests.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o750))
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0o600))
+
+	require.NoError(t, WriteFile(path, []byte("new")))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left")
+}
+
+func TestWriteFile_Mode(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.yaml")
+	require.NoError(t, os.WriteFile(existing, []byte("old"), 0o600))
+	require.NoError(t, os.Chmod(existing, 0o640))
+
+	require.NoError(t, WriteFile(existing, []byte("new")))
+	info, err := os.Stat(existing)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm(), "an existing file keeps its mode")
+
+	created := filepath.Join(dir, "created.yaml")
+	require.NoError(t, WriteFile(created, []byte("new")))
+	info, err = os.Stat(created)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "a new file is private")
+}
+
+func TestWriteDir(t *testing.T) {
+	tests := []struct {
+		groupBy  string
+		format   string
+		expected []string
+	}{
+		{format: FormatYAML, expected: []string{"configmap-web.yaml", "namespace-prod.yaml"}},
+		{format: FormatJSON, expected: []string{"configmap-web.json", "namespace-prod.json"}},
+		{groupBy: GroupByNamespace, format: FormatYAML, expected: []string{"prod/configmap-web.yaml", "namespace-prod.yaml"}},
+		{groupBy: GroupByApp, format: FormatYAML, expected: []string{"web/configmap-web.yaml", "namespace-prod.yaml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.groupBy+tt.format, func(t *testing.T) {
+			dir := t.TempDir()
+			files, err := Encoder{Format: tt.format}.WriteDir(dir, testObjects(), tt.groupBy)
+			require.NoError(t, err)
+			require.Len(t, files, len(tt.expected))
+			for i, f := range tt.expected {
+				assert.Equal(t, filepath.Join(dir, filepath.FromSlash(f)), files[i])
+				assert.FileExists(t, files[i])
+			}
+		})
+	}
+
+	content, err := os.ReadFile(filepath.Join(func() string {
+		dir := t.TempDir()
+		_, err := Encoder{Format: FormatYAML}.WriteDir(dir, testObjects()[1:], "")
+		require.NoError(t, err)
+		return dir
+	}(), "namespace-prod.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: prod\n", string(content))
+}
+
+func TestWriteDir_Errors(t *testing.T) {
+	objs := testObjects()
+	dup := objs[0].DeepCopy()
+	dup.SetNamespace("dev")
+
+	_, err := Encoder{Format: FormatYAML}.WriteDir(t.TempDir(), append(objs, dup), "")
+	assert.ErrorContains(t, err, "would both be written to")
+
+	_, err = Encoder{Format: FormatYAML}.WriteDir(t.TempDir(), append(objs, dup), GroupByNamespace)
+	assert.NoError(t, err)
+
+	_, err = Encoder{Format: FormatList}.WriteDir(t.TempDir(), objs, "")
+	assert.Error(t, err)
+
+	_, err = Encoder{Format: FormatYAML}.WriteDir(t.TempDir(), objs, "team")
+	assert.Error(t, err)
+}
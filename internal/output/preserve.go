@@ -0,0 +1,184 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/kubepatch/kubepatch/internal/unstr"
+	"gopkg.in/yaml.v3"
+)
+
+// MergeNode returns a copy of the base YAML document with the values of
+// value, e.g. a patched object. Nodes whose value did not change keep their
+// position, comments and quoting; new map keys are appended in sorted order.
+func MergeNode(base *yaml.Node, value interface{}) (*yaml.Node, error) {
+	doc := copyNode(base)
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 {
+		return nil, fmt.Errorf("expected a YAML document node")
+	}
+	merged, err := mergeValue(doc.Content[0], value)
+	if err != nil {
+		return nil, err
+	}
+	doc.Content[0] = merged
+	return doc, nil
+}
+
+func mergeValue(node *yaml.Node, value interface{}) (*yaml.Node, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if node.Kind == yaml.MappingNode {
+			return mergeMapping(node, v)
+		}
+	case []interface{}:
+		if node.Kind == yaml.SequenceNode {
+			return mergeSequence(node, v)
+		}
+	default:
+		if node.Kind == yaml.ScalarNode || node.Kind == yaml.AliasNode {
+			same, err := sameScalar(node, v)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				return node, nil
+			}
+		}
+	}
+	return replaceNode(node, value)
+}
+
+func mergeMapping(node *yaml.Node, value map[string]interface{}) (*yaml.Node, error) {
+	wasEmpty := len(node.Content) == 0
+	content := make([]*yaml.Node, 0, len(node.Content))
+	seen := make(map[string]bool, len(value))
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, val := node.Content[i], node.Content[i+1]
+		v, ok := value[key.Value]
+		if !ok || seen[key.Value] {
+			continue // removed by a patch
+		}
+		seen[key.Value] = true
+		merged, err := mergeValue(val, v)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, key, merged)
+	}
+
+	added := make([]string, 0, len(value))
+	for k := range value {
+		if !seen[k] {
+			added = append(added, k)
+		}
+	}
+	sort.Strings(added)
+	for _, k := range added {
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}
+		val, err := encodeNode(value[k])
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, key, val)
+	}
+
+	node.Content = content
+	blockIfFilled(node, wasEmpty)
+	return node, nil
+}
+
+func mergeSequence(node *yaml.Node, value []interface{}) (*yaml.Node, error) {
+	wasEmpty := len(node.Content) == 0
+	content := make([]*yaml.Node, 0, len(value))
+	for i, v := range value {
+		if i < len(node.Content) {
+			merged, err := mergeValue(node.Content[i], v)
+			if err != nil {
+				return nil, err
+			}
+			content = append(content, merged)
+			continue
+		}
+		n, err := encodeNode(v)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, n)
+	}
+	node.Content = content
+	blockIfFilled(node, wasEmpty)
+	return node, nil
+}
+
+// blockIfFilled prints a "{}" or "[]" of the base filled by a patch as a block.
+func blockIfFilled(node *yaml.Node, wasEmpty bool) {
+	if wasEmpty && len(node.Content) > 0 {
+		node.Style &^= yaml.FlowStyle
+	}
+}
+
+// sameScalar tells whether node holds value. Values are compared by their
+// JSON form, so that e.g. "1.0" in the base equals the float 1 of the object.
+func sameScalar(node *yaml.Node, value interface{}) (bool, error) {
+	var decoded interface{}
+	if err := node.Decode(&decoded); err != nil {
+		return false, err
+	}
+	a, err := json.Marshal(decoded)
+	if err != nil {
+		return false, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(a, b), nil
+}
+
+// replaceNode encodes value into a new node, keeping the comments of node
+// and, for strings replacing strings, its quoting style. A string that YAML
+// 1.1 readers like kubectl would not read back as a string, e.g. "no" or
+// "0777", is always quoted.
+func replaceNode(node *yaml.Node, value interface{}) (*yaml.Node, error) {
+	n, err := encodeNode(value)
+	if err != nil {
+		return nil, err
+	}
+	n.HeadComment = node.HeadComment
+	n.LineComment = node.LineComment
+	n.FootComment = node.FootComment
+	if n.Kind != yaml.ScalarNode || n.ShortTag() != "!!str" {
+		return n, nil
+	}
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str" {
+		n.Style = node.Style
+	}
+	if n.Style == 0 && unstr.Retyped(n.Value) {
+		n.Style = yaml.DoubleQuotedStyle
+	}
+	return n, nil
+}
+
+func encodeNode(value interface{}) (*yaml.Node, error) {
+	n := &yaml.Node{}
+	if err := n.Encode(value); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func copyNode(n *yaml.Node) *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	c := *n
+	if n.Content != nil {
+		c.Content = make([]*yaml.Node, len(n.Content))
+		for i, child := range n.Content {
+			c.Content[i] = copyNode(child)
+		}
+	}
+	return &c
+}
@@ -0,0 +1,122 @@
+package output
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubepatch/kubepatch/internal/unstr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestEncoder_Bases(t *testing.T) {
+	base := `# web frontend
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web # renamed per environment
+  annotations: {}
+spec:
+  replicas: 1
+  ratio: 1.0
+  template:
+    spec:
+      containers:
+        - name: web
+          image: "nginx:1.25"
+          args: ['--port', "8080"]
+          env:
+            - name: MODE
+              value: 'dev'
+            - name: OLD
+              value: x
+`
+	objects, err := unstr.ReadObjects(strings.NewReader(base))
+	require.NoError(t, err)
+	nodes := unstr.ReadNodes([]byte(base), objects)
+	require.Len(t, nodes, 1)
+
+	obj := objects[0]
+	obj.SetName("web-prod")
+	obj.SetAnnotations(map[string]string{"team": "web"})
+	obj.SetLabels(map[string]string{"app.kubernetes.io/name": "web-prod"})
+	require.NoError(t, unstructured.SetNestedField(obj.Object, int64(3), "spec", "replicas"))
+	require.NoError(t, unstructured.SetNestedSlice(obj.Object, []interface{}{
+		map[string]interface{}{
+			"name":  "web",
+			"image": "nginx:1.25",
+			"args":  []interface{}{"--port", "8080"},
+			"env":   []interface{}{map[string]interface{}{"name": "MODE", "value": "prod"}},
+		},
+	}, "spec", "template", "spec", "containers"))
+
+	out, err := Encoder{Format: FormatYAML, Bases: []*yaml.Node{nodes[0]}}.Marshal(objects)
+	require.NoError(t, err)
+	assert.Equal(t, `---
+# web frontend
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web-prod # renamed per environment
+  annotations:
+    team: web
+  labels:
+    app.kubernetes.io/name: web-prod
+spec:
+  replicas: 3
+  ratio: 1.0
+  template:
+    spec:
+      containers:
+        - name: web
+          image: "nginx:1.25"
+          args: ['--port', "8080"]
+          env:
+            - name: MODE
+              value: 'prod'
+`, string(out))
+}
+
+func TestEncoder_BasesOptional(t *testing.T) {
+	out, err := Encoder{Format: FormatYAML, Bases: []*yaml.Node{nil}}.Marshal(testObjects())
+	require.NoError(t, err)
+	expected, err := Marshal(testObjects(), FormatYAML)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(out))
+}
+
+func TestEncoder_BasesRetypedStrings(t *testing.T) {
+	base := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: flags
+data:
+  other: x
+  toggle: x
+  agree: 'x'
+  mode: x
+  count: x
+`
+	objects, err := unstr.ReadObjects(strings.NewReader(base))
+	require.NoError(t, err)
+	nodes := unstr.ReadNodes([]byte(base), objects)
+	require.Len(t, nodes, 1)
+
+	data := map[string]interface{}{
+		"other": "no", "toggle": "on", "agree": "yes", "mode": "0777", "count": "1e3", "added": "off",
+	}
+	require.NoError(t, unstructured.SetNestedField(objects[0].Object, data, "data"))
+
+	out, err := Encoder{Format: FormatYAML, Bases: []*yaml.Node{nodes[0]}}.Marshal(objects)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "  other: \"no\"\n")
+	assert.Contains(t, string(out), "  agree: 'yes'\n")
+
+	// kubectl reads manifests with YAML 1.1 rules, where a plain no is a boolean
+	reread, err := unstr.ReadObjects(strings.NewReader(string(out)))
+	require.NoError(t, err)
+	require.Len(t, reread, 1)
+	assert.Equal(t, data, reread[0].Object["data"])
+}
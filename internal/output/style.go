@@ -0,0 +1,176 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/kubepatch/kubepatch/internal/labels"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// defaultIndent is the indentation of the YAML output.
+const defaultIndent = 2
+
+// normalize applies DropNulls and CanonicalQuantities to a copy of obj.
+func (e Encoder) normalize(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if !e.DropNulls && !e.CanonicalQuantities {
+		return obj
+	}
+	obj = obj.DeepCopy()
+	if e.DropNulls {
+		dropNulls(obj.Object)
+		if ts, ok := nested(obj.Object, "metadata", "creationTimestamp").(string); ok && ts == "" {
+			unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+		}
+	}
+	if e.CanonicalQuantities {
+		canonicalQuantities(obj)
+	}
+	return obj
+}
+
+// dropNulls removes null values from maps, recursively. Nulls in lists are
+// kept, since removing them would shift the following elements.
+func dropNulls(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if item == nil {
+				delete(v, k)
+				continue
+			}
+			dropNulls(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			dropNulls(item)
+		}
+	}
+}
+
+// canonicalQuantities rewrites the string quantities of container resources,
+// PersistentVolumeClaims, ResourceQuotas and LimitRanges in canonical form,
+// e.g. "0.5" as "500m" and "1024Mi" as "1Gi". Invalid quantities are kept.
+func canonicalQuantities(obj *unstructured.Unstructured) {
+	var maps []map[string]interface{}
+	for _, path := range labels.PodSpecPaths(obj) {
+		podSpec, ok := nested(obj.Object, path...).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+			if containers, ok := podSpec[field].([]interface{}); ok {
+				for _, c := range containers {
+					maps = append(maps, resourceMaps(c)...)
+				}
+			}
+		}
+	}
+
+	switch strings.ToLower(obj.GetKind()) {
+	case "persistentvolumeclaim":
+		maps = append(maps, resourceMaps(nested(obj.Object, "spec"))...)
+	case "statefulset":
+		if templates, ok := nested(obj.Object, "spec", "volumeClaimTemplates").([]interface{}); ok {
+			for _, t := range templates {
+				maps = append(maps, resourceMaps(nested(t, "spec"))...)
+			}
+		}
+	case "resourcequota":
+		if hard, ok := nested(obj.Object, "spec", "hard").(map[string]interface{}); ok {
+			maps = append(maps, hard)
+		}
+	case "limitrange":
+		if limits, ok := nested(obj.Object, "spec", "limits").([]interface{}); ok {
+			for _, l := range limits {
+				for _, field := range []string{"max", "min", "default", "defaultRequest", "maxLimitRequestRatio"} {
+					if m, ok := nested(l, field).(map[string]interface{}); ok {
+						maps = append(maps, m)
+					}
+				}
+			}
+		}
+	}
+
+	for _, m := range maps {
+		for k, v := range m {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			if q, err := resource.ParseQuantity(s); err == nil {
+				m[k] = q.String()
+			}
+		}
+	}
+}
+
+// nested returns the value at fields below node without copying it, or nil.
+func nested(node interface{}, fields ...string) interface{} {
+	for _, f := range fields {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = m[f]
+	}
+	return node
+}
+
+// resourceMaps returns the limits and requests of the "resources" field of node.
+func resourceMaps(node interface{}) []map[string]interface{} {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	resources, ok := m["resources"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var maps []map[string]interface{}
+	for _, field := range []string{"limits", "requests"} {
+		if values, ok := resources[field].(map[string]interface{}); ok {
+			maps = append(maps, values)
+		}
+	}
+	return maps
+}
+
+// styled reports whether YAML output needs the node encoder instead of sigs.k8s.io/yaml.
+func (e Encoder) styled() bool {
+	return e.LiteralBlocks || (e.Indent != 0 && e.Indent != defaultIndent)
+}
+
+// encodeYAML encodes a document node with the indentation and scalar styles of e.
+func (e Encoder) encodeYAML(doc *yaml.Node) ([]byte, error) {
+	if e.LiteralBlocks {
+		literalBlocks(doc)
+	}
+	indent := e.Indent
+	if indent == 0 {
+		indent = defaultIndent
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(indent)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// literalBlocks switches multi-line strings to literal block style.
+func literalBlocks(n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!str" && strings.Contains(strings.TrimSuffix(n.Value, "\n"), "\n") {
+		n.Style = yaml.LiteralStyle
+	}
+	for _, c := range n.Content {
+		literalBlocks(c)
+	}
+}
@@ -0,0 +1,142 @@
+package output
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubepatch/kubepatch/internal/unstr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func readObjects(t *testing.T, s string) []*unstructured.Unstructured {
+	t.Helper()
+	objs, err := unstr.ReadObjects(strings.NewReader(s))
+	require.NoError(t, err)
+	return objs
+}
+
+func TestEncoder_Style(t *testing.T) {
+	objects := readObjects(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: pg
+  creationTimestamp: null
+  annotations:
+    note: null
+data:
+  postgresql.conf: "max_connections = 100\nshared_buffers = 128MB\n"
+  single: one line
+`)
+
+	tests := []struct {
+		name     string
+		enc      Encoder
+		expected string
+	}{
+		{
+			name: "default",
+			enc:  Encoder{Format: FormatYAML},
+			expected: `---
+apiVersion: v1
+data:
+  postgresql.conf: |
+    max_connections = 100
+    shared_buffers = 128MB
+  single: one line
+kind: ConfigMap
+metadata:
+  annotations:
+    note: null
+  creationTimestamp: null
+  name: pg
+`,
+		},
+		{
+			name: "literal blocks, nulls dropped, indent 4",
+			enc:  Encoder{Format: FormatYAML, LiteralBlocks: true, DropNulls: true, Indent: 4},
+			expected: `---
+apiVersion: v1
+data:
+    postgresql.conf: |
+        max_connections = 100
+        shared_buffers = 128MB
+    single: one line
+kind: ConfigMap
+metadata:
+    annotations: {}
+    name: pg
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.enc.Marshal(objects)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(out))
+		})
+	}
+	assert.Contains(t, objects[0].Object["metadata"], "creationTimestamp", "the input is not modified")
+}
+
+func TestEncoder_LiteralBlocksWithoutTrailingNewline(t *testing.T) {
+	objects := readObjects(t, `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "a"}, "data": {"x": "a\nb"}}`)
+
+	out, err := Encoder{Format: FormatYAML, LiteralBlocks: true}.Marshal(objects)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "  x: |-\n    a\n    b\n")
+}
+
+func TestEncoder_CanonicalQuantities(t *testing.T) {
+	objects := readObjects(t, `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          resources:
+            requests:
+              cpu: "0.1"
+      containers:
+        - name: db
+          resources:
+            limits:
+              cpu: 1000m
+              memory: 1024Mi
+              nvidia.com/gpu: 1
+            requests:
+              memory: not-a-quantity
+  volumeClaimTemplates:
+    - metadata:
+        name: data
+      spec:
+        resources:
+          requests:
+            storage: 10240Mi
+---
+apiVersion: v1
+kind: ResourceQuota
+metadata:
+  name: quota
+spec:
+  hard:
+    requests.cpu: "2.5"
+    pods: "10"
+`)
+
+	out, err := Encoder{Format: FormatJSONL, CanonicalQuantities: true}.Marshal(objects)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"requests":{"cpu":"100m"}`)
+	assert.Contains(t, lines[0], `"limits":{"cpu":"1","memory":"1Gi","nvidia.com/gpu":1}`)
+	assert.Contains(t, lines[0], `"requests":{"memory":"not-a-quantity"}`)
+	assert.Contains(t, lines[0], `"storage":"10Gi"`)
+	assert.Contains(t, lines[1], `"hard":{"pods":"10","requests.cpu":"2500m"}`)
+}
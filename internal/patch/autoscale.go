@@ -0,0 +1,101 @@
+package patch
+
+import (
+	"log"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const replicasPath = "/spec/replicas"
+
+// scaleTarget identifies a workload scaled by an autoscaler.
+type scaleTarget struct {
+	namespace, kind, name string
+	scaler                string // "kind/name" of the autoscaler
+}
+
+// dropAutoscaledReplicas removes spec.replicas from every workload targeted by
+// a HorizontalPodAutoscaler or a KEDA ScaledObject among the manifests, so that
+// applying the manifests does not fight the autoscaler. explicit holds indexes of
+// manifests whose replicas were set by the patch-file; dropping those is reported.
+func dropAutoscaledReplicas(manifests []*unstructured.Unstructured, explicit map[int]bool, trace *Trace) {
+	targets := autoscalerTargets(manifests)
+	if len(targets) == 0 {
+		return
+	}
+	for i, doc := range manifests {
+		for _, target := range targets {
+			if !target.matches(doc) {
+				continue
+			}
+			if _, found, err := unstructured.NestedFieldNoCopy(doc.Object, "spec", "replicas"); err != nil || !found {
+				break
+			}
+			unstructured.RemoveNestedField(doc.Object, "spec", "replicas")
+			trace.note(i, "spec.replicas removed, the workload is scaled by %s", target.scaler)
+			if explicit[i] {
+				log.Printf("WARNING: %s/%s: spec.replicas set by the patch-file is dropped, the workload is scaled by %s",
+					strings.ToLower(doc.GetKind()), doc.GetName(), target.scaler)
+			}
+			break
+		}
+	}
+}
+
+// autoscalerTargets collects the scale targets of HPAs and KEDA ScaledObjects.
+func autoscalerTargets(manifests []*unstructured.Unstructured) []scaleTarget {
+	var targets []scaleTarget
+	for _, doc := range manifests {
+		var defaultKind string
+		switch {
+		case isHPA(doc):
+		case isScaledObject(doc):
+			defaultKind = "Deployment" // KEDA defaults scaleTargetRef.kind to Deployment
+		default:
+			continue
+		}
+		ref, found, err := unstructured.NestedStringMap(doc.Object, "spec", "scaleTargetRef")
+		if err != nil || !found || ref["name"] == "" {
+			continue
+		}
+		kind := ref["kind"]
+		if kind == "" {
+			kind = defaultKind
+		}
+		targets = append(targets, scaleTarget{
+			namespace: doc.GetNamespace(),
+			kind:      kind,
+			name:      ref["name"],
+			scaler:    strings.ToLower(doc.GetKind()) + "/" + doc.GetName(),
+		})
+	}
+	return targets
+}
+
+func (t scaleTarget) matches(doc *unstructured.Unstructured) bool {
+	return strings.EqualFold(doc.GetKind(), t.kind) &&
+		doc.GetName() == t.name &&
+		doc.GetNamespace() == t.namespace
+}
+
+func isHPA(doc *unstructured.Unstructured) bool {
+	return doc.GetKind() == "HorizontalPodAutoscaler" && strings.HasPrefix(doc.GetAPIVersion(), "autoscaling/")
+}
+
+func isScaledObject(doc *unstructured.Unstructured) bool {
+	return doc.GetKind() == "ScaledObject" && strings.HasPrefix(doc.GetAPIVersion(), "keda.sh/")
+}
+
+// setsPath reports whether any add/replace/copy/move operation writes to path.
+func setsPath(ops []Operation, path string) bool {
+	for _, op := range ops {
+		switch op.Op {
+		case "add", "replace", "copy", "move":
+			if op.Path == path {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package patch
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func autoscaledManifests() []*unstructured.Unstructured {
+	return []*unstructured.Unstructured{
+		mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+`),
+		mustObj(`
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  replicas: 1
+`),
+		mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+spec:
+  replicas: 1
+`),
+		mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: static
+spec:
+  replicas: 1
+`),
+		mustObj(`
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+spec:
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web
+`),
+		mustObj(`
+apiVersion: autoscaling/v1
+kind: HorizontalPodAutoscaler
+metadata:
+  name: db
+spec:
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: StatefulSet
+    name: db
+`),
+		mustObj(`
+apiVersion: keda.sh/v1alpha1
+kind: ScaledObject
+metadata:
+  name: worker
+spec:
+  scaleTargetRef:
+    name: worker
+`),
+	}
+}
+
+func Test_Render_DropAutoscaledReplicas(t *testing.T) {
+	patchFile := FullPatchFile{
+		"web": {Resources: ResourcePatches{
+			"deployment/web": {
+				{Op: "replace", Path: "/spec/replicas", Value: 3},
+			},
+			"horizontalpodautoscaler/web": {
+				{Op: "replace", Path: "/spec/scaleTargetRef/name", Value: "web"},
+			},
+		}},
+	}
+
+	var logBuffer strings.Builder
+	log.SetOutput(&logBuffer)
+	defer log.SetOutput(os.Stderr)
+
+	rendered, err := Render(autoscaledManifests(), patchFile, Options{DropAutoscaledReplicas: true})
+	require.NoError(t, err)
+
+	for i, want := range []bool{false, false, false, true} {
+		_, found, err := unstructured.NestedFieldNoCopy(rendered[i].Object, "spec", "replicas")
+		require.NoError(t, err)
+		assert.Equal(t, want, found, rendered[i].GetName())
+	}
+
+	assert.Contains(t, logBuffer.String(),
+		"WARNING: deployment/web: spec.replicas set by the patch-file is dropped, the workload is scaled by horizontalpodautoscaler/web")
+	assert.NotContains(t, logBuffer.String(), "statefulset/db")
+}
+
+func Test_Render_KeepReplicasByDefault(t *testing.T) {
+	rendered, err := Render(autoscaledManifests(), FullPatchFile{}, Options{})
+	require.NoError(t, err)
+
+	for _, doc := range rendered[:4] {
+		_, found, err := unstructured.NestedFieldNoCopy(doc.Object, "spec", "replicas")
+		require.NoError(t, err)
+		assert.True(t, found, doc.GetName())
+	}
+}
+
+func Test_Render_DropAutoscaledReplicasNamespaced(t *testing.T) {
+	manifests := autoscaledManifests()
+	manifests[4].SetNamespace("other")
+
+	rendered, err := Render(manifests, FullPatchFile{}, Options{DropAutoscaledReplicas: true})
+	require.NoError(t, err)
+
+	_, found, err := unstructured.NestedFieldNoCopy(rendered[0].Object, "spec", "replicas")
+	require.NoError(t, err)
+	assert.True(t, found, "HPA in another namespace must not match")
+}
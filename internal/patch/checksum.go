@@ -0,0 +1,165 @@
+package patch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/kubepatch/kubepatch/internal/labels"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ConfigChecksumAnnotation is set on pod templates to the hash of the ConfigMaps
+// and Secrets they reference, so that pods roll when the configuration changes.
+const ConfigChecksumAnnotation = "kubepatch/config-checksum"
+
+// addConfigChecksums annotates every pod template with a checksum of the
+// ConfigMaps and Secrets it references that are part of the manifests.
+// References to objects outside the render are ignored.
+func addConfigChecksums(manifests []*unstructured.Unstructured, trace *Trace) error {
+	configs := make(map[string]*unstructured.Unstructured)
+	for _, doc := range manifests {
+		if key, ok := configKey(doc); ok {
+			configs[key] = doc
+		}
+	}
+	if len(configs) == 0 {
+		return nil
+	}
+
+	for i, doc := range manifests {
+		for _, path := range labels.PodSpecPaths(doc) {
+			// a bare Pod is not rolled by annotation changes
+			if len(path) == 1 {
+				continue
+			}
+			podSpec, found, err := unstructured.NestedMap(doc.Object, path...)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+
+			var refs []string
+			for _, ref := range configReferences(podSpec) {
+				if _, ok := configs[doc.GetNamespace()+"/"+ref]; ok {
+					refs = append(refs, ref)
+				}
+			}
+			if len(refs) == 0 {
+				continue
+			}
+
+			sum, err := checksumConfigs(doc.GetNamespace(), refs, configs)
+			if err != nil {
+				return err
+			}
+			metadataPath := append(append([]string{}, path[:len(path)-1]...), "metadata", "annotations")
+			if err := unstructured.SetNestedField(doc.Object, sum, append(metadataPath, ConfigChecksumAnnotation)...); err != nil {
+				return err
+			}
+			trace.note(i, "%s annotation computed from %s", ConfigChecksumAnnotation, strings.Join(refs, ", "))
+		}
+	}
+	return nil
+}
+
+// configKey returns "namespace/kind/name" for ConfigMaps and Secrets.
+func configKey(doc *unstructured.Unstructured) (string, bool) {
+	if doc.GetAPIVersion() != "v1" {
+		return "", false
+	}
+	switch doc.GetKind() {
+	case "ConfigMap", "Secret":
+		return doc.GetNamespace() + "/" + strings.ToLower(doc.GetKind()) + "/" + doc.GetName(), true
+	}
+	return "", false
+}
+
+// configReferences returns sorted, unique "kind/name" references of a pod spec:
+// envFrom and env valueFrom of all containers, volumes and projected volumes.
+func configReferences(podSpec map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	add := func(kind string, name interface{}) {
+		if s, ok := name.(string); ok && s != "" {
+			seen[kind+"/"+s] = true
+		}
+	}
+
+	for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+		for _, c := range mapsAt(podSpec, field) {
+			for _, envFrom := range mapsAt(c, "envFrom") {
+				add("configmap", nestedValue(envFrom, "configMapRef", "name"))
+				add("secret", nestedValue(envFrom, "secretRef", "name"))
+			}
+			for _, env := range mapsAt(c, "env") {
+				add("configmap", nestedValue(env, "valueFrom", "configMapKeyRef", "name"))
+				add("secret", nestedValue(env, "valueFrom", "secretKeyRef", "name"))
+			}
+		}
+	}
+	for _, volume := range mapsAt(podSpec, "volumes") {
+		add("configmap", nestedValue(volume, "configMap", "name"))
+		add("secret", nestedValue(volume, "secret", "secretName"))
+		if projected, ok := volume["projected"].(map[string]interface{}); ok {
+			for _, source := range mapsAt(projected, "sources") {
+				add("configmap", nestedValue(source, "configMap", "name"))
+				add("secret", nestedValue(source, "secret", "name"))
+			}
+		}
+	}
+
+	refs := make([]string, 0, len(seen))
+	for ref := range seen {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// checksumConfigs hashes the payload of the referenced configs in reference order.
+func checksumConfigs(namespace string, refs []string, configs map[string]*unstructured.Unstructured) (string, error) {
+	h := sha256.New()
+	for _, ref := range refs {
+		obj := configs[namespace+"/"+ref].Object
+		payload, err := json.Marshal(map[string]interface{}{
+			"data":       obj["data"],
+			"binaryData": obj["binaryData"],
+			"stringData": obj["stringData"],
+		})
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(ref))
+		h.Write([]byte{0})
+		h.Write(payload)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// mapsAt returns the map items of the list stored at m[key].
+func mapsAt(m map[string]interface{}, key string) []map[string]interface{} {
+	list, ok := m[key].([]interface{})
+	if !ok {
+		return nil
+	}
+	out := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if itemMap, ok := item.(map[string]interface{}); ok {
+			out = append(out, itemMap)
+		}
+	}
+	return out
+}
+
+func nestedValue(m map[string]interface{}, fields ...string) interface{} {
+	v, found, err := unstructured.NestedFieldNoCopy(m, fields...)
+	if err != nil || !found {
+		return nil
+	}
+	return v
+}
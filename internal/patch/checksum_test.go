@@ -0,0 +1,182 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func checksumManifests() []*unstructured.Unstructured {
+	return []*unstructured.Unstructured{
+		mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+data:
+  LOG_LEVEL: debug
+`),
+		mustObj(`
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app-secret
+stringData:
+  PASSWORD: dev
+`),
+		mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+        - name: web
+          envFrom:
+            - configMapRef:
+                name: app-config
+          env:
+            - name: PASSWORD
+              valueFrom:
+                secretKeyRef:
+                  name: app-secret
+                  key: PASSWORD
+            - name: EXTERNAL
+              valueFrom:
+                secretKeyRef:
+                  name: not-rendered
+                  key: value
+`),
+		mustObj(`
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          volumes:
+            - name: config
+              projected:
+                sources:
+                  - configMap:
+                      name: app-config
+          containers:
+            - name: report
+`),
+		mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: unrelated
+spec:
+  template:
+    spec:
+      volumes:
+        - name: data
+          emptyDir: {}
+      containers:
+        - name: unrelated
+`),
+	}
+}
+
+func renderChecksums(t *testing.T, patchFile FullPatchFile) []*unstructured.Unstructured {
+	t.Helper()
+	rendered, err := Render(checksumManifests(), patchFile, Options{ConfigChecksums: true})
+	require.NoError(t, err)
+	return rendered
+}
+
+func templateAnnotation(t *testing.T, obj *unstructured.Unstructured, path ...string) (string, bool) {
+	t.Helper()
+	sum, found, err := unstructured.NestedString(obj.Object, append(path, "metadata", "annotations", ConfigChecksumAnnotation)...)
+	require.NoError(t, err)
+	return sum, found
+}
+
+func Test_Render_ConfigChecksums(t *testing.T) {
+	rendered := renderChecksums(t, FullPatchFile{})
+
+	webSum, found := templateAnnotation(t, rendered[2], "spec", "template")
+	assert.True(t, found)
+	assert.Len(t, webSum, 64)
+
+	cronSum, found := templateAnnotation(t, rendered[3], "spec", "jobTemplate", "spec", "template")
+	assert.True(t, found)
+	assert.NotEqual(t, webSum, cronSum)
+
+	_, found = templateAnnotation(t, rendered[4], "spec", "template")
+	assert.False(t, found)
+
+	// deterministic across renders
+	again := renderChecksums(t, FullPatchFile{})
+	againSum, _ := templateAnnotation(t, again[2], "spec", "template")
+	assert.Equal(t, webSum, againSum)
+}
+
+func Test_Render_ConfigChecksumsChangeWithConfig(t *testing.T) {
+	base := renderChecksums(t, FullPatchFile{})
+	baseWeb, _ := templateAnnotation(t, base[2], "spec", "template")
+	baseCron, _ := templateAnnotation(t, base[3], "spec", "jobTemplate", "spec", "template")
+
+	// only the secret changes: the deployment rolls, the cronjob does not
+	patched := renderChecksums(t, FullPatchFile{
+		"app-secret": {Resources: ResourcePatches{
+			"secret/app-secret": {{Op: "replace", Path: "/stringData/PASSWORD", Value: "prod"}},
+		}},
+	})
+	patchedWeb, _ := templateAnnotation(t, patched[2], "spec", "template")
+	patchedCron, _ := templateAnnotation(t, patched[3], "spec", "jobTemplate", "spec", "template")
+
+	assert.NotEqual(t, baseWeb, patchedWeb)
+	assert.Equal(t, baseCron, patchedCron)
+}
+
+func Test_Render_ConfigChecksumsDisabled(t *testing.T) {
+	rendered, err := Render(checksumManifests(), FullPatchFile{}, Options{})
+	require.NoError(t, err)
+
+	_, found := templateAnnotation(t, rendered[2], "spec", "template")
+	assert.False(t, found)
+}
+
+func TestConfigReferences(t *testing.T) {
+	podSpec := mustObj(`
+initContainers:
+  - envFrom:
+      - secretRef:
+          name: init-secret
+containers:
+  - env:
+      - name: A
+        valueFrom:
+          configMapKeyRef:
+            name: cm-a
+            key: a
+      - name: B
+        value: plain
+volumes:
+  - secret:
+      secretName: tls
+  - configMap:
+      name: cm-a
+  - projected:
+      sources:
+        - secret:
+            name: projected-secret
+`).Object
+
+	assert.Equal(t, []string{
+		"configmap/cm-a",
+		"secret/init-secret",
+		"secret/projected-secret",
+		"secret/tls",
+	}, configReferences(podSpec))
+}
@@ -0,0 +1,525 @@
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kubepatch/kubepatch/internal/output"
+
+	yamlv3 "gopkg.in/yaml.v3"
+	"sigs.k8s.io/yaml"
+)
+
+// OpEmbedded applies nested operations to a document stored as a string,
+// e.g. an application.yaml key of a ConfigMap.
+const OpEmbedded = "embedded"
+
+// embeddedFormat converts an embedded document to and from its JSON form.
+// encode receives the original text, so formats can keep what they can of it:
+// YAML keeps its comments, key order and quoting, JSON its indentation, and
+// properties and INI their key order, but not their comments.
+type embeddedFormat interface {
+	decode(text string) (interface{}, error)
+	encode(value interface{}, original string) (string, error)
+}
+
+var embeddedFormats = map[string]embeddedFormat{
+	"yaml":       yamlFormat{},
+	"json":       jsonFormat{},
+	"ini":        iniFormat{},
+	"properties": propertiesFormat{},
+}
+
+// patchEmbedded decodes the string at op.Path, applies op.Ops to it and
+// encodes the result back into the same field.
+func patchEmbedded(data []byte, op Operation) ([]byte, error) {
+	format, ok := embeddedFormats[strings.ToLower(op.Format)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported embedded format %q at %s", op.Format, op.Path)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	value, err := lookupPointer(doc, op.Path)
+	if err != nil {
+		return nil, err
+	}
+	text, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("embedded document at %s is %T, not a string", op.Path, value)
+	}
+
+	decoded, err := format.decode(text)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s document at %s: %w", op.Format, op.Path, err)
+	}
+	decodedJSON, err := json.Marshal(decoded)
+	if err != nil {
+		return nil, err
+	}
+	patchedJSON, err := patchDocument(decodedJSON, op.Ops)
+	if err != nil {
+		return nil, fmt.Errorf("embedded document at %s: %w", op.Path, err)
+	}
+	var patched interface{}
+	if err := json.Unmarshal(patchedJSON, &patched); err != nil {
+		return nil, err
+	}
+	encoded, err := format.encode(patched, text)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write %s document at %s: %w", op.Format, op.Path, err)
+	}
+
+	return patchDocument(data, []Operation{{Op: "replace", Path: op.Path, Value: encoded}})
+}
+
+type yamlFormat struct{}
+
+func (yamlFormat) decode(text string) (interface{}, error) {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(text), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// encode writes value into the node tree of the original, like --preserve,
+// so that unchanged keys keep their position, comments and quoting.
+func (yamlFormat) encode(value interface{}, original string) (string, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(original), &doc); err != nil || len(doc.Content) != 1 {
+		out, err := yaml.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(out), nil
+	}
+	merged, err := output.MergeNode(&doc, value)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(max(len(detectIndent(original)), 2))
+	if err := enc.Encode(merged); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) decode(text string) (interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// encode keeps the original style: compact for one-liners, indented otherwise.
+func (jsonFormat) encode(value interface{}, original string) (string, error) {
+	trimmed := strings.TrimRight(original, "\n")
+	if !strings.Contains(trimmed, "\n") {
+		out, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(out) + original[len(trimmed):], nil
+	}
+	out, err := json.MarshalIndent(value, "", detectIndent(trimmed))
+	if err != nil {
+		return "", err
+	}
+	return string(out) + original[len(trimmed):], nil
+}
+
+// detectIndent returns the leading whitespace of the first indented line.
+func detectIndent(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "  "
+}
+
+// propertiesFormat handles Java .properties files as a flat map of strings.
+// Comments are not preserved; existing keys keep their order, new keys are appended sorted.
+type propertiesFormat struct{}
+
+func (propertiesFormat) decode(text string) (interface{}, error) {
+	keys, values, err := parseProperties(text)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		out[k] = values[k]
+	}
+	return out, nil
+}
+
+func (propertiesFormat) encode(value interface{}, original string) (string, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("properties document must be a map, got %T", value)
+	}
+	origKeys, _, err := parseProperties(original)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	for _, k := range orderKeys(origKeys, m) {
+		s, err := scalarString(m[k])
+		if err != nil {
+			return "", fmt.Errorf("property %q: %w", k, err)
+		}
+		buf.WriteString(escapeProperty(k, true))
+		buf.WriteByte('=')
+		buf.WriteString(escapeProperty(s, false))
+		buf.WriteByte('\n')
+	}
+	return buf.String(), nil
+}
+
+// parseProperties parses the java.util.Properties line format, returning keys in file order.
+func parseProperties(text string) ([]string, map[string]string, error) {
+	var keys []string
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	var logical string
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if logical == "" && (line == "" || line[0] == '#' || line[0] == '!') {
+			continue
+		}
+		if continues(line) {
+			logical += line[:len(line)-1]
+			continue
+		}
+		logical += line
+
+		key, value, err := splitProperty(logical)
+		logical = ""
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, exists := values[key]; !exists {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if logical != "" {
+		key, value, err := splitProperty(logical)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, exists := values[key]; !exists {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	return keys, values, nil
+}
+
+// continues reports whether a line ends with an odd number of backslashes.
+func continues(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+func splitProperty(line string) (key, value string, err error) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' {
+			i++
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			end = i
+			break
+		}
+	}
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	if key, err = unescapeProperty(line[:end]); err != nil {
+		return "", "", err
+	}
+	if value, err = unescapeProperty(rest); err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+func unescapeProperty(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i == len(s)-1 {
+			buf.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			buf.WriteByte('\t')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'u':
+			if i+4 >= len(s) {
+				return "", fmt.Errorf("malformed \\u escape in %q", s)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\u escape in %q", s)
+			}
+			buf.WriteRune(rune(r))
+			i += 4
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String(), nil
+}
+
+func escapeProperty(s string, isKey bool) string {
+	var buf strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			if isKey || i == 0 {
+				buf.WriteByte('\\')
+			}
+			buf.WriteRune(r)
+		case ' ':
+			if isKey || i == 0 {
+				buf.WriteByte('\\')
+			}
+			buf.WriteRune(r)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&buf, `\u%04x`, r)
+				continue
+			}
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// iniFormat handles INI files: top-level keys map to strings, [sections] to nested maps.
+// Comments are not preserved; existing keys and sections keep their order and
+// quoted values their quotes.
+type iniFormat struct{}
+
+func (iniFormat) decode(text string) (interface{}, error) {
+	doc, _, err := parseINI(text)
+	return doc, err
+}
+
+func (iniFormat) encode(value interface{}, original string) (string, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("ini document must be a map, got %T", value)
+	}
+	_, layout, err := parseINI(original)
+	if err != nil {
+		return "", err
+	}
+
+	var globals, sections []string
+	for _, k := range orderKeys(layout.order[""], m) {
+		if _, isSection := m[k].(map[string]interface{}); isSection {
+			sections = append(sections, k)
+		} else {
+			globals = append(globals, k)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, k := range globals {
+		s, err := scalarString(m[k])
+		if err != nil {
+			return "", fmt.Errorf("key %q: %w", k, err)
+		}
+		fmt.Fprintf(&buf, "%s = %s\n", k, layout.quote("", k, s))
+	}
+	for _, section := range sections {
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(&buf, "[%s]\n", section)
+		entries, ok := m[section].(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("section %q must be a map", section)
+		}
+		for _, k := range orderKeys(layout.order[section], entries) {
+			s, err := scalarString(entries[k])
+			if err != nil {
+				return "", fmt.Errorf("key %q in section %q: %w", k, section, err)
+			}
+			fmt.Fprintf(&buf, "%s = %s\n", k, layout.quote(section, k, s))
+		}
+	}
+	return buf.String(), nil
+}
+
+// iniLayout is what parseINI records of the text besides the values: the
+// key order of every section, with the top-level keys and section names
+// under "", and the quote of every quoted value by section and key.
+type iniLayout struct {
+	order  map[string][]string
+	quotes map[[2]string]byte
+}
+
+// quote returns the value of a key as written: with the quotes of the
+// original value, or in double quotes when it would not read back otherwise.
+func (l iniLayout) quote(section, key, value string) string {
+	q, ok := l.quotes[[2]string{section, key}]
+	if !ok && (value != strings.TrimSpace(value) || unquoteINI(value) != value) {
+		q, ok = '"', true
+	}
+	if !ok {
+		return value
+	}
+	return string(q) + value + string(q)
+}
+
+// parseINI returns the document and its layout.
+func parseINI(text string) (doc map[string]interface{}, layout iniLayout, err error) {
+	doc = make(map[string]interface{})
+	layout = iniLayout{order: make(map[string][]string), quotes: make(map[[2]string]byte)}
+	order := layout.order
+	section := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, layout, fmt.Errorf("line %d: malformed section header %q", n, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			existing, exists := doc[section]
+			if !exists {
+				doc[section] = make(map[string]interface{})
+				order[""] = append(order[""], section)
+			} else if _, ok := existing.(map[string]interface{}); !ok {
+				return nil, layout, fmt.Errorf("line %d: section %q conflicts with a top-level key", n, section)
+			}
+			continue
+		}
+
+		idx := strings.IndexAny(line, "=:")
+		if idx < 0 {
+			return nil, layout, fmt.Errorf("line %d: expected key = value, got %q", n, line)
+		}
+		key := strings.TrimSpace(line[:idx])
+		raw := strings.TrimSpace(line[idx+1:])
+		value := unquoteINI(raw)
+		if value != raw {
+			layout.quotes[[2]string{section, key}] = raw[0]
+		}
+
+		target := doc
+		if section != "" {
+			sectionMap, ok := doc[section].(map[string]interface{})
+			if !ok {
+				return nil, layout, fmt.Errorf("line %d: section %q conflicts with a top-level key", n, section)
+			}
+			target = sectionMap
+		} else if _, isSection := doc[key].(map[string]interface{}); isSection {
+			return nil, layout, fmt.Errorf("line %d: key %q conflicts with a section", n, key)
+		}
+		if _, exists := target[key]; !exists {
+			order[section] = append(order[section], key)
+		}
+		target[key] = value
+	}
+	return doc, layout, scanner.Err()
+}
+
+func unquoteINI(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// orderKeys returns the keys of m, known keys first in their original order, then new keys sorted.
+func orderKeys(known []string, m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	seen := make(map[string]bool, len(m))
+	for _, k := range known {
+		if _, ok := m[k]; ok && !seen[k] {
+			keys = append(keys, k)
+			seen[k] = true
+		}
+	}
+	var added []string
+	for k := range m {
+		if !seen[k] {
+			added = append(added, k)
+		}
+	}
+	sort.Strings(added)
+	return append(keys, added...)
+}
+
+// scalarString formats a patched value for flat, string-only formats.
+func scalarString(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case nil:
+		return "", nil
+	case bool, float64:
+		out, err := json.Marshal(t)
+		return string(out), err
+	default:
+		return "", fmt.Errorf("expected a scalar value, got %T", v)
+	}
+}
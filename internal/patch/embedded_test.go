@@ -0,0 +1,237 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func renderEmbedded(t *testing.T, key, content string, op Operation) string {
+	t.Helper()
+	cm := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "cfg"},
+		"data":       map[string]interface{}{key: content},
+	}}
+	rendered, err := Render([]*unstructured.Unstructured{cm}, FullPatchFile{
+		"cfg": {Resources: ResourcePatches{"configmap/cfg": {op}}},
+	}, Options{})
+	require.NoError(t, err)
+	value, _, err := unstructured.NestedString(rendered[0].Object, "data", key)
+	require.NoError(t, err)
+	return value
+}
+
+func Test_Render_EmbeddedYAML(t *testing.T) {
+	out := renderEmbedded(t, "application.yaml", `
+server:
+  port: 8080
+spring:
+  profiles: dev
+`, Operation{
+		Op:     OpEmbedded,
+		Path:   "/data/application.yaml",
+		Format: "yaml",
+		Ops: []Operation{
+			{Op: "replace", Path: "/server/port", Value: 9090},
+			{Op: "replace", Path: "/spring/profiles", Value: "prod"},
+			{Op: "add", Path: "/logging", Value: map[string]interface{}{"level": "info"}},
+		},
+	})
+	assert.Equal(t, "server:\n  port: 9090\nspring:\n  profiles: prod\nlogging:\n  level: info\n", out)
+}
+
+func Test_Render_EmbeddedYAMLLayout(t *testing.T) {
+	out := renderEmbedded(t, "application.yaml", `# served by the gateway
+spring:
+    profiles: dev # overridden per environment
+    datasource:
+        url: 'jdbc:postgresql://dev/app'
+        pool: 5
+server:
+    port: 8080
+`, Operation{
+		Op:     OpEmbedded,
+		Path:   "/data/application.yaml",
+		Format: "yaml",
+		Ops:    []Operation{{Op: "replace", Path: "/spring/profiles", Value: "prod"}},
+	})
+	assert.Equal(t, `# served by the gateway
+spring:
+    profiles: prod # overridden per environment
+    datasource:
+        url: 'jdbc:postgresql://dev/app'
+        pool: 5
+server:
+    port: 8080
+`, out)
+}
+
+func Test_Render_EmbeddedJSON(t *testing.T) {
+	out := renderEmbedded(t, "settings.json", "{\n    \"debug\": true,\n    \"url\": \"http://dev\"\n}\n", Operation{
+		Op:     OpEmbedded,
+		Path:   "/data/settings.json",
+		Format: "json",
+		Ops: []Operation{
+			{Op: "replace", Path: "/debug", Value: false},
+			{Op: "replace", Path: "/url", Value: "https://prod"},
+		},
+	})
+	assert.Equal(t, "{\n    \"debug\": false,\n    \"url\": \"https://prod\"\n}\n", out)
+
+	out = renderEmbedded(t, "settings.json", `{"a":1}`, Operation{
+		Op:     OpEmbedded,
+		Path:   "/data/settings.json",
+		Format: "json",
+		Ops:    []Operation{{Op: "add", Path: "/b", Value: 2}},
+	})
+	assert.Equal(t, `{"a":1,"b":2}`, out)
+}
+
+func Test_Render_EmbeddedProperties(t *testing.T) {
+	out := renderEmbedded(t, "app.properties", `# database
+db.url = jdbc:postgresql://dev:5432/app
+db.pool.size: 5
+greeting=hello \
+    world
+`, Operation{
+		Op:     OpEmbedded,
+		Path:   "/data/app.properties",
+		Format: "properties",
+		Ops: []Operation{
+			{Op: "replace", Path: "/db.url", Value: "jdbc:postgresql://prod:5432/app"},
+			{Op: "replace", Path: "/db.pool.size", Value: 20},
+			{Op: "add", Path: "/feature.enabled", Value: true},
+		},
+	})
+	assert.Equal(t, `db.url=jdbc:postgresql://prod:5432/app
+db.pool.size=20
+greeting=hello world
+feature.enabled=true
+`, out)
+}
+
+func Test_Render_EmbeddedINI(t *testing.T) {
+	out := renderEmbedded(t, "app.ini", `; global
+mode = dev
+
+[database]
+host = dev-db
+port = 5432
+
+[cache]
+ttl = "60"
+
+[auth]
+realm = 'dev'
+`, Operation{
+		Op:     OpEmbedded,
+		Path:   "/data/app.ini",
+		Format: "ini",
+		Ops: []Operation{
+			{Op: "replace", Path: "/mode", Value: "prod"},
+			{Op: "replace", Path: "/database/host", Value: "prod-db"},
+			{Op: "remove", Path: "/cache"},
+			{Op: "replace", Path: "/auth/realm", Value: "prod"},
+			{Op: "add", Path: "/auth/banner", Value: " welcome "},
+			{Op: "add", Path: "/ldap", Value: map[string]interface{}{"enabled": "yes"}},
+		},
+	})
+	assert.Equal(t, `mode = prod
+
+[database]
+host = prod-db
+port = 5432
+
+[auth]
+realm = 'prod'
+banner = " welcome "
+
+[ldap]
+enabled = yes
+`, out)
+}
+
+func Test_Render_EmbeddedErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      Operation
+		wantErr string
+	}{
+		{
+			name:    "unknown format",
+			op:      Operation{Op: OpEmbedded, Path: "/data/app", Format: "toml"},
+			wantErr: `unsupported embedded format "toml"`,
+		},
+		{
+			name:    "missing field",
+			op:      Operation{Op: OpEmbedded, Path: "/data/missing", Format: "yaml"},
+			wantErr: `path "/data/missing" not found`,
+		},
+		{
+			name:    "not a string",
+			op:      Operation{Op: OpEmbedded, Path: "/data", Format: "yaml"},
+			wantErr: "not a string",
+		},
+		{
+			name:    "unparsable document",
+			op:      Operation{Op: OpEmbedded, Path: "/data/app", Format: "json"},
+			wantErr: "cannot parse json document",
+		},
+		{
+			name: "failing nested op",
+			op: Operation{Op: OpEmbedded, Path: "/data/app", Format: "yaml", Ops: []Operation{
+				{Op: "remove", Path: "/missing"},
+			}},
+			wantErr: "embedded document at /data/app",
+		},
+		{
+			name: "non-scalar property",
+			op: Operation{Op: OpEmbedded, Path: "/data/app", Format: "properties", Ops: []Operation{
+				{Op: "add", Path: "/nested", Value: map[string]interface{}{"a": "b"}},
+			}},
+			wantErr: "expected a scalar value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+data:
+  app: "key: value"
+`)
+			_, err := Render([]*unstructured.Unstructured{cm}, FullPatchFile{
+				"cfg": {Resources: ResourcePatches{"configmap/cfg": {tt.op}}},
+			}, Options{})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestParseProperties(t *testing.T) {
+	keys, values, err := parseProperties(`
+! comment
+key1=value1
+key2 : value2
+key3 value3
+key\ with\ spaces = a\tb
+unicode=café
+empty
+`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key1", "key2", "key3", "key with spaces", "unicode", "empty"}, keys)
+	assert.Equal(t, "value1", values["key1"])
+	assert.Equal(t, "value2", values["key2"])
+	assert.Equal(t, "value3", values["key3"])
+	assert.Equal(t, "a\tb", values["key with spaces"])
+	assert.Equal(t, "café", values["unicode"])
+	assert.Equal(t, "", values["empty"])
+}
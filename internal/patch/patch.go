@@ -1,87 +1,320 @@
 package patch
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/kubepatch/kubepatch/internal/labels"
+	"github.com/kubepatch/kubepatch/internal/output"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-
-	"sigs.k8s.io/yaml"
 )
 
 type Operation struct {
-	Op    string      `yaml:"op" json:"op"`
-	Path  string      `yaml:"path" json:"path"`
-	Value interface{} `yaml:"value,omitempty" json:"value,omitempty"`
+	Op        string      `yaml:"op" json:"op"`
+	Path      string      `yaml:"path" json:"path"`
+	Value     interface{} `yaml:"value,omitempty" json:"value,omitempty"`
+	ValueFrom *ValueFrom  `yaml:"valueFrom,omitempty" json:"valueFrom,omitempty"`
+
+	// Format and Ops are used by the "embedded" op only.
+	Format string      `yaml:"format,omitempty" json:"format,omitempty"`
+	Ops    []Operation `yaml:"ops,omitempty" json:"ops,omitempty"`
 }
 
-// FullPatchFile map[appName]map["kind/name"] -> []PatchOperation
-type FullPatchFile map[string]map[string][]Operation
+// FullPatchFile map[appName] -> AppPatch
+type FullPatchFile map[string]AppPatch
+
+// ResourcePatches map["kind/name"] -> []PatchOperation
+type ResourcePatches map[string][]Operation
+
+// AppPatch is everything a patch-file declares for a single application.
+// In the file, resource keys and sections (podSpec) share one mapping.
+type AppPatch struct {
+	Resources ResourcePatches
+	PodSpec   *PodSpecOverlay
+}
+
+const podSpecSection = "podSpec"
+
+func (a *AppPatch) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*a = AppPatch{}
+	for key, value := range raw {
+		if key == podSpecSection {
+			if err := json.Unmarshal(value, &a.PodSpec); err != nil {
+				return fmt.Errorf("invalid %s: %w", podSpecSection, err)
+			}
+			continue
+		}
+		var ops []Operation
+		if err := json.Unmarshal(value, &ops); err != nil {
+			return fmt.Errorf("invalid operations for %q: %w", key, err)
+		}
+		if a.Resources == nil {
+			a.Resources = make(ResourcePatches)
+		}
+		a.Resources[key] = ops
+	}
+	return nil
+}
+
+func (a AppPatch) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(a.Resources)+1)
+	for key, ops := range a.Resources {
+		out[key] = ops
+	}
+	if a.PodSpec != nil {
+		out[podSpecSection] = a.PodSpec
+	}
+	return json.Marshal(out)
+}
+
+// job is a single patch-file entry matched against a base manifest.
+type job struct {
+	index       int // position of the matched manifest
+	appName     string
+	podSpec     *PodSpecOverlay
+	resourceKey string
+	kind, name  string
+	ops         []Operation
+	refs        map[*ValueFrom]int // manifest index referenced by each valueFrom
+}
 
 func Run(manifests []*unstructured.Unstructured, patchFile FullPatchFile) ([]byte, error) {
-	for i, doc := range manifests {
-		for appName, resources := range patchFile {
-			for resourceKey, ops := range resources {
-				parts := strings.SplitN(resourceKey, "/", 2)
-				if len(parts) != 2 {
-					return nil, fmt.Errorf("invalid resource key: %q", resourceKey)
-				}
-				kind, name := strings.ToLower(parts[0]), strings.ToLower(parts[1]) // normalize kind casing
+	rendered, err := Render(manifests, patchFile, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return MarshalYAML(rendered)
+}
 
-				if strings.ToLower(doc.GetKind()) != kind || strings.ToLower(doc.GetName()) != name {
-					continue
-				}
+// Options enable optional transformations of the rendered objects.
+type Options struct {
+	// DropAutoscaledReplicas removes spec.replicas from workloads targeted by
+	// a HorizontalPodAutoscaler or a KEDA ScaledObject of the same render.
+	DropAutoscaledReplicas bool
+	// ConfigChecksums annotates pod templates with a hash of the ConfigMaps
+	// and Secrets of the same render they reference.
+	ConfigChecksums bool
+	// KeepNames keeps metadata.name of patched objects instead of setting it
+	// to the app name. A patch-file operation on /metadata/name still applies.
+	KeepNames bool
+	// Labels are added to the common labels of every patched object.
+	// The app name label takes precedence.
+	Labels map[string]string
+	// Trace, if set, is filled with the history of every object.
+	Trace *Trace
+}
 
-				labels.ApplyCommonLabels(doc, map[string]string{
-					"app.kubernetes.io/name": appName,
-				})
+// Render applies the patch-file to the manifests and returns the patched objects
+// in input order. Operations using valueFrom are applied after the objects they
+// reference have been patched; a reference to the patched object itself reads
+// its base manifest. An app's podSpec overlay is merged into the pod
+// templates of every object the app patches.
+func Render(manifests []*unstructured.Unstructured, patchFile FullPatchFile, opts Options) ([]*unstructured.Unstructured, error) {
+	jobs, err := matchJobs(manifests, patchFile)
+	if err != nil {
+		return nil, err
+	}
+	ordered, err := orderJobs(manifests, jobs)
+	if err != nil {
+		return nil, err
+	}
 
-				// Inject metadata.name patch (if it's not already present)
-				opsWithName := injectMetadataName(appName, ops)
+	// self-references read the manifest before any job patches it
+	bases := selfReferenced(manifests, ordered)
 
-				jsonData, err := json.Marshal(doc)
-				if err != nil {
-					return nil, err
-				}
+	// manifests with spec.replicas set by the patch-file
+	explicitReplicas := make(map[int]bool)
 
-				patchJSON, err := json.Marshal(opsWithName)
-				if err != nil {
-					return nil, err
-				}
+	trace := opts.Trace
+	if trace != nil {
+		trace.init(manifests)
+	}
 
-				patch, err := jsonpatch.DecodePatch(patchJSON)
-				if err != nil {
-					return nil, fmt.Errorf("invalid patch for %s/%s: %w", kind, name, err)
-				}
+	for _, j := range ordered {
+		doc := manifests[j.index]
+		if setsPath(j.ops, replicasPath) {
+			explicitReplicas[j.index] = true
+		}
 
-				patchedJSON, err := patch.Apply(jsonData)
-				if err != nil {
-					return nil, fmt.Errorf("failed to apply patch to %s/%s: %w", kind, name, err)
-				}
+		ops, err := resolveValuesFrom(manifests, bases, j)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve valueFrom for %s/%s: %w", j.kind, j.name, err)
+		}
 
-				var updated unstructured.Unstructured
-				if err := json.Unmarshal(patchedJSON, &updated); err != nil {
+		commonLabels := make(map[string]string, len(opts.Labels)+1)
+		for k, v := range opts.Labels {
+			commonLabels[k] = v
+		}
+		commonLabels["app.kubernetes.io/name"] = j.appName
+		labels.ApplyCommonLabels(doc, commonLabels)
+
+		// Inject metadata.name patch (if it's not already present)
+		opsWithName := ops
+		if !opts.KeepNames {
+			opsWithName = injectMetadataName(j.appName, ops)
+		}
+
+		var updated *unstructured.Unstructured
+		if trace != nil {
+			rec := PatchTrace{App: j.appName, ResourceKey: j.resourceKey, Labels: commonLabels}
+			injected := len(opsWithName) - len(ops)
+			if injected > 0 {
+				rec.RenamedFrom, rec.RenamedTo = doc.GetName(), j.appName
+			}
+			updated, err = applyTraced(doc, opsWithName, injected, &j, &rec)
+			trace.Objects[j.index].Patches = append(trace.Objects[j.index].Patches, rec)
+		} else {
+			updated, err = applyOperations(doc, opsWithName, j.kind, j.name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		merged, err := applyPodSpecOverlay(updated, j.podSpec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply %s to %s/%s: %w", podSpecSection, j.kind, j.name, err)
+		}
+		if merged > 0 {
+			trace.note(j.index, "%s of app %q merged into the pod spec", podSpecSection, j.appName)
+		}
+		manifests[j.index] = updated
+	}
+
+	if opts.DropAutoscaledReplicas {
+		dropAutoscaledReplicas(manifests, explicitReplicas, trace)
+	}
+	if opts.ConfigChecksums {
+		if err := addConfigChecksums(manifests, trace); err != nil {
+			return nil, err
+		}
+	}
+	return manifests, nil
+}
+
+// MarshalYAML renders objects as a stream of '---' separated YAML documents.
+func MarshalYAML(manifests []*unstructured.Unstructured) ([]byte, error) {
+	return output.Marshal(manifests, output.FormatYAML)
+}
+
+// matchJobs pairs every patch-file entry with the base manifests it targets.
+// Apps and resource keys are visited in sorted order, so the result is stable.
+func matchJobs(manifests []*unstructured.Unstructured, patchFile FullPatchFile) ([]job, error) {
+	appNames := make([]string, 0, len(patchFile))
+	for appName := range patchFile {
+		appNames = append(appNames, appName)
+	}
+	sort.Strings(appNames)
+
+	var jobs []job
+	for i, doc := range manifests {
+		for _, appName := range appNames {
+			resources := patchFile[appName].Resources
+			resourceKeys := make([]string, 0, len(resources))
+			for resourceKey := range resources {
+				resourceKeys = append(resourceKeys, resourceKey)
+			}
+			sort.Strings(resourceKeys)
+
+			for _, resourceKey := range resourceKeys {
+				kind, name, err := parseResourceKey(resourceKey)
+				if err != nil {
 					return nil, err
 				}
-				manifests[i] = &updated
+				if !matchesResource(doc, kind, name) {
+					continue
+				}
+				jobs = append(jobs, job{
+					index:       i,
+					appName:     appName,
+					podSpec:     patchFile[appName].PodSpec,
+					resourceKey: resourceKey,
+					kind:        kind,
+					name:        name,
+					ops:         resources[resourceKey],
+				})
 			}
 		}
 	}
+	return jobs, nil
+}
+
+// parseResourceKey splits a "kind/name" key, normalizing casing.
+func parseResourceKey(resourceKey string) (kind, name string, err error) {
+	parts := strings.SplitN(resourceKey, "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid resource key: %q", resourceKey)
+	}
+	return strings.ToLower(parts[0]), strings.ToLower(parts[1]), nil // normalize kind casing
+}
+
+func matchesResource(doc *unstructured.Unstructured, kind, name string) bool {
+	return strings.ToLower(doc.GetKind()) == kind && strings.ToLower(doc.GetName()) == name
+}
+
+// applyOperations applies JSON-patch operations to a copy of doc.
+func applyOperations(doc *unstructured.Unstructured, ops []Operation, kind, name string) (*unstructured.Unstructured, error) {
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	patchedJSON, err := patchDocument(jsonData, ops)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply patch to %s/%s: %w", kind, name, err)
+	}
+
+	var updated unstructured.Unstructured
+	if err := json.Unmarshal(patchedJSON, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// patchDocument applies operations to a JSON document. Runs of standard
+// RFC 6902 operations are applied as a single JSON-patch.
+func patchDocument(data []byte, ops []Operation) ([]byte, error) {
+	var pending []Operation
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		patchJSON, err := json.Marshal(pending)
+		if err != nil {
+			return err
+		}
+		patch, err := jsonpatch.DecodePatch(patchJSON)
+		if err != nil {
+			return fmt.Errorf("invalid patch: %w", err)
+		}
+		data, err = patch.Apply(data)
+		pending = nil
+		return err
+	}
 
-	var buf bytes.Buffer
-	for _, doc := range manifests {
-		out, err := yaml.Marshal(doc)
+	for _, op := range ops {
+		if op.Op != OpEmbedded {
+			pending = append(pending, op)
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		patched, err := patchEmbedded(data, op)
 		if err != nil {
 			return nil, err
 		}
-		buf.WriteString("---\n")
-		buf.Write(out)
+		data = patched
+	}
+	if err := flush(); err != nil {
+		return nil, err
 	}
-	return buf.Bytes(), nil
+	return data, nil
 }
 
 func injectMetadataName(appName string, ops []Operation) []Operation {
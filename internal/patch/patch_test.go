@@ -31,7 +31,7 @@ data:
 `)
 
 	patchFile := FullPatchFile{
-		"my-config": {
+		"my-config": {Resources: ResourcePatches{
 			"configmap/my-config": {
 				{
 					Op:    "replace",
@@ -39,7 +39,7 @@ data:
 					Value: "patched",
 				},
 			},
-		},
+		}},
 	}
 
 	out, err := Run([]*unstructured.Unstructured{manifest}, patchFile)
@@ -59,13 +59,13 @@ data:
 `)
 
 	patchFile := FullPatchFile{
-		"my-config": {
+		"my-config": {Resources: ResourcePatches{
 			"configmap/my-config": {
 				{
 					Op: "bogus-op",
 				},
 			},
-		},
+		}},
 	}
 
 	_, err := Run([]*unstructured.Unstructured{manifest}, patchFile)
@@ -83,14 +83,14 @@ data:
 `)
 
 	patchFile := FullPatchFile{
-		"my-config": {
+		"my-config": {Resources: ResourcePatches{
 			"configmap/my-config": {
 				{
 					Op:   "remove",
 					Path: "/data/missing",
 				},
 			},
-		},
+		}},
 	}
 
 	_, err := Run([]*unstructured.Unstructured{manifest}, patchFile)
@@ -108,7 +108,7 @@ data:
 `)
 
 	patchFile := FullPatchFile{
-		"my-config": {
+		"my-config": {Resources: ResourcePatches{
 			"configmap/my-config": {
 				{
 					Op:    "replace",
@@ -116,7 +116,7 @@ data:
 					Value: "nope",
 				},
 			},
-		},
+		}},
 	}
 
 	_, err := Run([]*unstructured.Unstructured{manifest}, patchFile)
@@ -140,13 +140,13 @@ metadata:
 	}
 
 	patchFile := FullPatchFile{
-		"newname": {
+		"newname": {Resources: ResourcePatches{
 			"configmap/myconfig": append([]Operation{}, originalOps...), // clone to be safe
-		},
+		}},
 	}
 
 	// Save deep copy of original patch input
-	patchJSONBefore, err := json.Marshal(patchFile["newname"]["configmap/myconfig"])
+	patchJSONBefore, err := json.Marshal(patchFile["newname"].Resources["configmap/myconfig"])
 	require.NoError(t, err)
 
 	// Run the patch logic
@@ -157,9 +157,34 @@ metadata:
 	assert.Contains(t, string(out), "name: newname")
 
 	// Ensure original patch list is NOT mutated
-	patchJSONAfter, err := json.Marshal(patchFile["newname"]["configmap/myconfig"])
+	patchJSONAfter, err := json.Marshal(patchFile["newname"].Resources["configmap/myconfig"])
 	require.NoError(t, err)
 
 	assert.Equal(t, string(patchJSONBefore), string(patchJSONAfter),
 		"original patch operations should remain unchanged")
 }
+
+func TestRender_KeepNamesAndLabels(t *testing.T) {
+	manifest := mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: my-config
+data:
+  foo: bar
+`)
+	patchFile := FullPatchFile{
+		"app": {Resources: ResourcePatches{
+			"configmap/my-config": {{Op: "replace", Path: "/data/foo", Value: "patched"}},
+		}},
+	}
+
+	out, err := Render([]*unstructured.Unstructured{manifest}, patchFile, Options{
+		KeepNames: true,
+		Labels:    map[string]string{"team": "web", "app.kubernetes.io/name": "ignored"},
+	})
+	require.NoError(t, err)
+	require.Len(t, out, 1)
+	assert.Equal(t, "my-config", out[0].GetName())
+	assert.Equal(t, map[string]string{"team": "web", "app.kubernetes.io/name": "app"}, out[0].GetLabels())
+}
@@ -1,34 +1,191 @@
 package patch
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 
+	"github.com/kubepatch/kubepatch/internal/crypt"
 	"github.com/kubepatch/kubepatch/internal/envs"
+	"github.com/kubepatch/kubepatch/internal/redact"
 
+	yamlv3 "gopkg.in/yaml.v3"
 	"sigs.k8s.io/yaml"
 )
 
+// variablesSection is the top-level key of a patch-file declaring the
+// variables it consumes; it is not an app.
+const variablesSection = "variables"
+
+// ReadOptions configure how a patch-file is read.
+type ReadOptions struct {
+	// EnvsubstPrefixes allow ${VAR} substitution for environment variables with these prefixes.
+	EnvsubstPrefixes []string
+	// EnvsubstVars allow ${VAR} substitution for these environment variables.
+	EnvsubstVars []string
+	// EnvFiles are dotenv files with values for substitution, used for the
+	// variables not set in the environment. A later file overrides an earlier one.
+	EnvFiles []string
+	// ProvidersConfig is a config file enabling provider references like
+	// ${file:path} or ${vault:secret/data/app#key}; see envs.Config.
+	ProvidersConfig string
+	// AgeKeyFile holds the age identities encrypted patch-files are decrypted
+	// with; see crypt.LoadIdentities for the default.
+	AgeKeyFile string
+	// Redactor, if set, is given the substituted env var values and the
+	// secrets decrypted from SOPS or age values (see crypt.DecryptValues),
+	// which are then masked in diagnostics.
+	Redactor *redact.Redactor
+	// Verbose logs the substitutions, with masked values.
+	Verbose bool
+	// Context, if set, cancels the exec commands and Vault requests of
+	// provider references.
+	Context context.Context
+}
+
 func ReadPatchFile(patchFilePath string, envsubstPrefixes []string) (FullPatchFile, error) {
-	// read patches
-	patchData, err := os.ReadFile(patchFilePath)
+	return ReadPatchFileWithOptions(patchFilePath, ReadOptions{EnvsubstPrefixes: envsubstPrefixes})
+}
+
+// ReadPatchFileWithOptions reads a patch-file, decrypts it if it is encrypted
+// with age or SOPS, validates the variables it declares and substitutes them.
+func ReadPatchFileWithOptions(patchFilePath string, opts ReadOptions) (FullPatchFile, error) {
+	doc, vars, err := readPatchFileNode(patchFilePath, &opts)
 	if err != nil {
 		return nil, err
 	}
 
 	// subst envs in a patch-file (if opts are set)
-	if len(envsubstPrefixes) > 0 {
-		envsubst := envs.NewEnvsubst([]string{}, envsubstPrefixes, true)
-		patchFileAfterSubst, err := envsubst.SubstituteEnvs(string(patchData))
+	if opts.substitutes() {
+		envsubst, err := opts.envsubst(patchFilePath)
 		if err != nil {
 			return nil, err
 		}
-		patchData = []byte(patchFileAfterSubst)
+		if err := envsubst.Validate(vars); err != nil {
+			return nil, fmt.Errorf("%s: %w", patchFilePath, err)
+		}
+		if err := envsubst.SubstituteNode(doc); err != nil {
+			return nil, err
+		}
+	} else if len(opts.EnvFiles) > 0 || len(vars) > 0 {
+		log.Printf("WARNING: %s: the env files and declared variables are not used, no variables are allowed for substitution", patchFilePath)
 	}
 
 	// unmarshal to struct
+	patchData, err := yamlv3.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
 	var patchFile FullPatchFile
 	if err := yaml.Unmarshal(patchData, &patchFile); err != nil {
 		return nil, err
 	}
 	return patchFile, nil
 }
+
+// ReadVariables returns the status of the variables a patch-file declares in
+// its variables section or references with placeholders, decrypting it if needed.
+func ReadVariables(patchFilePath string, opts ReadOptions) ([]envs.VariableStatus, error) {
+	doc, vars, err := readPatchFileNode(patchFilePath, &opts)
+	if err != nil {
+		return nil, err
+	}
+	envsubst, err := opts.envsubst(patchFilePath)
+	if err != nil {
+		return nil, err
+	}
+	return envsubst.Statuses(vars, envs.References(doc)), nil
+}
+
+// readPatchFileNode reads and decrypts a patch-file, and splits off its
+// variables section. The decrypted values are added to the redactor.
+func readPatchFileNode(patchFilePath string, opts *ReadOptions) (*yamlv3.Node, map[string]envs.Variable, error) {
+	// read patches
+	patchData, err := os.ReadFile(patchFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// decrypt before substitution, which would break the SOPS MAC
+	patchData, decrypted, err := crypt.DecryptValues(patchData, opts.AgeKeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decrypting %s: %w", patchFilePath, err)
+	}
+	opts.Redactor.Add(decrypted...)
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(patchData, &doc); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", patchFilePath, err)
+	}
+	vars := map[string]envs.Variable{}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return &doc, vars, nil
+	}
+	m := doc.Content[0]
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value != variablesSection {
+			continue
+		}
+		if vars, err = envs.ParseVariables(m.Content[i+1]); err != nil {
+			return nil, nil, fmt.Errorf("%s: %s: %w", patchFilePath, variablesSection, err)
+		}
+		m.Content = append(m.Content[:i], m.Content[i+2:]...)
+		break
+	}
+	return &doc, vars, nil
+}
+
+func (opts *ReadOptions) substitutes() bool {
+	return len(opts.EnvsubstPrefixes) > 0 || len(opts.EnvsubstVars) > 0 || opts.ProvidersConfig != ""
+}
+
+// envsubst returns the Envsubst of the options for a patch-file, with the
+// values of the env files and the providers of the config.
+func (opts *ReadOptions) envsubst(patchFilePath string) (*envs.Envsubst, error) {
+	envsubst := envs.NewEnvsubst(opts.EnvsubstVars, opts.EnvsubstPrefixes, true)
+	fallbackEnv, err := envs.ReadEnvFiles(opts.EnvFiles)
+	if err != nil {
+		return nil, err
+	}
+	envsubst.SetFallbackEnv(fallbackEnv)
+	if opts.ProvidersConfig != "" {
+		config, err := envs.ReadConfig(opts.ProvidersConfig)
+		if err != nil {
+			return nil, err
+		}
+		providers, err := config.NewProviders(filepath.Dir(patchFilePath))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", opts.ProvidersConfig, err)
+		}
+		envsubst.SetProviders(providers)
+	}
+	envsubst.SetRedactor(opts.Redactor)
+	envsubst.SetVerbose(opts.Verbose)
+	envsubst.SetContext(opts.Context)
+	return envsubst, nil
+}
+
+// Merge returns the patch-files layered in order: operations of an app's
+// resource key are appended to those of earlier files, and a later podSpec
+// overlay replaces an earlier one.
+func Merge(files ...FullPatchFile) FullPatchFile {
+	out := make(FullPatchFile)
+	for _, f := range files {
+		for app, p := range f {
+			merged := out[app]
+			for key, ops := range p.Resources {
+				if merged.Resources == nil {
+					merged.Resources = make(ResourcePatches)
+				}
+				merged.Resources[key] = append(append([]Operation{}, merged.Resources[key]...), ops...)
+			}
+			if p.PodSpec != nil {
+				merged.PodSpec = p.PodSpec
+			}
+			out[app] = merged
+		}
+	}
+	return out
+}
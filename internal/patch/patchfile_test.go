@@ -5,6 +5,10 @@ import (
 	"path/filepath"
 	"testing"
 
+	"filippo.io/age"
+	"github.com/kubepatch/kubepatch/internal/crypt"
+	"github.com/kubepatch/kubepatch/internal/envs"
+	"github.com/kubepatch/kubepatch/internal/redact"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -40,7 +44,7 @@ myapp:
 	patchFile, err := ReadPatchFile(path, nil)
 	require.NoError(t, err)
 
-	ops := patchFile["myapp"]["configmap/myconfig"]
+	ops := patchFile["myapp"].Resources["configmap/myconfig"]
 	require.Len(t, ops, 1)
 	assert.Equal(t, "replace", ops[0].Op)
 	assert.Equal(t, "/data/foo", ops[0].Path)
@@ -60,7 +64,7 @@ myapp:
 	patchFile, err := ReadPatchFile(path, []string{"FOO"})
 	require.NoError(t, err)
 
-	ops := patchFile["myapp"]["configmap/myconfig"]
+	ops := patchFile["myapp"].Resources["configmap/myconfig"]
 	require.Len(t, ops, 1)
 	assert.Equal(t, "bar", ops[0].Value)
 }
@@ -77,3 +81,309 @@ myapp:
 	_, err := ReadPatchFile(path, []string{"MISSING_VAR"})
 	assert.Error(t, err)
 }
+
+func TestReadPatchFile_PodSpecSection(t *testing.T) {
+	content := `
+myapp:
+  podSpec:
+    serviceAccountName: myapp
+    nodeSelector:
+      pool: prod
+  deployment/myapp:
+    - op: replace
+      path: /spec/replicas
+      value: 3
+`
+	path := writeTempFile(t, content)
+	patchFile, err := ReadPatchFile(path, nil)
+	require.NoError(t, err)
+
+	app := patchFile["myapp"]
+	require.NotNil(t, app.PodSpec)
+	assert.Equal(t, "myapp", app.PodSpec.ServiceAccountName)
+	assert.Equal(t, map[string]string{"pool": "prod"}, app.PodSpec.NodeSelector)
+	assert.Len(t, app.Resources, 1)
+	assert.Len(t, app.Resources["deployment/myapp"], 1)
+}
+
+func TestReadPatchFile_InvalidPodSpecSection(t *testing.T) {
+	path := writeTempFile(t, `
+myapp:
+  podSpec:
+    - op: replace
+`)
+	_, err := ReadPatchFile(path, nil)
+	assert.Error(t, err)
+}
+
+func TestMerge(t *testing.T) {
+	base := FullPatchFile{
+		"app": {
+			Resources: ResourcePatches{"deployment/app": {{Op: "replace", Path: "/spec/replicas", Value: 1}}},
+			PodSpec:   &PodSpecOverlay{},
+		},
+	}
+	overlay := FullPatchFile{
+		"app": {Resources: ResourcePatches{"deployment/app": {{Op: "replace", Path: "/spec/replicas", Value: 3}}}},
+		"db":  {Resources: ResourcePatches{"statefulset/db": {{Op: "remove", Path: "/spec/replicas"}}}},
+	}
+
+	merged := Merge(base, overlay)
+	require.Len(t, merged, 2)
+	ops := merged["app"].Resources["deployment/app"]
+	require.Len(t, ops, 2)
+	assert.Equal(t, 1, ops[0].Value)
+	assert.Equal(t, 3, ops[1].Value)
+	assert.Same(t, base["app"].PodSpec, merged["app"].PodSpec)
+	assert.Len(t, merged["db"].Resources["statefulset/db"], 1)
+	// inputs are not modified
+	assert.Len(t, base["app"].Resources["deployment/app"], 1)
+}
+
+func TestReadPatchFileWithOptions_Encrypted(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(keyFile, []byte(id.String()+"\n"), 0o600))
+
+	enc, err := crypt.EncryptSops([]byte(`
+myapp:
+  secret/db:
+    - op: add
+      path: /stringData/password
+      value: s3cret
+    - op: add
+      path: /stringData/user
+      value: ${CI_DB_USER}
+`), []string{id.Recipient().String()}, crypt.SopsOptions{EncryptedRegex: "^value$"})
+	require.NoError(t, err)
+	path := writeTempFile(t, string(enc))
+	t.Setenv("CI_DB_USER", "app")
+
+	patchFile, err := ReadPatchFileWithOptions(path, ReadOptions{EnvsubstPrefixes: []string{"CI_"}, AgeKeyFile: keyFile})
+	require.NoError(t, err)
+	ops := patchFile["myapp"].Resources["secret/db"]
+	require.Len(t, ops, 2)
+	assert.Equal(t, "s3cret", ops[0].Value)
+	assert.Equal(t, "app", ops[1].Value)
+
+	_, err = ReadPatchFileWithOptions(path, ReadOptions{AgeKeyFile: filepath.Join(t.TempDir(), "missing.txt")})
+	assert.Error(t, err)
+}
+
+func TestReadPatchFileWithOptions_EncryptedRedacted(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(keyFile, []byte(id.String()+"\n"), 0o600))
+
+	// every value is encrypted, the op and path fields as well
+	enc, err := crypt.EncryptSops([]byte(`
+myapp:
+  deployment/myapp:
+    - op: add
+      path: /spec/template/spec/containers/0/env/0/value
+      value: s3cret-password
+    - op: replace
+      path: /spec/replicas
+      value: 3
+    - op: add
+      path: /spec/template/spec/volumes/0
+      value:
+        name: certs
+        hostPath:
+          path: /srv/private-certs
+`), []string{id.Recipient().String()}, crypt.SopsOptions{})
+	require.NoError(t, err)
+	path := writeTempFile(t, string(enc))
+
+	r := redact.New()
+	_, err = ReadPatchFileWithOptions(path, ReadOptions{AgeKeyFile: keyFile, Redactor: r})
+	require.NoError(t, err)
+	assert.Equal(t, "DB="+r.Mask("s3cret-password"), r.String("DB=s3cret-password"))
+	assert.Equal(t, r.Mask("/srv/private-certs"), r.String("/srv/private-certs"))
+	for _, s := range []string{"add", "replace", "/spec/replicas"} {
+		assert.Equal(t, s, r.String(s))
+	}
+	assert.Equal(t, int64(3), r.Value(int64(3)))
+}
+
+func TestReadPatchFileWithOptions_EnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	require.NoError(t, os.WriteFile(envFile, []byte("DB_HOST=db.env\nDB_USER=app\n"), 0o600))
+	localFile := filepath.Join(dir, ".env.local")
+	require.NoError(t, os.WriteFile(localFile, []byte("DB_USER=local\n"), 0o600))
+	t.Setenv("DB_HOST", "db.environment")
+
+	path := writeTempFile(t, `
+myapp:
+  configmap/db:
+    - op: add
+      path: /data
+      value:
+        host: ${DB_HOST}
+        user: ${DB_USER}
+        other: ${DB_OTHER:-none}
+`)
+	patchFile, err := ReadPatchFileWithOptions(path, ReadOptions{
+		EnvsubstVars: []string{"DB_HOST", "DB_USER", "DB_OTHER"},
+		EnvFiles:     []string{envFile, localFile},
+	})
+	require.NoError(t, err)
+	ops := patchFile["myapp"].Resources["configmap/db"]
+	require.Len(t, ops, 1)
+	assert.Equal(t, map[string]interface{}{
+		"host":  "db.environment",
+		"user":  "local",
+		"other": "none",
+	}, ops[0].Value)
+
+	_, err = ReadPatchFileWithOptions(path, ReadOptions{
+		EnvsubstVars: []string{"DB_HOST"},
+		EnvFiles:     []string{filepath.Join(dir, "missing.env")},
+	})
+	assert.Error(t, err)
+}
+
+func TestReadPatchFile_EnvsubstStructure(t *testing.T) {
+	t.Setenv("CI_PASSWORD", "s3cret: value\n- injected")
+	t.Setenv("CI_REPLICAS", "3")
+	path := writeTempFile(t, `
+myapp:
+  deployment/myapp:
+    - op: replace
+      path: /spec/replicas
+      value: ${CI_REPLICAS|int}
+  secret/myapp:
+    - op: add
+      path: /stringData/password
+      value: ${CI_PASSWORD}
+`)
+	patchFile, err := ReadPatchFile(path, []string{"CI_"})
+	require.NoError(t, err)
+
+	ops := patchFile["myapp"].Resources["deployment/myapp"]
+	require.Len(t, ops, 1)
+	assert.EqualValues(t, 3, ops[0].Value)
+
+	ops = patchFile["myapp"].Resources["secret/myapp"]
+	require.Len(t, ops, 1)
+	assert.Equal(t, "s3cret: value\n- injected", ops[0].Value)
+}
+
+const declaredPatchFile = `
+variables:
+  CI_IMAGE_TAG:
+    required: true
+    pattern: '^[0-9.]+$'
+    description: the image tag to deploy
+  CI_DEBUG:
+    description: enable debug logs
+myapp:
+  deployment/myapp:
+    - op: replace
+      path: /spec/template/spec/containers/0/image
+      value: registry/myapp:${CI_IMAGE_TAG}
+    - op: add
+      path: /spec/template/spec/containers/0/args
+      value: ["--port=${CI_PORT:-8080}"]
+`
+
+func TestReadPatchFileWithOptions_Variables(t *testing.T) {
+	path := writeTempFile(t, declaredPatchFile)
+
+	t.Setenv("CI_IMAGE_TAG", "1.2.3")
+	patchFile, err := ReadPatchFile(path, []string{"CI_"})
+	require.NoError(t, err)
+	assert.NotContains(t, patchFile, variablesSection)
+	ops := patchFile["myapp"].Resources["deployment/myapp"]
+	require.Len(t, ops, 2)
+	assert.Equal(t, "registry/myapp:1.2.3", ops[0].Value)
+
+	t.Setenv("CI_IMAGE_TAG", "latest")
+	_, err = ReadPatchFile(path, []string{"CI_"})
+	assert.ErrorContains(t, err, "CI_IMAGE_TAG: the value does not match ^[0-9.]+$ (the image tag to deploy)")
+
+	t.Setenv("CI_IMAGE_TAG", "")
+	_, err = ReadPatchFile(path, []string{"CI_"})
+	assert.ErrorContains(t, err, "CI_IMAGE_TAG: required")
+
+	_, err = ReadPatchFileWithOptions(path, ReadOptions{EnvsubstVars: []string{"CI_IMAGE_TAG"}})
+	assert.ErrorContains(t, err, "CI_DEBUG: declared, but not allowed for substitution")
+
+	// without substitution the declarations are dropped
+	patchFile, err = ReadPatchFile(path, nil)
+	require.NoError(t, err)
+	assert.NotContains(t, patchFile, variablesSection)
+	assert.Contains(t, patchFile, "myapp")
+
+	path = writeTempFile(t, "variables:\n  CI_IMAGE_TAG:\n    requried: true\n")
+	_, err = ReadPatchFile(path, nil)
+	assert.ErrorContains(t, err, `unknown field "requried"`)
+}
+
+func TestReadVariables(t *testing.T) {
+	path := writeTempFile(t, declaredPatchFile)
+	t.Setenv("CI_IMAGE_TAG", "1.2.3")
+
+	statuses, err := ReadVariables(path, ReadOptions{EnvsubstPrefixes: []string{"CI_"}})
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	assert.Equal(t, "CI_DEBUG", statuses[0].Name)
+	assert.True(t, statuses[0].Declared)
+	assert.False(t, statuses[0].Referenced)
+	assert.Equal(t, envs.StateUnset, statuses[0].State)
+	assert.Equal(t, "CI_IMAGE_TAG", statuses[1].Name)
+	assert.Equal(t, envs.StateSet, statuses[1].State)
+	assert.Equal(t, "CI_PORT", statuses[2].Name)
+	assert.Equal(t, envs.StateDefault, statuses[2].State)
+	assert.Equal(t, "8080", statuses[2].Default)
+
+	_, err = ReadVariables(filepath.Join(t.TempDir(), "missing.yaml"), ReadOptions{})
+	assert.Error(t, err)
+}
+
+func TestReadPatchFileWithOptions_Providers(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "providers.yaml")
+	require.NoError(t, os.WriteFile(config, []byte(`
+providers:
+  file: {}
+  vault:
+    type: local
+    values:
+      secret/data/app#password: s3cret
+`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "user.txt"), []byte("app\n"), 0o600))
+
+	path := filepath.Join(dir, "patch.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+myapp:
+  secret/db:
+    - op: add
+      path: /stringData
+      value:
+        user: ${file:user.txt}
+        password: ${vault:secret/data/app#password}
+`), 0o600))
+
+	patchFile, err := ReadPatchFileWithOptions(path, ReadOptions{ProvidersConfig: config})
+	require.NoError(t, err)
+	ops := patchFile["myapp"].Resources["secret/db"]
+	require.Len(t, ops, 1)
+	assert.Equal(t, map[string]interface{}{"user": "app", "password": "s3cret"}, ops[0].Value)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+myapp:
+  secret/db:
+    - op: add
+      path: /stringData/password
+      value: ${vault:secret/data/other#password}
+`), 0o600))
+	_, err = ReadPatchFileWithOptions(path, ReadOptions{ProvidersConfig: config})
+	assert.ErrorContains(t, err, "unresolved references: [vault:secret/data/other#password]")
+
+	_, err = ReadPatchFileWithOptions(path, ReadOptions{ProvidersConfig: filepath.Join(dir, "missing.yaml")})
+	assert.Error(t, err)
+}
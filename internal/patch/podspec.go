@@ -0,0 +1,180 @@
+package patch
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/kubepatch/kubepatch/internal/labels"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// PodSpecOverlay is merged into every pod template of an application:
+// Deployments, StatefulSets, DaemonSets, Jobs, CronJobs, ReplicaSets and bare Pods.
+type PodSpecOverlay struct {
+	// NodeSelector entries are added to (or override) the existing selector.
+	NodeSelector map[string]string `yaml:"nodeSelector,omitempty" json:"nodeSelector,omitempty"`
+	// Tolerations are appended, unless an identical toleration is already present.
+	Tolerations []map[string]interface{} `yaml:"tolerations,omitempty" json:"tolerations,omitempty"`
+	// Affinity replaces nodeAffinity, podAffinity and podAntiAffinity individually.
+	Affinity map[string]interface{} `yaml:"affinity,omitempty" json:"affinity,omitempty"`
+	// ImagePullSecrets are appended, unless a secret with the same name is already present.
+	ImagePullSecrets []map[string]interface{} `yaml:"imagePullSecrets,omitempty" json:"imagePullSecrets,omitempty"`
+	// ServiceAccountName replaces the existing service account.
+	ServiceAccountName string `yaml:"serviceAccountName,omitempty" json:"serviceAccountName,omitempty"`
+	// SecurityContext fields are added to (or override) the pod security context.
+	SecurityContext map[string]interface{} `yaml:"securityContext,omitempty" json:"securityContext,omitempty"`
+	// Env is set on all containers and init containers, replacing variables with the same name.
+	Env []map[string]interface{} `yaml:"env,omitempty" json:"env,omitempty"`
+}
+
+// applyPodSpecOverlay merges the overlay into every pod spec of obj and
+// returns the number of pod specs it was merged into.
+func applyPodSpecOverlay(obj *unstructured.Unstructured, overlay *PodSpecOverlay) (int, error) {
+	if overlay == nil {
+		return 0, nil
+	}
+	merged := 0
+	for _, path := range labels.PodSpecPaths(obj) {
+		podSpec, found, err := unstructured.NestedMap(obj.Object, path...)
+		if err != nil {
+			return merged, err
+		}
+		if !found {
+			continue
+		}
+		if err := overlay.mergeInto(podSpec); err != nil {
+			return merged, fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+		}
+		if err := unstructured.SetNestedMap(obj.Object, podSpec, path...); err != nil {
+			return merged, err
+		}
+		merged++
+	}
+	return merged, nil
+}
+
+func (o *PodSpecOverlay) mergeInto(podSpec map[string]interface{}) error {
+	if len(o.NodeSelector) > 0 {
+		selector := make(map[string]interface{}, len(o.NodeSelector))
+		for k, v := range o.NodeSelector {
+			selector[k] = v
+		}
+		if err := mergeMap(podSpec, "nodeSelector", selector); err != nil {
+			return err
+		}
+	}
+	if err := appendUnique(podSpec, "tolerations", o.Tolerations, reflect.DeepEqual); err != nil {
+		return err
+	}
+	if err := mergeMap(podSpec, "affinity", o.Affinity); err != nil {
+		return err
+	}
+	if err := appendUnique(podSpec, "imagePullSecrets", o.ImagePullSecrets, sameName); err != nil {
+		return err
+	}
+	if o.ServiceAccountName != "" {
+		podSpec["serviceAccountName"] = o.ServiceAccountName
+	}
+	if err := mergeMap(podSpec, "securityContext", o.SecurityContext); err != nil {
+		return err
+	}
+	if len(o.Env) > 0 {
+		for _, field := range []string{"initContainers", "containers"} {
+			containers, ok := podSpec[field].([]interface{})
+			if !ok {
+				continue
+			}
+			for _, c := range containers {
+				container, ok := c.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("expected map in %s, got %T", field, c)
+				}
+				if err := setEnv(container, o.Env); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// mergeMap copies overlay entries into the map stored at parent[key], creating it if needed.
+func mergeMap(parent map[string]interface{}, key string, overlay map[string]interface{}) error {
+	if len(overlay) == 0 {
+		return nil
+	}
+	target, ok := parent[key].(map[string]interface{})
+	if !ok {
+		if parent[key] != nil {
+			return fmt.Errorf("expected map at %s, got %T", key, parent[key])
+		}
+		target = make(map[string]interface{}, len(overlay))
+		parent[key] = target
+	}
+	for k, v := range overlay {
+		target[k] = runtime.DeepCopyJSONValue(v)
+	}
+	return nil
+}
+
+// appendUnique appends items to the list at parent[key] that are not yet present according to same.
+func appendUnique(parent map[string]interface{}, key string, items []map[string]interface{}, same func(a, b interface{}) bool) error {
+	if len(items) == 0 {
+		return nil
+	}
+	list, ok := parent[key].([]interface{})
+	if !ok && parent[key] != nil {
+		return fmt.Errorf("expected list at %s, got %T", key, parent[key])
+	}
+	for _, item := range items {
+		present := false
+		for _, existing := range list {
+			if same(existing, item) {
+				present = true
+				break
+			}
+		}
+		if !present {
+			list = append(list, runtime.DeepCopyJSON(item))
+		}
+	}
+	parent[key] = list
+	return nil
+}
+
+// setEnv adds env vars to a container, replacing the ones with the same name.
+func setEnv(container map[string]interface{}, env []map[string]interface{}) error {
+	list, ok := container["env"].([]interface{})
+	if !ok && container["env"] != nil {
+		return fmt.Errorf("expected list at env, got %T", container["env"])
+	}
+	for _, item := range env {
+		replaced := false
+		for i, existing := range list {
+			if sameName(existing, item) {
+				list[i] = runtime.DeepCopyJSON(item)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			list = append(list, runtime.DeepCopyJSON(item))
+		}
+	}
+	container["env"] = list
+	return nil
+}
+
+func sameName(a, b interface{}) bool {
+	am, ok := a.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	bm, ok := b.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	return am["name"] != nil && am["name"] == bm["name"]
+}
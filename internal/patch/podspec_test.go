@@ -0,0 +1,198 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func mustOverlay(y string) *PodSpecOverlay {
+	var o PodSpecOverlay
+	if err := yaml.Unmarshal([]byte(y), &o); err != nil {
+		panic(err)
+	}
+	return &o
+}
+
+const testOverlay = `
+nodeSelector:
+  pool: prod
+tolerations:
+  - key: dedicated
+    operator: Equal
+    value: prod
+    effect: NoSchedule
+affinity:
+  nodeAffinity:
+    requiredDuringSchedulingIgnoredDuringExecution:
+      nodeSelectorTerms:
+        - matchExpressions:
+            - key: zone
+              operator: In
+              values: [a, b]
+imagePullSecrets:
+  - name: registry
+serviceAccountName: myapp
+securityContext:
+  runAsNonRoot: true
+env:
+  - name: ENV
+    value: prod
+  - name: LOG_LEVEL
+    value: info
+`
+
+func Test_Render_PodSpecOverlayAllWorkloads(t *testing.T) {
+	manifests := []*unstructured.Unstructured{
+		mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      nodeSelector:
+        disk: ssd
+      imagePullSecrets:
+        - name: registry
+      securityContext:
+        fsGroup: 1000
+      initContainers:
+        - name: init
+      containers:
+        - name: web
+          env:
+            - name: LOG_LEVEL
+              value: debug
+            - name: PORT
+              value: "8080"
+`),
+		mustObj(`
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: cleanup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: cleanup
+`),
+		mustObj(`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+    - name: debug
+`),
+		mustObj(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+    - port: 80
+`),
+	}
+
+	patchFile := FullPatchFile{
+		"myapp": {
+			Resources: ResourcePatches{
+				"deployment/web":  nil,
+				"cronjob/cleanup": nil,
+				"pod/debug":       nil,
+				"service/web":     nil,
+			},
+			PodSpec: mustOverlay(testOverlay),
+		},
+	}
+
+	rendered, err := Render(manifests, patchFile, Options{})
+	require.NoError(t, err)
+
+	deploySpec, _, err := unstructured.NestedMap(rendered[0].Object, "spec", "template", "spec")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"disk": "ssd", "pool": "prod"}, deploySpec["nodeSelector"])
+	assert.Equal(t, map[string]interface{}{"fsGroup": int64(1000), "runAsNonRoot": true}, deploySpec["securityContext"])
+	assert.Equal(t, "myapp", deploySpec["serviceAccountName"])
+	assert.Len(t, deploySpec["imagePullSecrets"], 1)
+	assert.Len(t, deploySpec["tolerations"], 1)
+	assert.Contains(t, deploySpec["affinity"], "nodeAffinity")
+
+	containers, ok := deploySpec["containers"].([]interface{})
+	require.True(t, ok)
+	web, ok := containers[0].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "LOG_LEVEL", "value": "info"},
+		map[string]interface{}{"name": "PORT", "value": "8080"},
+		map[string]interface{}{"name": "ENV", "value": "prod"},
+	}, web["env"])
+	initContainers, ok := deploySpec["initContainers"].([]interface{})
+	require.True(t, ok)
+	assert.Contains(t, initContainers[0], "env")
+
+	cronSA, _, err := unstructured.NestedString(rendered[1].Object, "spec", "jobTemplate", "spec", "template", "spec", "serviceAccountName")
+	require.NoError(t, err)
+	assert.Equal(t, "myapp", cronSA)
+
+	podSA, _, err := unstructured.NestedString(rendered[2].Object, "spec", "serviceAccountName")
+	require.NoError(t, err)
+	assert.Equal(t, "myapp", podSA)
+
+	_, found, err := unstructured.NestedFieldNoCopy(rendered[3].Object, "spec", "serviceAccountName")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func Test_Render_PodSpecOverlayIsIdempotent(t *testing.T) {
+	deploy := mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+        - name: web
+`)
+	overlay := mustOverlay(testOverlay)
+
+	merged, err := applyPodSpecOverlay(deploy, overlay)
+	require.NoError(t, err)
+	assert.Equal(t, 1, merged)
+	once := deploy.DeepCopy()
+	_, err = applyPodSpecOverlay(deploy, overlay)
+	require.NoError(t, err)
+	assert.Equal(t, once, deploy)
+}
+
+func Test_Render_PodSpecOverlayTypeMismatch(t *testing.T) {
+	deploy := mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      tolerations: not-a-list
+      containers:
+        - name: web
+`)
+	_, err := Render([]*unstructured.Unstructured{deploy}, FullPatchFile{
+		"web": {Resources: ResourcePatches{"deployment/web": nil}, PodSpec: mustOverlay(testOverlay)},
+	}, Options{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected list at tolerations")
+}
@@ -0,0 +1,170 @@
+package patch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/kubepatch/kubepatch/internal/redact"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Trace records how Render produced every object. Pass an empty Trace in
+// Options to have it filled; Objects is indexed like the input manifests.
+type Trace struct {
+	Objects []ObjectTrace
+	// Redactor, if set, masks secret values in Write.
+	Redactor *redact.Redactor
+}
+
+// ObjectTrace is the history of a single manifest.
+type ObjectTrace struct {
+	// Base is the "kind/name" of the manifest before patching.
+	Base    string
+	Patches []PatchTrace
+	// Notes describe changes made outside of patch-file operations,
+	// e.g. by the podSpec overlay or --drop-autoscaled-replicas.
+	Notes []string
+}
+
+// PatchTrace describes one patch-file entry applied to a manifest.
+type PatchTrace struct {
+	App         string
+	ResourceKey string
+	// Labels are the common labels injected before the operations.
+	Labels map[string]string
+	// RenamedFrom/RenamedTo are set when kubepatch set metadata.name to the app name.
+	RenamedFrom, RenamedTo string
+	Ops                    []OpTrace
+}
+
+// OpTrace is an applied operation with the value at its path before and after.
+type OpTrace struct {
+	Operation
+	// Injected marks operations added by kubepatch rather than the patch-file.
+	Injected      bool
+	Before, After interface{}
+	// HadBefore/HasAfter tell whether the path existed before/after the operation.
+	HadBefore, HasAfter bool
+}
+
+func (t *Trace) init(manifests []*unstructured.Unstructured) {
+	t.Objects = make([]ObjectTrace, len(manifests))
+	for i, doc := range manifests {
+		t.Objects[i].Base = strings.ToLower(doc.GetKind()) + "/" + doc.GetName()
+	}
+}
+
+func (t *Trace) note(index int, format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	t.Objects[index].Notes = append(t.Objects[index].Notes, fmt.Sprintf(format, args...))
+}
+
+// applyTraced applies operations one by one, recording the value at each path.
+func applyTraced(doc *unstructured.Unstructured, ops []Operation, injected int, j *job, rec *PatchTrace) (*unstructured.Unstructured, error) {
+	for i, op := range ops {
+		entry := OpTrace{Operation: op, Injected: i < injected}
+		if k := i - injected; k >= 0 && k < len(j.ops) {
+			entry.ValueFrom = j.ops[k].ValueFrom // show the reference, not only the resolved value
+		}
+		entry.Before, entry.HadBefore = valueAt(doc, op.Path)
+
+		updated, err := applyOperations(doc, []Operation{op}, j.kind, j.name)
+		if err != nil {
+			return nil, err
+		}
+		doc = updated
+
+		entry.After, entry.HasAfter = valueAt(doc, op.Path)
+		rec.Ops = append(rec.Ops, entry)
+	}
+	return doc, nil
+}
+
+func valueAt(doc *unstructured.Unstructured, path string) (interface{}, bool) {
+	v, err := lookupPointer(doc.Object, path)
+	if err != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// Write prints a human-readable explanation of every rendered object.
+// sources are the files the manifests were read from, indexed like the trace;
+// they may be nil.
+func (t *Trace) Write(w io.Writer, rendered []*unstructured.Unstructured, sources []string) error {
+	var b strings.Builder
+	for i, obj := range rendered {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "# %s/%s (%s)\n", strings.ToLower(obj.GetKind()), obj.GetName(), obj.GetAPIVersion())
+		if i < len(sources) {
+			fmt.Fprintf(&b, "source: %s\n", sources[i])
+		}
+		if i >= len(t.Objects) {
+			continue
+		}
+		ot := t.Objects[i]
+		fmt.Fprintf(&b, "base:   %s\n", ot.Base)
+		if len(ot.Patches) == 0 {
+			b.WriteString("not patched\n")
+		}
+		for _, p := range ot.Patches {
+			fmt.Fprintf(&b, "patch:  app %q, resource %q\n", p.App, p.ResourceKey)
+			if len(p.Labels) > 0 {
+				fmt.Fprintf(&b, "  labels: %s\n", formatLabels(p.Labels))
+			}
+			if p.RenamedTo != "" {
+				fmt.Fprintf(&b, "  rename: %s -> %s\n", p.RenamedFrom, p.RenamedTo)
+			}
+			n := 0
+			for _, op := range p.Ops {
+				if op.Injected {
+					continue
+				}
+				n++
+				fmt.Fprintf(&b, "  %d. %s %s", n, op.Op, op.Path)
+				if op.ValueFrom != nil {
+					fmt.Fprintf(&b, " (valueFrom %s %s)", op.ValueFrom.Resource, op.ValueFrom.Path)
+				}
+				b.WriteByte('\n')
+				fmt.Fprintf(&b, "     before: %s\n", formatValue(t.Redactor.Field(obj, op.Path, op.Before), op.HadBefore))
+				fmt.Fprintf(&b, "     after:  %s\n", formatValue(t.Redactor.Field(obj, op.Path, op.After), op.HasAfter))
+			}
+		}
+		for _, note := range ot.Notes {
+			fmt.Fprintf(&b, "note:   %s\n", note)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + labels[k]
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatValue(v interface{}, found bool) string {
+	if !found {
+		return "<unset>"
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(out)
+}
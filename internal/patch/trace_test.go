@@ -0,0 +1,182 @@
+package patch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubepatch/kubepatch/internal/redact"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_Render_Trace(t *testing.T) {
+	manifests := []*unstructured.Unstructured{
+		mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: myapp
+spec:
+  replicas: 1
+`),
+		mustObj(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: myapp
+spec:
+  ports:
+    - port: 8080
+`),
+		mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: untouched
+`),
+	}
+	patchFile := FullPatchFile{
+		"myapp-prod": {Resources: ResourcePatches{
+			"deployment/myapp": {
+				{Op: "replace", Path: "/spec/replicas", Value: 3},
+				{Op: "add", Path: "/metadata/annotations", ValueFrom: &ValueFrom{Resource: "service/myapp", Path: "/metadata/labels"}},
+			},
+			"service/myapp": {
+				{Op: "replace", Path: "/metadata/name", Value: "myapp"},
+			},
+		}},
+	}
+
+	trace := &Trace{}
+	rendered, err := Render(manifests, patchFile, Options{Trace: trace})
+	require.NoError(t, err)
+	require.Len(t, trace.Objects, 3)
+
+	deploy := trace.Objects[0]
+	assert.Equal(t, "deployment/myapp", deploy.Base)
+	require.Len(t, deploy.Patches, 1)
+	p := deploy.Patches[0]
+	assert.Equal(t, "myapp-prod", p.App)
+	assert.Equal(t, "deployment/myapp", p.ResourceKey)
+	assert.Equal(t, map[string]string{"app.kubernetes.io/name": "myapp-prod"}, p.Labels)
+	assert.Equal(t, "myapp", p.RenamedFrom)
+	assert.Equal(t, "myapp-prod", p.RenamedTo)
+	require.Len(t, p.Ops, 3)
+	assert.True(t, p.Ops[0].Injected)
+	assert.Equal(t, int64(1), p.Ops[1].Before)
+	assert.Equal(t, int64(3), p.Ops[1].After)
+	assert.False(t, p.Ops[2].HadBefore)
+	assert.True(t, p.Ops[2].HasAfter)
+	assert.NotNil(t, p.Ops[2].ValueFrom)
+
+	// an explicit metadata.name op means no rename by kubepatch
+	svc := trace.Objects[1].Patches[0]
+	assert.Empty(t, svc.RenamedTo)
+	assert.False(t, svc.Ops[0].Injected)
+
+	assert.Empty(t, trace.Objects[2].Patches)
+
+	var out strings.Builder
+	require.NoError(t, trace.Write(&out, rendered, []string{"base/deploy.yaml", "base/svc.yaml", "base/cm.yaml"}))
+	text := out.String()
+	assert.Contains(t, text, "# deployment/myapp-prod (apps/v1)\nsource: base/deploy.yaml\nbase:   deployment/myapp\n")
+	assert.Contains(t, text, "  rename: myapp -> myapp-prod\n")
+	assert.Contains(t, text, "  1. replace /spec/replicas\n     before: 1\n     after:  3\n")
+	assert.Contains(t, text, "  2. add /metadata/annotations (valueFrom service/myapp /metadata/labels)\n     before: <unset>\n")
+	assert.Contains(t, text, "# configmap/untouched (v1)\nsource: base/cm.yaml\nbase:   configmap/untouched\nnot patched\n")
+}
+
+func Test_Render_TraceNotes(t *testing.T) {
+	trace := &Trace{}
+	_, err := Render(autoscaledManifests(), FullPatchFile{
+		"web": {
+			Resources: ResourcePatches{"deployment/web": nil},
+			PodSpec:   &PodSpecOverlay{ServiceAccountName: "web"},
+		},
+	}, Options{DropAutoscaledReplicas: true, Trace: trace})
+	require.NoError(t, err)
+
+	// the deployment has no pod template, so only the replicas note is expected
+	assert.Equal(t, []string{
+		"spec.replicas removed, the workload is scaled by horizontalpodautoscaler/web",
+	}, trace.Objects[0].Notes)
+
+	trace = &Trace{}
+	_, err = Render([]*unstructured.Unstructured{mustObj(`
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      containers:
+        - name: migrate
+`)}, FullPatchFile{
+		"migrate": {
+			Resources: ResourcePatches{"job/migrate": nil},
+			PodSpec:   &PodSpecOverlay{ServiceAccountName: "migrate"},
+		},
+	}, Options{Trace: trace})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`podSpec of app "migrate" merged into the pod spec`}, trace.Objects[0].Notes)
+}
+
+func Test_Render_TraceFailedOp(t *testing.T) {
+	_, err := Render([]*unstructured.Unstructured{mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+`)}, FullPatchFile{
+		"cm": {Resources: ResourcePatches{
+			"configmap/cm": {{Op: "remove", Path: "/data/missing"}},
+		}},
+	}, Options{Trace: &Trace{}})
+	assert.Error(t, err)
+}
+
+func TestTrace_WriteRedacted(t *testing.T) {
+	r := redact.New()
+	r.Add("token-1234")
+	trace := &Trace{Redactor: r}
+	rendered, err := Render([]*unstructured.Unstructured{
+		mustObj(`
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: old-password
+`),
+		mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+`),
+	}, FullPatchFile{
+		"db": {Resources: ResourcePatches{"secret/db": {
+			{Op: "replace", Path: "/stringData/password", Value: "new-password"},
+		}}},
+		"cfg": {Resources: ResourcePatches{"configmap/cfg": {
+			{Op: "add", Path: "/data", Value: map[string]interface{}{"url": "https://api?token=token-1234", "level": "info"}},
+		}}},
+	}, Options{Trace: trace})
+	require.NoError(t, err)
+
+	var buf strings.Builder
+	require.NoError(t, trace.Write(&buf, rendered, nil))
+	out := buf.String()
+	assert.NotContains(t, out, "old-password")
+	assert.NotContains(t, out, "new-password")
+	assert.NotContains(t, out, "token-1234")
+	assert.Contains(t, out, `before: "`+r.Mask("old-password")+`"`)
+	assert.Contains(t, out, `"level":"info"`)
+
+	// the rendered objects keep the real values
+	password, _, err := unstructured.NestedString(rendered[0].Object, "stringData", "password")
+	require.NoError(t, err)
+	assert.Equal(t, "new-password", password)
+}
@@ -0,0 +1,225 @@
+package patch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ValueFrom copies an operation value from another object of the same render,
+// e.g. {resource: service/myapp, path: /spec/ports/0/port}.
+type ValueFrom struct {
+	// Resource is the "kind/name" of the referenced base manifest.
+	Resource string `yaml:"resource" json:"resource"`
+	// Path is a JSON pointer into the referenced object.
+	Path string `yaml:"path" json:"path"`
+}
+
+// orderJobs sorts jobs so that every job runs after all jobs patching the
+// objects it references via valueFrom, including the valueFrom of ops nested in
+// embedded ops. Independent jobs keep their order. A reference to the object a
+// job patches does not order anything, it is resolved against the base manifest.
+func orderJobs(manifests []*unstructured.Unstructured, jobs []job) ([]job, error) {
+	// jobs patching each manifest, by manifest index
+	byIndex := make(map[int][]int)
+	for i, j := range jobs {
+		byIndex[j.index] = append(byIndex[j.index], i)
+	}
+
+	// references are resolved by base identity, before anything gets renamed
+	deps := make([][]int, len(jobs))
+	for i := range jobs {
+		j := &jobs[i]
+		j.refs = make(map[*ValueFrom]int)
+		err := forEachValueFrom(j.ops, func(vf *ValueFrom) error {
+			ref, err := findReferenced(manifests, vf.Resource)
+			if err != nil {
+				return err
+			}
+			j.refs[vf] = ref
+			if ref != j.index {
+				deps[i] = append(deps[i], byIndex[ref]...)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("valueFrom in %s/%s: %w", j.kind, j.name, err)
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(jobs))
+	ordered := make([]job, 0, len(jobs))
+	var stack []int
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("valueFrom cycle detected: %s", describeCycle(jobs, stack, i))
+		}
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, d := range deps[i] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = done
+		ordered = append(ordered, jobs[i])
+		return nil
+	}
+
+	for i := range jobs {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// describeCycle formats the part of the visit stack that forms a cycle.
+func describeCycle(jobs []job, stack []int, start int) string {
+	var parts []string
+	for k := len(stack) - 1; k >= 0; k-- {
+		if stack[k] == start {
+			for _, i := range stack[k:] {
+				parts = append(parts, jobs[i].resourceKey)
+			}
+			break
+		}
+	}
+	parts = append(parts, jobs[start].resourceKey)
+	return strings.Join(parts, " -> ")
+}
+
+// forEachValueFrom calls fn with the valueFrom of every operation, descending
+// into the ops of embedded operations.
+func forEachValueFrom(ops []Operation, fn func(*ValueFrom) error) error {
+	for _, op := range ops {
+		if op.ValueFrom != nil {
+			if err := fn(op.ValueFrom); err != nil {
+				return err
+			}
+		}
+		if err := forEachValueFrom(op.Ops, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selfReferenced returns a copy of every base manifest that a job patching it
+// references, for resolving those references before the manifest is patched.
+func selfReferenced(manifests []*unstructured.Unstructured, jobs []job) map[int]*unstructured.Unstructured {
+	bases := make(map[int]*unstructured.Unstructured)
+	for _, j := range jobs {
+		for _, ref := range j.refs {
+			if ref == j.index && bases[ref] == nil {
+				bases[ref] = manifests[ref].DeepCopy()
+			}
+		}
+	}
+	return bases
+}
+
+// findReferenced returns the index of the only manifest matching a "kind/name" key.
+func findReferenced(manifests []*unstructured.Unstructured, resourceKey string) (int, error) {
+	kind, name, err := parseResourceKey(resourceKey)
+	if err != nil {
+		return 0, err
+	}
+	found := -1
+	for i, doc := range manifests {
+		if !matchesResource(doc, kind, name) {
+			continue
+		}
+		if found >= 0 {
+			return 0, fmt.Errorf("resource %q is ambiguous", resourceKey)
+		}
+		found = i
+	}
+	if found < 0 {
+		return 0, fmt.Errorf("resource %q not found", resourceKey)
+	}
+	return found, nil
+}
+
+// resolveValuesFrom returns a copy of the job operations with every valueFrom,
+// nested ones included, replaced by the value currently found in the referenced
+// manifest, or in its base for a manifest referencing itself.
+func resolveValuesFrom(manifests []*unstructured.Unstructured, bases map[int]*unstructured.Unstructured, j job) ([]Operation, error) {
+	return resolveOps(manifests, bases, j, j.ops)
+}
+
+func resolveOps(manifests []*unstructured.Unstructured, bases map[int]*unstructured.Unstructured, j job, ops []Operation) ([]Operation, error) {
+	if ops == nil {
+		return nil, nil
+	}
+	resolved := make([]Operation, len(ops))
+	for i, op := range ops {
+		resolved[i] = op
+		if len(op.Ops) > 0 {
+			nested, err := resolveOps(manifests, bases, j, op.Ops)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i].Ops = nested
+		}
+		if op.ValueFrom == nil {
+			continue
+		}
+		ref := j.refs[op.ValueFrom]
+		source := manifests[ref]
+		if ref == j.index {
+			source = bases[ref]
+		}
+		value, err := lookupPointer(source.Object, op.ValueFrom.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op.ValueFrom.Resource, err)
+		}
+		resolved[i].Value = value
+		resolved[i].ValueFrom = nil
+	}
+	return resolved, nil
+}
+
+// lookupPointer returns the value addressed by a JSON pointer (RFC 6901).
+func lookupPointer(doc interface{}, pointer string) (interface{}, error) {
+	if pointer == "" {
+		return doc, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	curr := doc
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch node := curr.(type) {
+		case map[string]interface{}:
+			child, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", pointer)
+			}
+			curr = child
+		case []interface{}:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, fmt.Errorf("path %q not found", pointer)
+			}
+			curr = node[idx]
+		default:
+			return nil, fmt.Errorf("path %q not found", pointer)
+		}
+	}
+	return curr, nil
+}
@@ -0,0 +1,360 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_Render_ValueFromPatchedResource(t *testing.T) {
+	deployment := mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: myapp
+spec:
+  template:
+    spec:
+      containers:
+        - name: myapp
+          env: []
+`)
+	service := mustObj(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: myapp
+spec:
+  ports:
+    - port: 8080
+`)
+
+	patchFile := FullPatchFile{
+		"myapp-prod": {Resources: ResourcePatches{
+			// deployment sorts before service, so ordering must come from the reference
+			"deployment/myapp": {
+				{
+					Op:   "add",
+					Path: "/spec/template/spec/containers/0/env/-",
+					Value: map[string]interface{}{
+						"name": "SERVICE_NAME",
+					},
+				},
+				{
+					Op:        "add",
+					Path:      "/spec/template/spec/containers/0/env/0/value",
+					ValueFrom: &ValueFrom{Resource: "service/myapp", Path: "/metadata/name"},
+				},
+				{
+					Op:        "add",
+					Path:      "/metadata/annotations",
+					ValueFrom: &ValueFrom{Resource: "service/myapp", Path: "/metadata/labels"},
+				},
+			},
+			"service/myapp": {
+				{Op: "replace", Path: "/spec/ports/0/port", Value: 9090},
+			},
+		}},
+	}
+
+	rendered, err := Render([]*unstructured.Unstructured{deployment, service}, patchFile, Options{})
+	require.NoError(t, err)
+	require.Len(t, rendered, 2)
+
+	env, _, err := unstructured.NestedSlice(rendered[0].Object, "spec", "template", "spec", "containers")
+	require.NoError(t, err)
+	container, ok := env[0].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "SERVICE_NAME", "value": "myapp-prod"},
+	}, container["env"])
+	assert.Equal(t, map[string]string{"app.kubernetes.io/name": "myapp-prod"}, rendered[0].GetAnnotations())
+}
+
+func Test_Render_ValueFromPort(t *testing.T) {
+	ingress := mustObj(`
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: myapp
+spec:
+  defaultBackend:
+    service:
+      name: myapp
+      port:
+        number: 80
+`)
+	service := mustObj(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: myapp
+spec:
+  ports:
+    - port: 8080
+`)
+
+	patchFile := FullPatchFile{
+		"myapp": {Resources: ResourcePatches{
+			"ingress/myapp": {
+				{
+					Op:        "replace",
+					Path:      "/spec/defaultBackend/service/port/number",
+					ValueFrom: &ValueFrom{Resource: "service/myapp", Path: "/spec/ports/0/port"},
+				},
+			},
+			"service/myapp": {
+				{Op: "replace", Path: "/spec/ports/0/port", Value: 9090},
+			},
+		}},
+	}
+
+	rendered, err := Render([]*unstructured.Unstructured{ingress, service}, patchFile, Options{})
+	require.NoError(t, err)
+
+	port, found, err := unstructured.NestedInt64(rendered[0].Object, "spec", "defaultBackend", "service", "port", "number")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(9090), port)
+}
+
+func Test_Render_ValueFromCycle(t *testing.T) {
+	a := mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+data:
+  key: a
+`)
+	b := mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+data:
+  key: b
+`)
+
+	patchFile := FullPatchFile{
+		"app": {Resources: ResourcePatches{
+			"configmap/a": {
+				{Op: "replace", Path: "/data/key", ValueFrom: &ValueFrom{Resource: "configmap/b", Path: "/data/key"}},
+			},
+			"configmap/b": {
+				{Op: "replace", Path: "/data/key", ValueFrom: &ValueFrom{Resource: "configmap/a", Path: "/data/key"}},
+			},
+		}},
+	}
+
+	_, err := Render([]*unstructured.Unstructured{a, b}, patchFile, Options{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cycle")
+	assert.Contains(t, err.Error(), "configmap/a -> configmap/b -> configmap/a")
+}
+
+func Test_Render_ValueFromSelf(t *testing.T) {
+	deployment := mustObj(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: myapp
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+        - name: myapp
+`)
+
+	patchFile := FullPatchFile{
+		"myapp-prod": {Resources: ResourcePatches{
+			"deployment/myapp": {
+				{Op: "replace", Path: "/spec/replicas", Value: 5},
+				{
+					Op:   "add",
+					Path: "/spec/template/spec/containers/0/env",
+					Value: []interface{}{
+						map[string]interface{}{"name": "BASE_NAME"},
+						map[string]interface{}{"name": "BASE_REPLICAS"},
+					},
+				},
+				{
+					Op:        "add",
+					Path:      "/spec/template/spec/containers/0/env/0/value",
+					ValueFrom: &ValueFrom{Resource: "deployment/myapp", Path: "/metadata/name"},
+				},
+				{
+					Op:        "add",
+					Path:      "/spec/template/spec/containers/0/env/1/value",
+					ValueFrom: &ValueFrom{Resource: "deployment/myapp", Path: "/spec/replicas"},
+				},
+			},
+		}},
+	}
+
+	rendered, err := Render([]*unstructured.Unstructured{deployment}, patchFile, Options{})
+	require.NoError(t, err)
+
+	containers, _, err := unstructured.NestedSlice(rendered[0].Object, "spec", "template", "spec", "containers")
+	require.NoError(t, err)
+	container, ok := containers[0].(map[string]interface{})
+	require.True(t, ok)
+	// the base manifest is read, before the rename and the replicas patch
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "BASE_NAME", "value": "myapp"},
+		map[string]interface{}{"name": "BASE_REPLICAS", "value": int64(2)},
+	}, container["env"])
+	assert.Equal(t, "myapp-prod", rendered[0].GetName())
+}
+
+func Test_Render_ValueFromEmbedded(t *testing.T) {
+	cm := mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: myapp
+data:
+  application.yaml: |
+    server:
+      port: 80
+`)
+	service := mustObj(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: myapp
+spec:
+  ports:
+    - port: 8080
+`)
+
+	patchFile := FullPatchFile{
+		"myapp": {Resources: ResourcePatches{
+			"configmap/myapp": {
+				{
+					Op:     OpEmbedded,
+					Path:   "/data/application.yaml",
+					Format: "yaml",
+					Ops: []Operation{
+						{
+							Op:        "replace",
+							Path:      "/server/port",
+							ValueFrom: &ValueFrom{Resource: "service/myapp", Path: "/spec/ports/0/port"},
+						},
+					},
+				},
+			},
+			"service/myapp": {
+				{Op: "replace", Path: "/spec/ports/0/port", Value: 9090},
+			},
+		}},
+	}
+
+	rendered, err := Render([]*unstructured.Unstructured{cm, service}, patchFile, Options{})
+	require.NoError(t, err)
+
+	data, _, err := unstructured.NestedStringMap(rendered[0].Object, "data")
+	require.NoError(t, err)
+	assert.Equal(t, "server:\n  port: 9090\n", data["application.yaml"])
+
+	// nested references are checked like top-level ones
+	patchFile["myapp"].Resources["configmap/myapp"][0].Ops[0].ValueFrom = &ValueFrom{Resource: "service/missing", Path: "/spec"}
+	_, err = Render([]*unstructured.Unstructured{cm, service}, patchFile, Options{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `resource "service/missing" not found`)
+}
+
+func Test_Render_ValueFromErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		valueFrom ValueFrom
+		wantErr   string
+	}{
+		{
+			name:      "unknown resource",
+			valueFrom: ValueFrom{Resource: "service/missing", Path: "/spec"},
+			wantErr:   `resource "service/missing" not found`,
+		},
+		{
+			name:      "unknown path",
+			valueFrom: ValueFrom{Resource: "configmap/other", Path: "/data/missing"},
+			wantErr:   `path "/data/missing" not found`,
+		},
+		{
+			name:      "invalid resource key",
+			valueFrom: ValueFrom{Resource: "configmap", Path: "/data"},
+			wantErr:   `invalid resource key`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifests := []*unstructured.Unstructured{
+				mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+data:
+  key: value
+`),
+				mustObj(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: other
+data:
+  key: other
+`),
+			}
+			vf := tt.valueFrom
+			patchFile := FullPatchFile{
+				"app": {Resources: ResourcePatches{
+					"configmap/cm": {
+						{Op: "replace", Path: "/data/key", ValueFrom: &vf},
+					},
+				}},
+			}
+
+			_, err := Render(manifests, patchFile, Options{})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestLookupPointer(t *testing.T) {
+	doc := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				"example.com/a~b": "escaped",
+			},
+		},
+		"spec": map[string]interface{}{
+			"ports": []interface{}{
+				map[string]interface{}{"port": int64(80)},
+			},
+		},
+	}
+
+	v, err := lookupPointer(doc, "/spec/ports/0/port")
+	require.NoError(t, err)
+	assert.Equal(t, int64(80), v)
+
+	v, err = lookupPointer(doc, "/metadata/annotations/example.com~1a~0b")
+	require.NoError(t, err)
+	assert.Equal(t, "escaped", v)
+
+	v, err = lookupPointer(doc, "")
+	require.NoError(t, err)
+	assert.Equal(t, doc, v)
+
+	_, err = lookupPointer(doc, "/spec/ports/1")
+	assert.Error(t, err)
+
+	_, err = lookupPointer(doc, "spec")
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+// Package redact masks secret values in diagnostics: explanations, diffs and
+// debug logs. Rendered manifests are never redacted.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kubepatch/kubepatch/internal/unstr"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// MaskPrefix starts every masked value.
+const MaskPrefix = "***"
+
+// MinLength is the length below which added values are only masked as whole
+// values, not inside other strings, since values like "1" or "on" would mask
+// unrelated text.
+const MinLength = 4
+
+// secretFields are the fields of a Secret whose values are masked.
+var secretFields = []string{"data", "stringData"}
+
+// Redactor masks the values of Secrets and the values added to it, e.g. the
+// values of allowlisted environment variables. Equal values get equal masks
+// and different values different ones, so that diffs still show what changed;
+// the masks are keyed per Redactor and cannot be reversed by guessing values.
+// A nil Redactor does not redact anything.
+type Redactor struct {
+	key []byte
+
+	mu       sync.Mutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+// New returns a Redactor with a random key.
+func New() *Redactor {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("redact: reading random key: %v", err))
+	}
+	return &Redactor{key: key, values: map[string]bool{}}
+}
+
+// Add registers secret values, which are masked as whole values of any type,
+// e.g. the integer 8080 for "8080", and wherever they occur in strings if they
+// are at least MinLength long.
+func (r *Redactor) Add(values ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range values {
+		if v != "" && !r.values[v] {
+			r.values[v] = true
+			r.replacer = nil
+		}
+	}
+}
+
+// added reports whether v is a registered value.
+func (r *Redactor) added(v string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.values[v]
+}
+
+// Mask returns the mask of a single value, e.g. "***3f2a9c1b".
+func (r *Redactor) Mask(value string) string {
+	if r == nil {
+		return value
+	}
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return MaskPrefix + hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
+// String masks the added values occurring in s, or s itself if it was added.
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	if r.added(s) {
+		return r.Mask(s)
+	}
+	rep := r.stringReplacer()
+	if rep == nil {
+		return s
+	}
+	return rep.Replace(s)
+}
+
+// Value returns a copy of v with the added values masked in every string, and
+// every scalar equal to an added value masked as a whole.
+func (r *Redactor) Value(v interface{}) interface{} {
+	if r == nil {
+		return v
+	}
+	switch t := v.(type) {
+	case string:
+		return r.String(t)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			out[k] = r.Value(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			out[i] = r.Value(item)
+		}
+		return out
+	case nil:
+		return nil
+	default:
+		// typed values, e.g. substituted with ${VAR|int} or ${VAR|bool}
+		if b, err := json.Marshal(t); err == nil && r.added(string(b)) {
+			return r.Mask(string(b))
+		}
+		return v
+	}
+}
+
+// Object returns a copy of obj with the values of a Secret masked and the
+// added values masked in every string. The last-applied-configuration
+// annotation of a Secret, which repeats its data, is masked as a whole.
+func (r *Redactor) Object(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	if r == nil || obj == nil {
+		return obj
+	}
+	out := obj.DeepCopy()
+	if m, ok := r.Value(out.Object).(map[string]interface{}); ok {
+		out.Object = m
+	}
+	if !unstr.IsSecret(out) {
+		return out
+	}
+	for _, field := range secretFields {
+		// masked from the original values, which may contain added values
+		if data, ok := obj.Object[field].(map[string]interface{}); ok {
+			out.Object[field] = r.all(data)
+		}
+	}
+	if annotations := out.GetAnnotations(); annotations[unstr.LastAppliedConfigAnnotation] != "" {
+		annotations[unstr.LastAppliedConfigAnnotation] = r.Mask(obj.GetAnnotations()[unstr.LastAppliedConfigAnnotation])
+		out.SetAnnotations(annotations)
+	}
+	return out
+}
+
+// Field redacts v, the value at the JSON pointer path of obj.
+func (r *Redactor) Field(obj *unstructured.Unstructured, path string, v interface{}) interface{} {
+	if r == nil {
+		return v
+	}
+	if obj != nil && unstr.IsSecret(obj) {
+		if path == "" || path == "/" {
+			if m, ok := v.(map[string]interface{}); ok {
+				return r.Object(&unstructured.Unstructured{Object: m}).Object
+			}
+		}
+		for _, field := range secretFields {
+			if path == "/"+field || strings.HasPrefix(path, "/"+field+"/") {
+				return r.all(v)
+			}
+		}
+	}
+	return r.Value(v)
+}
+
+// all masks every scalar below v, keeping the keys of maps.
+func (r *Redactor) all(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return r.Mask(t)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			out[k] = r.all(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			out[i] = r.all(item)
+		}
+		return out
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return r.Mask(fmt.Sprint(t))
+		}
+		return r.Mask(string(b))
+	}
+}
+
+func (r *Redactor) stringReplacer() *strings.Replacer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.replacer != nil {
+		return r.replacer
+	}
+	// longest first, so that a value containing another one is masked as a whole
+	values := make([]string, 0, len(r.values))
+	for v := range r.values {
+		if len(v) >= MinLength {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, r.Mask(v))
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+	return r.replacer
+}
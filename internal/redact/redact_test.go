@@ -0,0 +1,125 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func mustObj(t *testing.T, s string) *unstructured.Unstructured {
+	t.Helper()
+	var m map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(s), &m))
+	return &unstructured.Unstructured{Object: m}
+}
+
+func TestMask(t *testing.T) {
+	r := New()
+	assert.Equal(t, r.Mask("a"), r.Mask("a"))
+	assert.NotEqual(t, r.Mask("a"), r.Mask("b"))
+	assert.Regexp(t, `^\*\*\*[0-9a-f]{8}$`, r.Mask("a"))
+	assert.NotEqual(t, r.Mask("a"), New().Mask("a"), "masks are keyed per redactor")
+}
+
+func TestString(t *testing.T) {
+	r := New()
+	r.Add("password", "pass", "on")
+	assert.Equal(t, "url=db://u:"+r.Mask("password")+"@db "+r.Mask("pass")+" on",
+		r.String("url=db://u:password@db pass on"))
+}
+
+func TestObject(t *testing.T) {
+	r := New()
+	r.Add("token-1234")
+	secret := mustObj(t, `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{"data":{"password":"cGFzcw=="}}'
+data:
+  password: cGFzcw==
+stringData:
+  port: 5432
+  nested: {a: b}
+`)
+	before := secret.DeepCopy()
+
+	out := r.Object(secret)
+	assert.Equal(t, before, secret, "the input is not modified")
+	assert.Equal(t, map[string]interface{}{"password": r.Mask("cGFzcw==")}, out.Object["data"])
+	assert.Equal(t, map[string]interface{}{
+		"port":   r.Mask("5432"),
+		"nested": map[string]interface{}{"a": r.Mask("b")},
+	}, out.Object["stringData"])
+	assert.NotContains(t, out.GetAnnotations()["kubectl.kubernetes.io/last-applied-configuration"], "cGFzcw==")
+	assert.Equal(t, "db", out.GetName())
+
+	cm := mustObj(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+data:
+  url: https://api?token=token-1234
+  plain: value
+`)
+	out = r.Object(cm)
+	assert.Equal(t, map[string]interface{}{
+		"url":   "https://api?token=" + r.Mask("token-1234"),
+		"plain": "value",
+	}, out.Object["data"])
+}
+
+func TestField(t *testing.T) {
+	r := New()
+	r.Add("token-1234")
+	secret := mustObj(t, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\n")
+	cm := mustObj(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg\n")
+
+	assert.Equal(t, r.Mask("x"), r.Field(secret, "/stringData/password", "x"))
+	assert.Equal(t, map[string]interface{}{"a": r.Mask("x")}, r.Field(secret, "/data", map[string]interface{}{"a": "x"}))
+	assert.Equal(t, "web", r.Field(secret, "/metadata/labels/app", "web"))
+	assert.Equal(t, "x", r.Field(cm, "/data/password", "x"))
+	assert.Equal(t, r.Mask("token-1234"), r.Field(cm, "/data/token", "token-1234"))
+}
+
+func TestNil(t *testing.T) {
+	var r *Redactor
+	r.Add("secret")
+	obj := mustObj(t, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\ndata:\n  a: b\n")
+	assert.Same(t, obj, r.Object(obj))
+	assert.Equal(t, "secret", r.String("secret"))
+	assert.Equal(t, "b", r.Field(obj, "/data/a", "b"))
+}
+
+func TestObject_SecretValueAdded(t *testing.T) {
+	r := New()
+	r.Add("hunter22")
+	out := r.Object(mustObj(t, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\nstringData:\n  password: hunter22\n"))
+	assert.Equal(t, map[string]interface{}{"password": r.Mask("hunter22")}, out.Object["stringData"])
+}
+
+func TestValue_ShortAndTypedValues(t *testing.T) {
+	r := New()
+	r.Add("on", "8080", "true")
+
+	assert.Equal(t, r.Mask("on"), r.Value("on"), "a short value is masked as a whole")
+	assert.Equal(t, "only on Mondays", r.Value("only on Mondays"), "but not inside other strings")
+	assert.Equal(t, r.Mask("on"), r.String("on"))
+
+	assert.Equal(t, r.Mask("8080"), r.Value(int64(8080)))
+	assert.Equal(t, r.Mask("true"), r.Value(true))
+	assert.Equal(t, int64(80), r.Value(int64(80)))
+	assert.Equal(t, false, r.Value(false))
+	assert.Nil(t, r.Value(nil))
+
+	cm := mustObj(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cfg\ndata:\n  mode: \"on\"\nspec:\n  port: 8080\n  debug: true\n")
+	out := r.Object(cm)
+	assert.Equal(t, map[string]interface{}{"mode": r.Mask("on")}, out.Object["data"])
+	assert.Equal(t, map[string]interface{}{"port": r.Mask("8080"), "debug": r.Mask("true")}, out.Object["spec"])
+}
@@ -0,0 +1,418 @@
+package resolve
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Environment variables configuring how remote manifests are fetched.
+const (
+	// HTTPTimeoutEnv is the timeout of a single request, e.g. 30s.
+	HTTPTimeoutEnv = "KUBEPATCH_HTTP_TIMEOUT"
+	// HTTPRetriesEnv is the number of retries after a failed request.
+	HTTPRetriesEnv = "KUBEPATCH_HTTP_RETRIES"
+	// HTTPMaxBodySizeEnv limits the size of a response, in bytes or as a
+	// quantity like 64Mi.
+	HTTPMaxBodySizeEnv = "KUBEPATCH_HTTP_MAX_BODY_SIZE"
+	// HTTPTokenEnv is a bearer token sent to the hosts of HTTPAuthHostsEnv.
+	HTTPTokenEnv = "KUBEPATCH_HTTP_TOKEN"
+	// HTTPUsernameEnv and HTTPPasswordEnv are basic auth credentials sent to
+	// the hosts of HTTPAuthHostsEnv, used when there is no token.
+	HTTPUsernameEnv = "KUBEPATCH_HTTP_USERNAME"
+	HTTPPasswordEnv = "KUBEPATCH_HTTP_PASSWORD"
+	// HTTPAuthHostsEnv is a comma-separated list of the hosts, e.g.
+	// gitlab.example.com or nexus:8443, the token or basic auth is sent to.
+	HTTPAuthHostsEnv = "KUBEPATCH_HTTP_AUTH_HOSTS"
+	// HTTPCAFileEnv names a PEM bundle of CAs trusted besides the system ones.
+	HTTPCAFileEnv = "KUBEPATCH_HTTP_CA_FILE"
+	// HTTPProxyEnv is the proxy of all requests; when it is not set,
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY are used.
+	HTTPProxyEnv = "KUBEPATCH_HTTP_PROXY"
+	// NetrcEnv names the netrc file with per-host credentials, ~/.netrc by default.
+	NetrcEnv = "NETRC"
+)
+
+const (
+	defaultHTTPTimeout     = 30 * time.Second
+	defaultHTTPRetries     = 3
+	defaultHTTPMaxBodySize = 64 << 20
+
+	initialBackoff = 500 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+)
+
+// HTTPOptions configure a Fetcher.
+type HTTPOptions struct {
+	// Timeout of a single request, including reading the response.
+	Timeout time.Duration
+	// Retries after a network error, 408, 429 or 5xx, with exponential backoff.
+	Retries int
+	// MaxBodySize limits the size of a response.
+	MaxBodySize int64
+	// Token is sent as bearer token, or else Username and Password as basic
+	// auth, to the AuthHosts only. For other hosts, or when none is set, the
+	// credentials of the host in the netrc file are used.
+	Token    string
+	Username string
+	Password string
+	// AuthHosts are the hosts the Token or basic auth is sent to, each a
+	// host name or host:port.
+	AuthHosts []string
+	// NetrcFile has per-host credentials; a missing file is ignored.
+	NetrcFile string
+	// CAFile is a PEM bundle of CAs trusted besides the system ones.
+	CAFile string
+	// Proxy is the URL of the proxy of all requests; when it is empty the
+	// proxy is taken from the environment.
+	Proxy string
+}
+
+// HTTPOptionsFromEnv returns the options set by the KUBEPATCH_HTTP_* and
+// NETRC environment variables, with defaults for the others.
+func HTTPOptionsFromEnv() (HTTPOptions, error) {
+	opts := HTTPOptions{
+		Timeout:     defaultHTTPTimeout,
+		Retries:     defaultHTTPRetries,
+		MaxBodySize: defaultHTTPMaxBodySize,
+		Token:       os.Getenv(HTTPTokenEnv),
+		Username:    os.Getenv(HTTPUsernameEnv),
+		Password:    os.Getenv(HTTPPasswordEnv),
+		NetrcFile:   os.Getenv(NetrcEnv),
+		CAFile:      os.Getenv(HTTPCAFileEnv),
+		Proxy:       os.Getenv(HTTPProxyEnv),
+	}
+	for _, host := range strings.Split(os.Getenv(HTTPAuthHostsEnv), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			opts.AuthHosts = append(opts.AuthHosts, host)
+		}
+	}
+	if v := os.Getenv(HTTPTimeoutEnv); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout < 0 {
+			return opts, fmt.Errorf("$%s: invalid duration %q", HTTPTimeoutEnv, v)
+		}
+		opts.Timeout = timeout
+	}
+	if v := os.Getenv(HTTPRetriesEnv); v != "" {
+		retries, err := strconv.Atoi(v)
+		if err != nil || retries < 0 {
+			return opts, fmt.Errorf("$%s: invalid number %q", HTTPRetriesEnv, v)
+		}
+		opts.Retries = retries
+	}
+	if v := os.Getenv(HTTPMaxBodySizeEnv); v != "" {
+		size, err := resource.ParseQuantity(v)
+		if err != nil || size.Sign() <= 0 {
+			return opts, fmt.Errorf("$%s: invalid size %q", HTTPMaxBodySizeEnv, v)
+		}
+		opts.MaxBodySize = size.Value()
+	}
+	if opts.NetrcFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			opts.NetrcFile = home + string(os.PathSeparator) + ".netrc"
+		}
+	}
+	return opts, nil
+}
+
+// Fetcher reads remote files.
+type Fetcher struct {
+	opts   HTTPOptions
+	client *http.Client
+	netrc  []netrcEntry
+	// sleep waits between retries, replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewFetcher returns a Fetcher with the options, reading the netrc and CA files.
+func NewFetcher(opts HTTPOptions) (*Fetcher, error) {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = defaultHTTPMaxBodySize
+	}
+	if (opts.Token != "" || opts.Username != "") && len(opts.AuthHosts) == 0 {
+		log.Printf("WARNING: the HTTP credentials are not sent to any host, set $%s", HTTPAuthHostsEnv)
+	}
+	transport, err := newTransport(&opts)
+	if err != nil {
+		return nil, err
+	}
+	f := &Fetcher{
+		opts:   opts,
+		client: &http.Client{Transport: transport, Timeout: opts.Timeout},
+		sleep:  sleepContext,
+	}
+	if opts.NetrcFile != "" {
+		f.netrc, err = readNetrc(opts.NetrcFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func newTransport(opts *HTTPOptions) (*http.Transport, error) {
+	transport := &http.Transport{}
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = t.Clone()
+	}
+	transport.Proxy = http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		proxy, err := url.Parse(opts.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL: %s", redactURL(opts.Proxy))
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", opts.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return transport, nil
+}
+
+var (
+	defaultFetcherOnce sync.Once
+	defaultFetcher     *Fetcher
+	errDefaultFetcher  error
+)
+
+func readRemoteFileContent(ctx context.Context, inputURL string) ([]byte, error) {
+	defaultFetcherOnce.Do(func() {
+		opts, err := HTTPOptionsFromEnv()
+		if err != nil {
+			errDefaultFetcher = err
+			return
+		}
+		defaultFetcher, errDefaultFetcher = NewFetcher(opts)
+	})
+	if errDefaultFetcher != nil {
+		return nil, errDefaultFetcher
+	}
+	return defaultFetcher.Fetch(ctx, inputURL)
+}
+
+// Fetch returns the content of a URL, retrying transient failures until ctx
+// is canceled. Errors name the URL with its credentials and token-like query
+// values redacted.
+func (f *Fetcher) Fetch(ctx context.Context, inputURL string) ([]byte, error) {
+	parsedURL, err := url.Parse(inputURL)
+	if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
+		return nil, fmt.Errorf("invalid URL: %s", redactURL(inputURL))
+	}
+	redacted := redactURL(inputURL)
+
+	for attempt := 0; ; attempt++ {
+		body, retryAfter, err := f.get(ctx, parsedURL)
+		if err == nil {
+			return body, nil
+		}
+		err = fmt.Errorf("GET %s: %w", redacted, err)
+		if retryAfter < 0 || attempt >= f.opts.Retries {
+			return nil, err
+		}
+		wait := backoff(attempt)
+		if retryAfter > 0 {
+			wait = min(retryAfter, maxBackoff)
+		}
+		log.Printf("WARNING: %v, retrying in %s", err, wait)
+		if err := f.sleep(ctx, wait); err != nil {
+			return nil, fmt.Errorf("GET %s: %w", redacted, err)
+		}
+	}
+}
+
+// sleepContext waits for d, or until ctx is canceled.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// get does a single request. A failure is retried after retryAfter, or after
+// the backoff when it is 0; a negative retryAfter is permanent.
+func (f *Fetcher) get(ctx context.Context, u *url.URL) ([]byte, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return nil, -1, err
+	}
+	f.authorize(req)
+
+	response, err := f.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// the url.Error repeats the unredacted URL
+			err = urlErr.Err
+		}
+		if ctx.Err() != nil || permanent(err) {
+			return nil, -1, err
+		}
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		err := errors.New(response.Status)
+		if retryable(response.StatusCode) {
+			return nil, retryAfter(response.Header.Get("Retry-After")), err
+		}
+		return nil, -1, err
+	}
+
+	limit := f.opts.MaxBodySize
+	if response.ContentLength > limit {
+		return nil, -1, fmt.Errorf("response of %d bytes exceeds the limit of %d bytes", response.ContentLength, limit)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if int64(len(body)) > limit {
+		return nil, -1, fmt.Errorf("response exceeds the limit of %d bytes", limit)
+	}
+	return body, 0, nil
+}
+
+// authorize sets the configured credentials for the auth hosts, or those of
+// the netrc file for the host. Credentials are only sent over https, and never
+// replace those of the URL itself.
+func (f *Fetcher) authorize(req *http.Request) {
+	if req.URL.User != nil {
+		return
+	}
+	var token, username, password string
+	if f.authHost(req.URL) {
+		token, username, password = f.opts.Token, f.opts.Username, f.opts.Password
+	}
+	if token == "" && username == "" {
+		entry, ok := lookupNetrc(f.netrc, req.URL.Hostname())
+		if !ok {
+			return
+		}
+		username, password = entry.login, entry.password
+	}
+	if req.URL.Scheme != "https" {
+		log.Printf("WARNING: not sending credentials to %s over plain http", redactURL(req.URL.String()))
+		return
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return
+	}
+	req.SetBasicAuth(username, password)
+}
+
+// authHost reports whether u is on one of the auth hosts.
+func (f *Fetcher) authHost(u *url.URL) bool {
+	for _, host := range f.opts.AuthHosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// permanent reports whether a transport error fails again on retry: a
+// certificate that is not trusted, a TLS handshake with a plain http server,
+// or a host name that does not resolve.
+func permanent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsNotFound
+	}
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	return errors.As(err, &verifyErr) || errors.As(err, &recordErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
+
+func retryable(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryAfter parses a Retry-After header in seconds or as HTTP date, 0 when
+// it is missing or invalid.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// backoff returns the wait before retry attempt+1: 0.5s, 1s, 2s, ... capped at maxBackoff.
+func backoff(attempt int) time.Duration {
+	wait := initialBackoff
+	for i := 0; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxBackoff)
+}
+
+// sensitiveParams are substrings of query parameter names whose values are
+// redacted, e.g. token, access_token or X-Amz-Signature.
+var sensitiveParams = []string{"token", "key", "secret", "password", "signature", "sig", "auth", "credential"}
+
+// redactURL returns u with the password of its userinfo and the values of
+// token-like query parameters redacted.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		// an unparsable URL can't be redacted selectively
+		return "xxxxx"
+	}
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		for name := range query {
+			lower := strings.ToLower(name)
+			for _, s := range sensitiveParams {
+				if strings.Contains(lower, s) {
+					query[name] = []string{"xxxxx"}
+					break
+				}
+			}
+		}
+		parsed.RawQuery = query.Encode()
+	}
+	return parsed.Redacted()
+}
@@ -0,0 +1,100 @@
+package resolve
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// netrcEntry holds the credentials of a machine in a netrc file; the
+// default entry has an empty machine.
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// readNetrc reads the entries of a netrc file, none if it does not exist.
+func readNetrc(path string) ([]netrcEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseNetrc(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// parseNetrc parses the machine, default, login and password tokens of a
+// netrc file, skipping account values and macdef definitions.
+func parseNetrc(data string) ([]netrcEntry, error) {
+	var entries []netrcEntry
+	current := -1
+	inMacdef := false
+	for lineNum, line := range strings.Split(data, "\n") {
+		if inMacdef {
+			// a macro definition ends with an empty line
+			inMacdef = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			switch token := fields[i]; token {
+			case "default":
+				entries = append(entries, netrcEntry{})
+				current = len(entries) - 1
+			case "macdef":
+				inMacdef = true
+				i = len(fields)
+			case "machine", "login", "password", "account":
+				if i+1 == len(fields) {
+					return nil, fmt.Errorf("line %d: %s without a value", lineNum+1, token)
+				}
+				i++
+				value := fields[i]
+				if token == "machine" {
+					entries = append(entries, netrcEntry{machine: value})
+					current = len(entries) - 1
+					continue
+				}
+				if current < 0 {
+					return nil, fmt.Errorf("line %d: %s before machine", lineNum+1, token)
+				}
+				switch token {
+				case "login":
+					entries[current].login = value
+				case "password":
+					entries[current].password = value
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unexpected token %q", lineNum+1, token)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// lookupNetrc returns the entry of the host, or else the default entry.
+func lookupNetrc(entries []netrcEntry, host string) (netrcEntry, bool) {
+	for _, e := range entries {
+		if e.machine != "" && strings.EqualFold(e.machine, host) {
+			return e, true
+		}
+	}
+	for _, e := range entries {
+		if e.machine == "" {
+			return e, true
+		}
+	}
+	return netrcEntry{}, false
+}
@@ -1,10 +1,9 @@
 package resolve
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"io/fs"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -14,9 +13,10 @@ import (
 
 var FileExtensions = []string{".json", ".yaml", ".yml"}
 
-func ReadFileContent(filename string) ([]byte, error) {
-	if isURL(filename) {
-		return readRemoteFileContent(filename)
+// ReadFileContent reads a file or fetches a URL, which stops when ctx is canceled.
+func ReadFileContent(ctx context.Context, filename string) ([]byte, error) {
+	if IsURL(filename) {
+		return readRemoteFileContent(ctx, filename)
 	}
 	return os.ReadFile(filename)
 }
@@ -41,7 +41,7 @@ func resolveFilenamesForPatterns(path string, recursive bool) ([]string, error)
 
 	// Check if the path is a URL
 
-	if isURL(path) {
+	if IsURL(path) {
 		// Add URL directly to results
 		results = append(results, path)
 	} else if strings.Contains(path, "*") {
@@ -88,7 +88,8 @@ func resolveFilenamesForPatterns(path string, recursive bool) ([]string, error)
 	return results, nil
 }
 
-func isURL(s string) bool {
+// IsURL reports whether s is a remote URL rather than a local path.
+func IsURL(s string) bool {
 	u, err := url.Parse(s)
 	return err == nil && u.Scheme != "" && u.Host != ""
 }
@@ -105,31 +106,3 @@ func ignoreFile(path string, extensions []string) bool {
 	}
 	return true
 }
-
-func readRemoteFileContent(inputURL string) ([]byte, error) {
-	// Parse and validate the URL
-	parsedURL, err := url.Parse(inputURL)
-	if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
-		return nil, fmt.Errorf("invalid URL: %s", inputURL)
-	}
-
-	// Make the HTTP GET request
-	response, err := http.Get(parsedURL.String())
-	if err != nil {
-		return nil, err
-	}
-	defer response.Body.Close()
-
-	// Check for HTTP errors
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("cannot GET file content from: %s", inputURL)
-	}
-
-	// Read the response body
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return body, nil
-}
@@ -1,6 +1,7 @@
 package resolve
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -127,9 +128,9 @@ func TestIsURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := isURL(tt.input)
+			result := IsURL(tt.input)
 			if result != tt.expected {
-				t.Errorf("isURL(%q) = %v, expected %v", tt.input, result, tt.expected)
+				t.Errorf("IsURL(%q) = %v, expected %v", tt.input, result, tt.expected)
 			}
 		})
 	}
@@ -305,7 +306,7 @@ func TestReadRemoteFileContent(t *testing.T) {
 			}
 
 			// Call the function under test
-			got, err := readRemoteFileContent(inputURL)
+			got, err := readRemoteFileContent(context.Background(), inputURL)
 
 			// Check for errors
 			if tt.wantError {
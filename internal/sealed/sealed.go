@@ -0,0 +1,312 @@
+// Package sealed converts Secrets into SealedSecrets of the Bitnami
+// sealed-secrets controller, offline, with the controller's public certificate.
+package sealed
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kubepatch/kubepatch/internal/unstr"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	APIVersion = "bitnami.com/v1alpha1"
+	Kind       = "SealedSecret"
+)
+
+// Scope annotations, set on Secrets to choose their scope and on the SealedSecrets.
+const (
+	NamespaceWideAnnotation = "sealedsecrets.bitnami.com/namespace-wide"
+	ClusterWideAnnotation   = "sealedsecrets.bitnami.com/cluster-wide"
+)
+
+// Scope limits where a SealedSecret can be decrypted.
+type Scope string
+
+const (
+	// ScopeStrict binds a SealedSecret to the name and namespace of its Secret.
+	ScopeStrict Scope = "strict"
+	// ScopeNamespaceWide allows renaming the Secret within its namespace.
+	ScopeNamespaceWide Scope = "namespace-wide"
+	// ScopeClusterWide allows any name and namespace.
+	ScopeClusterWide Scope = "cluster-wide"
+)
+
+// sessionKeyBytes is the size of the AES-256 key of every value.
+const sessionKeyBytes = 32
+
+// ParseScope parses a scope name.
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(s); scope {
+	case ScopeStrict, ScopeNamespaceWide, ScopeClusterWide:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown sealing scope %q, expected %s, %s or %s", s, ScopeStrict, ScopeNamespaceWide, ScopeClusterWide)
+	}
+}
+
+// ReadPublicKey reads the RSA public key of a sealed-secrets certificate,
+// e.g. as fetched with "kubeseal --fetch-cert".
+func ReadPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// ParsePublicKey parses a PEM encoded certificate or RSA public key.
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	var pub interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if time.Now().After(cert.NotAfter) {
+			log.Printf("WARNING: the sealed-secrets certificate expired on %s", cert.NotAfter.Format(time.DateOnly))
+		}
+		pub = cert.PublicKey
+	case "PUBLIC KEY":
+		var err error
+		if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q, expected a certificate", block.Type)
+	}
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the certificate does not hold an RSA public key")
+	}
+	return key, nil
+}
+
+// Sealer seals Secrets with the public key of a sealed-secrets controller.
+type Sealer struct {
+	key   *rsa.PublicKey
+	scope Scope
+}
+
+// NewSealer returns a Sealer using scope for Secrets without a scope annotation.
+func NewSealer(key *rsa.PublicKey, scope Scope) *Sealer {
+	return &Sealer{key: key, scope: scope}
+}
+
+// SealAll returns objects with every v1/Secret replaced by a SealedSecret.
+// The other objects are returned as they are.
+func (s *Sealer) SealAll(objects []*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	out := make([]*unstructured.Unstructured, len(objects))
+	for i, obj := range objects {
+		if !unstr.IsSecret(obj) {
+			out[i] = obj
+			continue
+		}
+		sealed, err := s.Seal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("secret/%s: %w", obj.GetName(), err)
+		}
+		out[i] = sealed
+	}
+	return out, nil
+}
+
+// Seal returns the SealedSecret of a Secret. Its scope is taken from the
+// scope annotations of the Secret, or else the default scope of the Sealer.
+func (s *Sealer) Seal(secret *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	scope := s.scopeOf(secret)
+	if scope != ScopeClusterWide && secret.GetNamespace() == "" {
+		return nil, fmt.Errorf("a %s SealedSecret requires metadata.namespace", scope)
+	}
+
+	values, err := secretValues(secret)
+	if err != nil {
+		return nil, err
+	}
+	label := EncryptionLabel(secret.GetNamespace(), secret.GetName(), scope)
+	encrypted := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		ciphertext, err := HybridEncrypt(rand.Reader, s.key, v, label)
+		if err != nil {
+			return nil, fmt.Errorf("encrypting %q: %w", k, err)
+		}
+		encrypted[k] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	// the controller reads the scope from the template annotations
+	templateMeta := templateMetadata(secret)
+	setScopeAnnotations(templateMeta, scope)
+	template := map[string]interface{}{"metadata": templateMeta}
+	for _, field := range []string{"type", "immutable"} {
+		if v, ok := secret.Object[field]; ok {
+			template[field] = v
+		}
+	}
+
+	meta := map[string]interface{}{"name": secret.GetName()}
+	if ns := secret.GetNamespace(); ns != "" {
+		meta["namespace"] = ns
+	}
+	if labels, ok := templateMeta["labels"]; ok {
+		meta["labels"] = labels
+	}
+	setScopeAnnotations(meta, scope)
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": APIVersion,
+		"kind":       Kind,
+		"metadata":   meta,
+		"spec": map[string]interface{}{
+			"encryptedData": encrypted,
+			"template":      template,
+		},
+	}}, nil
+}
+
+func (s *Sealer) scopeOf(secret *unstructured.Unstructured) Scope {
+	annotations := secret.GetAnnotations()
+	switch {
+	case annotations[ClusterWideAnnotation] == "true":
+		return ScopeClusterWide
+	case annotations[NamespaceWideAnnotation] == "true":
+		return ScopeNamespaceWide
+	case s.scope == "":
+		return ScopeStrict
+	default:
+		return s.scope
+	}
+}
+
+// EncryptionLabel is the RSA-OAEP label binding a value to its scope.
+func EncryptionLabel(namespace, name string, scope Scope) []byte {
+	switch scope {
+	case ScopeClusterWide:
+		return []byte{}
+	case ScopeNamespaceWide:
+		return []byte(namespace)
+	default:
+		return []byte(namespace + "/" + name)
+	}
+}
+
+// HybridEncrypt encrypts plaintext like kubeseal: a random AES-256-GCM key
+// encrypts the plaintext and is itself encrypted with RSA-OAEP SHA-256. The
+// result is the 2 byte length of the RSA ciphertext, the RSA ciphertext and
+// the AES ciphertext.
+func HybridEncrypt(rnd io.Reader, key *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeyBytes)
+	if _, err := io.ReadFull(rnd, sessionKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), rnd, key, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 2, 2+len(rsaCiphertext)+len(plaintext)+gcm.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(rsaCiphertext))) //nolint:gosec // at most the RSA key size
+	out = append(out, rsaCiphertext...)
+	// every session key encrypts a single value, so a zero nonce is safe
+	return gcm.Seal(out, make([]byte, gcm.NonceSize()), plaintext, nil), nil
+}
+
+// secretValues returns the decoded data of a Secret, merged with its stringData.
+func secretValues(secret *unstructured.Unstructured) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	data, _, err := unstructured.NestedMap(secret.Object, "data")
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range data {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("data.%s is not a string", k)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("data.%s is not valid base64: %w", k, err)
+		}
+		values[k] = decoded
+	}
+	stringData, _, err := unstructured.NestedMap(secret.Object, "stringData")
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range stringData {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("stringData.%s is not a string", k)
+		}
+		values[k] = []byte(s)
+	}
+	return values, nil
+}
+
+// templateMetadata copies the metadata of a Secret for the template of its
+// SealedSecret, without the fields set by the cluster and without the
+// last-applied-configuration annotation, which holds the data in plain text.
+func templateMetadata(secret *unstructured.Unstructured) map[string]interface{} {
+	c := secret.DeepCopy()
+	unstr.Sanitize(c)
+	meta, ok := c.Object["metadata"].(map[string]interface{})
+	if !ok {
+		meta = map[string]interface{}{}
+	}
+	delete(meta, "ownerReferences")
+	return meta
+}
+
+// setScopeAnnotations sets the annotation of scope on metadata and removes the other one.
+func setScopeAnnotations(meta map[string]interface{}, scope Scope) {
+	annotations, ok := meta["annotations"].(map[string]interface{})
+	if !ok {
+		annotations = map[string]interface{}{}
+	}
+	delete(annotations, NamespaceWideAnnotation)
+	delete(annotations, ClusterWideAnnotation)
+	switch scope {
+	case ScopeNamespaceWide:
+		annotations[NamespaceWideAnnotation] = "true"
+	case ScopeClusterWide:
+		annotations[ClusterWideAnnotation] = "true"
+	}
+	if len(annotations) == 0 {
+		delete(meta, "annotations")
+		return
+	}
+	meta["annotations"] = annotations
+}
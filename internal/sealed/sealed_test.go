@@ -0,0 +1,314 @@
+package sealed
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func mustObj(t *testing.T, s string) *unstructured.Unstructured {
+	t.Helper()
+	var m map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(s), &m))
+	return &unstructured.Unstructured{Object: m}
+}
+
+// hybridDecrypt mirrors the decryption of the sealed-secrets controller.
+func hybridDecrypt(t *testing.T, key *rsa.PrivateKey, ciphertext, label []byte) ([]byte, error) {
+	t.Helper()
+	require.Greater(t, len(ciphertext), 2)
+	n := int(binary.BigEndian.Uint16(ciphertext))
+	require.Greater(t, len(ciphertext), 2+n)
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext[2:2+n], label)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	return gcm.Open(nil, make([]byte, gcm.NonceSize()), ciphertext[2+n:], nil)
+}
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func certPEM(t *testing.T, key *rsa.PrivateKey, notAfter time.Time) []byte {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    notAfter.Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encryptedValue(t *testing.T, key *rsa.PrivateKey, obj *unstructured.Unstructured, name string, label []byte) string {
+	t.Helper()
+	v, ok, err := unstructured.NestedString(obj.Object, "spec", "encryptedData", name)
+	require.NoError(t, err)
+	require.True(t, ok, "encryptedData.%s", name)
+	ciphertext, err := base64.StdEncoding.DecodeString(v)
+	require.NoError(t, err)
+	plaintext, err := hybridDecrypt(t, key, ciphertext, label)
+	require.NoError(t, err)
+	return string(plaintext)
+}
+
+const secretYAML = `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: prod
+  labels:
+    app: myapp
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{"data":{"password":"cGFzc3dvcmQ="}}'
+    team: payments
+  uid: 1234
+  resourceVersion: "5"
+type: kubernetes.io/basic-auth
+data:
+  username: YWRtaW4=
+  password: b2xk
+stringData:
+  password: s3cr3t
+`
+
+func TestParsePublicKey(t *testing.T) {
+	key := newKey(t)
+
+	pub, err := ParsePublicKey(certPEM(t, key, time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(pub))
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	pub, err = ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(pub))
+
+	// an expired certificate is still used, with a warning
+	_, err = ParsePublicKey(certPEM(t, key, time.Now().Add(-time.Hour)))
+	require.NoError(t, err)
+
+	_, err = ParsePublicKey([]byte("not a certificate"))
+	assert.Error(t, err)
+	_, err = ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("x")}))
+	assert.Error(t, err)
+}
+
+func TestReadPublicKey(t *testing.T) {
+	key := newKey(t)
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	require.NoError(t, os.WriteFile(path, certPEM(t, key, time.Now().Add(time.Hour)), 0o600))
+	pub, err := ReadPublicKey(path)
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(pub))
+
+	_, err = ReadPublicKey(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+}
+
+func TestParseScope(t *testing.T) {
+	for _, s := range []string{"strict", "namespace-wide", "cluster-wide"} {
+		scope, err := ParseScope(s)
+		require.NoError(t, err)
+		assert.Equal(t, Scope(s), scope)
+	}
+	_, err := ParseScope("global")
+	assert.Error(t, err)
+}
+
+func TestSeal(t *testing.T) {
+	key := newKey(t)
+	secret := mustObj(t, secretYAML)
+	sealed, err := NewSealer(&key.PublicKey, ScopeStrict).Seal(secret)
+	require.NoError(t, err)
+
+	assert.Equal(t, APIVersion, sealed.GetAPIVersion())
+	assert.Equal(t, Kind, sealed.GetKind())
+	assert.Equal(t, "db", sealed.GetName())
+	assert.Equal(t, "prod", sealed.GetNamespace())
+	assert.Equal(t, map[string]string{"app": "myapp"}, sealed.GetLabels())
+	assert.Empty(t, sealed.GetAnnotations())
+
+	label := []byte("prod/db")
+	assert.Equal(t, "admin", encryptedValue(t, key, sealed, "username", label))
+	assert.Equal(t, "s3cr3t", encryptedValue(t, key, sealed, "password", label), "stringData overrides data")
+
+	// the values are bound to the name and namespace
+	v, _, err := unstructured.NestedString(sealed.Object, "spec", "encryptedData", "username")
+	require.NoError(t, err)
+	ciphertext, err := base64.StdEncoding.DecodeString(v)
+	require.NoError(t, err)
+	_, err = hybridDecrypt(t, key, ciphertext, []byte("prod/other"))
+	assert.Error(t, err)
+
+	templateType, _, err := unstructured.NestedString(sealed.Object, "spec", "template", "type")
+	require.NoError(t, err)
+	assert.Equal(t, "kubernetes.io/basic-auth", templateType)
+	templateMeta, _, err := unstructured.NestedMap(sealed.Object, "spec", "template", "metadata")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name":        "db",
+		"namespace":   "prod",
+		"labels":      map[string]interface{}{"app": "myapp"},
+		"annotations": map[string]interface{}{"team": "payments"},
+	}, templateMeta)
+
+	_, found, err := unstructured.NestedFieldNoCopy(sealed.Object, "data")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// the secret is left as it was
+	assert.Equal(t, mustObj(t, secretYAML), secret)
+}
+
+func TestSeal_Scopes(t *testing.T) {
+	key := newKey(t)
+	tests := []struct {
+		name        string
+		scope       Scope
+		annotations string
+		label       string
+		annotation  string
+	}{
+		{name: "strict", scope: ScopeStrict, label: "prod/db"},
+		{name: "default", label: "prod/db"},
+		{name: "namespace-wide", scope: ScopeNamespaceWide, label: "prod", annotation: NamespaceWideAnnotation},
+		{name: "cluster-wide", scope: ScopeClusterWide, label: "", annotation: ClusterWideAnnotation},
+		{
+			name:        "annotated secret",
+			scope:       ScopeStrict,
+			annotations: "\n    " + ClusterWideAnnotation + `: "true"`,
+			label:       "",
+			annotation:  ClusterWideAnnotation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := mustObj(t, `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: prod
+  annotations:
+    team: payments`+tt.annotations+`
+stringData:
+  password: s3cr3t
+`)
+			sealed, err := NewSealer(&key.PublicKey, tt.scope).Seal(secret)
+			require.NoError(t, err)
+			assert.Equal(t, "s3cr3t", encryptedValue(t, key, sealed, "password", []byte(tt.label)))
+
+			for _, path := range [][]string{
+				{"metadata", "annotations"},
+				{"spec", "template", "metadata", "annotations"},
+			} {
+				annotations, _, err := unstructured.NestedStringMap(sealed.Object, path...)
+				require.NoError(t, err)
+				if tt.annotation == "" {
+					assert.NotContains(t, annotations, NamespaceWideAnnotation)
+					assert.NotContains(t, annotations, ClusterWideAnnotation)
+				} else {
+					assert.Equal(t, "true", annotations[tt.annotation], path)
+				}
+			}
+		})
+	}
+}
+
+func TestSeal_Namespace(t *testing.T) {
+	key := newKey(t)
+	secret := mustObj(t, `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: s3cr3t
+`)
+	_, err := NewSealer(&key.PublicKey, ScopeStrict).Seal(secret)
+	assert.ErrorContains(t, err, "namespace")
+	_, err = NewSealer(&key.PublicKey, ScopeNamespaceWide).Seal(secret)
+	assert.ErrorContains(t, err, "namespace")
+
+	sealed, err := NewSealer(&key.PublicKey, ScopeClusterWide).Seal(secret)
+	require.NoError(t, err)
+	assert.Empty(t, sealed.GetNamespace())
+	assert.Equal(t, "s3cr3t", encryptedValue(t, key, sealed, "password", nil))
+}
+
+func TestSeal_InvalidData(t *testing.T) {
+	key := newKey(t)
+	secret := mustObj(t, `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: prod
+data:
+  password: not base64!
+`)
+	_, err := NewSealer(&key.PublicKey, ScopeStrict).Seal(secret)
+	assert.ErrorContains(t, err, "data.password")
+}
+
+func TestSealAll(t *testing.T) {
+	key := newKey(t)
+	objects := []*unstructured.Unstructured{
+		mustObj(t, `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: prod
+data:
+  key: value
+`),
+		mustObj(t, secretYAML),
+		mustObj(t, `
+apiVersion: example.com/v1
+kind: Secret
+metadata:
+  name: not-a-core-secret
+`),
+	}
+	out, err := NewSealer(&key.PublicKey, ScopeStrict).SealAll(objects)
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+	assert.Same(t, objects[0], out[0])
+	assert.Equal(t, Kind, out[1].GetKind())
+	assert.Same(t, objects[2], out[2])
+
+	objects[1].SetNamespace("")
+	_, err = NewSealer(&key.PublicKey, ScopeStrict).SealAll(objects)
+	assert.ErrorContains(t, err, "secret/db")
+}
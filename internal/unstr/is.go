@@ -41,3 +41,7 @@ func IsKustomization(object *unstructured.Unstructured) bool {
 func IsSecret(object *unstructured.Unstructured) bool {
 	return strings.ToLower(object.GetKind()) == "secret" && object.GetAPIVersion() == "v1"
 }
+
+func IsService(object *unstructured.Unstructured) bool {
+	return strings.ToLower(object.GetKind()) == "service" && object.GetAPIVersion() == "v1"
+}
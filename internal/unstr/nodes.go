@@ -0,0 +1,97 @@
+package unstr
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// nodeHeader holds the fields ReadObjects filters documents by.
+type nodeHeader struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Items yaml.Node `yaml:"items"`
+}
+
+// ReadNodes decodes content into YAML node trees, one per object returned by
+// ReadObjects for the same content, keeping key order, comments and quoting.
+// It returns nil when the documents cannot be aligned with objects.
+func ReadNodes(content []byte, objects []*unstructured.Unstructured) []*yaml.Node {
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	var nodes []*yaml.Node
+	for {
+		doc := &yaml.Node{}
+		err := dec.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil
+		}
+		if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			continue
+		}
+
+		var hdr nodeHeader
+		if err := doc.Content[0].Decode(&hdr); err != nil {
+			return nil
+		}
+		if hdr.Items.Kind == yaml.SequenceNode {
+			// a List: its items are the objects
+			for _, item := range hdr.Items.Content {
+				nodes = append(nodes, &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{item}})
+			}
+			continue
+		}
+		if keepNode(&hdr) {
+			nodes = append(nodes, doc)
+		}
+	}
+
+	if len(nodes) != len(objects) {
+		return nil
+	}
+	for i, n := range nodes {
+		var hdr nodeHeader
+		if err := n.Content[0].Decode(&hdr); err != nil {
+			return nil
+		}
+		if hdr.Kind != objects[i].GetKind() || hdr.Metadata.Name != objects[i].GetName() {
+			return nil
+		}
+	}
+	return nodes
+}
+
+func keepNode(hdr *nodeHeader) bool {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(hdr.APIVersion)
+	obj.SetKind(hdr.Kind)
+	obj.SetName(hdr.Metadata.Name)
+	return IsKubernetesObject(obj) && !IsKustomization(obj)
+}
+
+// Retyped reports whether a plain scalar of value is not read back as the
+// same string. Manifests and patch-files are read with YAML 1.1 rules, which
+// yaml.v3 does not quote for, e.g. "on" is a boolean and "0777" an octal
+// integer there. Multi-line values are written as literal blocks, which are
+// always strings.
+func Retyped(value string) bool {
+	if strings.Contains(value, "\n") {
+		return false
+	}
+	var v interface{}
+	if err := sigsyaml.Unmarshal([]byte(value), &v); err != nil {
+		return true
+	}
+	s, ok := v.(string)
+	return !ok || s != value
+}
@@ -0,0 +1,49 @@
+package unstr
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// LastAppliedConfigAnnotation is written by 'kubectl apply' and holds the previously applied object.
+const LastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// ServerFields are populated by the API server and never part of base or
+// rendered manifests, as field paths.
+var ServerFields = [][]string{
+	{"status"},
+	{"metadata", "managedFields"},
+	{"metadata", "uid"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "generation"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "selfLink"},
+}
+
+// Sanitize removes everything the cluster added to an object exported with
+// 'kubectl get -o yaml', so that it can be used as a base manifest: status,
+// server-populated metadata, the last-applied-configuration annotation and
+// cluster IPs allocated to Services (headless "None" is kept).
+// The object is modified in place.
+func Sanitize(obj *unstructured.Unstructured) {
+	for _, field := range ServerFields {
+		unstructured.RemoveNestedField(obj.Object, field...)
+	}
+
+	if annotations := obj.GetAnnotations(); annotations != nil {
+		if _, ok := annotations[LastAppliedConfigAnnotation]; ok {
+			delete(annotations, LastAppliedConfigAnnotation)
+			if len(annotations) == 0 {
+				annotations = nil
+			}
+			obj.SetAnnotations(annotations)
+		}
+	}
+
+	if IsService(obj) {
+		clusterIP, _, err := unstructured.NestedString(obj.Object, "spec", "clusterIP")
+		if err != nil || clusterIP != "None" {
+			unstructured.RemoveNestedField(obj.Object, "spec", "clusterIP")
+			unstructured.RemoveNestedField(obj.Object, "spec", "clusterIPs")
+		}
+	}
+}
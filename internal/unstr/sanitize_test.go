@@ -0,0 +1,129 @@
+package unstr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "deployment",
+			input: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  uid: 3f1c
+  resourceVersion: "123"
+  generation: 4
+  creationTimestamp: "2024-01-01T00:00:00Z"
+  managedFields:
+    - manager: kubectl
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{}'
+spec:
+  replicas: 2
+status:
+  readyReplicas: 2
+`,
+			expected: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+spec:
+  replicas: 2
+`,
+		},
+		{
+			name: "other annotations are kept",
+			input: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{}'
+    team: web
+`,
+			expected: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+  annotations:
+    team: web
+`,
+		},
+		{
+			name: "allocated cluster IP",
+			input: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  clusterIP: 10.96.0.12
+  clusterIPs:
+    - 10.96.0.12
+  ports:
+    - port: 80
+`,
+			expected: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+    - port: 80
+`,
+		},
+		{
+			name: "headless service",
+			input: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: db
+spec:
+  clusterIP: None
+  clusterIPs:
+    - None
+`,
+			expected: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: db
+spec:
+  clusterIP: None
+  clusterIPs:
+    - None
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := ReadObjects(strings.NewReader(tt.input))
+			require.NoError(t, err)
+			require.Len(t, objs, 1)
+			expected, err := ReadObjects(strings.NewReader(tt.expected))
+			require.NoError(t, err)
+
+			Sanitize(objs[0])
+			assert.Equal(t, expected[0].Object, objs[0].Object)
+		})
+	}
+}
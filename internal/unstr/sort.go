@@ -0,0 +1,95 @@
+package unstr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Sort orders.
+const (
+	// SortInstall orders objects so that every object comes after the objects it needs.
+	SortInstall = "install"
+	// SortName orders objects by kind, namespace and name.
+	SortName = "name"
+)
+
+// installOrder ranks kinds for SortInstall, after Namespaces and CRDs.
+// Kinds not listed are installed after the workloads and before the webhooks,
+// which go last so that they cannot block the installation of the objects
+// they validate or mutate.
+var installOrder = map[string]int{
+	"priorityclass":                  2,
+	"storageclass":                   2,
+	"serviceaccount":                 3,
+	"clusterrole":                    4,
+	"role":                           5,
+	"clusterrolebinding":             6,
+	"rolebinding":                    7,
+	"resourcequota":                  8,
+	"limitrange":                     8,
+	"configmap":                      9,
+	"secret":                         9,
+	"sealedsecret":                   9,
+	"persistentvolume":               10,
+	"persistentvolumeclaim":          11,
+	"service":                        12,
+	"replicationcontroller":          13,
+	"pod":                            13,
+	"replicaset":                     13,
+	"deployment":                     13,
+	"statefulset":                    13,
+	"daemonset":                      13,
+	"job":                            13,
+	"cronjob":                        13,
+	"mutatingwebhookconfiguration":   15,
+	"validatingwebhookconfiguration": 15,
+}
+
+const (
+	namespaceRank  = 0
+	crdRank        = 1
+	otherKindsRank = 14
+)
+
+func installRank(obj *unstructured.Unstructured) int {
+	if IsClusterDefinition(obj) {
+		if IsCRD(obj) {
+			return crdRank
+		}
+		return namespaceRank
+	}
+	if rank, ok := installOrder[strings.ToLower(obj.GetKind())]; ok {
+		return rank
+	}
+	return otherKindsRank
+}
+
+// SortObjects returns objects ordered by SortInstall or SortName; an empty
+// order keeps the input order. Objects that compare equal keep their input
+// order, and the input slice is not modified.
+func SortObjects(objects []*unstructured.Unstructured, order string) ([]*unstructured.Unstructured, error) {
+	sorted := make([]*unstructured.Unstructured, len(objects))
+	copy(sorted, objects)
+
+	switch order {
+	case "":
+	case SortInstall:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return installRank(sorted[i]) < installRank(sorted[j])
+		})
+	case SortName:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return nameKey(sorted[i]) < nameKey(sorted[j])
+		})
+	default:
+		return nil, fmt.Errorf("unknown sort order %q, expected %s or %s", order, SortInstall, SortName)
+	}
+	return sorted, nil
+}
+
+func nameKey(obj *unstructured.Unstructured) string {
+	return strings.ToLower(obj.GetKind()) + "\x00" + obj.GetNamespace() + "\x00" + obj.GetName()
+}
@@ -0,0 +1,78 @@
+package unstr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func sortTestObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+func kindNames(objects []*unstructured.Unstructured) []string {
+	out := make([]string, len(objects))
+	for i, obj := range objects {
+		out[i] = obj.GetKind() + "/" + obj.GetName()
+	}
+	return out
+}
+
+func TestSortObjects(t *testing.T) {
+	objects := []*unstructured.Unstructured{
+		sortTestObject("admissionregistration.k8s.io/v1", "ValidatingWebhookConfiguration", "", "hook"),
+		sortTestObject("apps/v1", "Deployment", "prod", "web"),
+		sortTestObject("example.com/v1", "Widget", "prod", "w"),
+		sortTestObject("v1", "Service", "prod", "web"),
+		sortTestObject("v1", "Secret", "prod", "creds"),
+		sortTestObject("v1", "ConfigMap", "prod", "cfg"),
+		sortTestObject("rbac.authorization.k8s.io/v1", "RoleBinding", "prod", "web"),
+		sortTestObject("rbac.authorization.k8s.io/v1", "Role", "prod", "web"),
+		sortTestObject("v1", "ServiceAccount", "prod", "web"),
+		sortTestObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "widgets.example.com"),
+		sortTestObject("v1", "Namespace", "", "prod"),
+		sortTestObject("batch/v1", "Job", "prod", "migrate"),
+	}
+
+	sorted, err := SortObjects(objects, SortInstall)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Namespace/prod",
+		"CustomResourceDefinition/widgets.example.com",
+		"ServiceAccount/web",
+		"Role/web",
+		"RoleBinding/web",
+		"Secret/creds",
+		"ConfigMap/cfg",
+		"Service/web",
+		"Deployment/web",
+		"Job/migrate",
+		"Widget/w",
+		"ValidatingWebhookConfiguration/hook",
+	}, kindNames(sorted))
+	assert.Equal(t, "ValidatingWebhookConfiguration", objects[0].GetKind(), "the input is not modified")
+
+	sorted, err = SortObjects(objects[1:6], SortName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"ConfigMap/cfg",
+		"Deployment/web",
+		"Secret/creds",
+		"Service/web",
+		"Widget/w",
+	}, kindNames(sorted))
+
+	sorted, err = SortObjects(objects, "")
+	require.NoError(t, err)
+	assert.Equal(t, kindNames(objects), kindNames(sorted))
+
+	_, err = SortObjects(objects, "size")
+	assert.Error(t, err)
+}
@@ -2,20 +2,60 @@ package unstr
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 
 	"github.com/kubepatch/kubepatch/internal/resolve"
 
+	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
+// StdinSource is the Doc.Source of objects read from stdin.
+const StdinSource = "<stdin>"
+
+// Doc is a decoded object together with the file (or URL) it was read from.
+type Doc struct {
+	Source string
+	Object *unstructured.Unstructured
+	// Node is the YAML document the object was decoded from, see ReadNodes.
+	// It is only set by ReadPreservedDocs, and nil when the document could
+	// not be matched with the object.
+	Node *yaml.Node
+}
+
 // ReadDocs resolves -f arguments (or stdin '-') into a slice of decoded
 // Kubernetes objects. It expands directory globs, walks recursively if
 // requested and supports YAML documents containing multiple resources.
 func ReadDocs(filenames []string, recursive bool) ([]*unstructured.Unstructured, error) {
-	var allDocs []*unstructured.Unstructured
+	docs, err := ReadSourcedDocs(filenames, recursive)
+	if err != nil {
+		return nil, err
+	}
+	return Objects(docs), nil
+}
+
+// ReadSourcedDocs works like ReadDocs, but keeps the source of every object.
+func ReadSourcedDocs(filenames []string, recursive bool) ([]Doc, error) {
+	return readDocs(context.Background(), filenames, recursive, false)
+}
+
+// ReadSourcedDocsContext works like ReadSourcedDocs, but stops reading, and
+// fetching URLs, when ctx is canceled.
+func ReadSourcedDocsContext(ctx context.Context, filenames []string, recursive bool) ([]Doc, error) {
+	return readDocs(ctx, filenames, recursive, false)
+}
+
+// ReadPreservedDocs works like ReadSourcedDocs, but also keeps the YAML node
+// of every object, parsing the manifests a second time.
+func ReadPreservedDocs(filenames []string, recursive bool) ([]Doc, error) {
+	return readDocs(context.Background(), filenames, recursive, true)
+}
+
+func readDocs(ctx context.Context, filenames []string, recursive, withNodes bool) ([]Doc, error) {
+	var allDocs []Doc
 
 	// 1. stdin mode: exactly one filename equal to "-"
 	if len(filenames) == 1 && filenames[0] == "-" {
@@ -23,11 +63,11 @@ func ReadDocs(filenames []string, recursive bool) ([]*unstructured.Unstructured,
 		if err != nil {
 			return nil, fmt.Errorf("reading stdin: %w", err)
 		}
-		docs, err := ReadObjects(bytes.NewReader(d))
+		objects, err := ReadObjects(bytes.NewReader(d))
 		if err != nil {
 			return nil, err
 		}
-		allDocs = append(allDocs, docs...)
+		allDocs = appendDocs(allDocs, StdinSource, objects, readNodes(withNodes, d, objects))
 		return allDocs, nil
 	}
 
@@ -38,20 +78,53 @@ func ReadDocs(filenames []string, recursive bool) ([]*unstructured.Unstructured,
 	}
 
 	for _, file := range files {
-		fileContent, err := resolve.ReadFileContent(file)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fileContent, err := resolve.ReadFileContent(ctx, file)
 		if err != nil {
 			return nil, err
 		}
-		docs, err := ReadObjects(bytes.NewReader(fileContent))
+		objects, err := ReadObjects(bytes.NewReader(fileContent))
 		if err != nil {
 			return nil, err
 		}
-		allDocs = append(allDocs, docs...)
+		allDocs = appendDocs(allDocs, file, objects, readNodes(withNodes, fileContent, objects))
 	}
 
 	return allDocs, nil
 }
 
+func readNodes(withNodes bool, content []byte, objects []*unstructured.Unstructured) []*yaml.Node {
+	if !withNodes {
+		return nil
+	}
+	return ReadNodes(content, objects)
+}
+
+func appendDocs(docs []Doc, source string, objects []*unstructured.Unstructured, nodes []*yaml.Node) []Doc {
+	for i, obj := range objects {
+		d := Doc{Source: source, Object: obj}
+		if nodes != nil {
+			d.Node = nodes[i]
+		}
+		docs = append(docs, d)
+	}
+	return docs
+}
+
+// Objects returns the objects of docs, in order.
+func Objects(docs []Doc) []*unstructured.Unstructured {
+	if docs == nil {
+		return nil
+	}
+	out := make([]*unstructured.Unstructured, len(docs))
+	for i, d := range docs {
+		out[i] = d.Object
+	}
+	return out
+}
+
 func DeepCloneManifests(in []*unstructured.Unstructured) []*unstructured.Unstructured {
 	if in == nil {
 		return nil
@@ -1,11 +1,14 @@
 package unstr
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func Test_ReadDocs_FromStdin(t *testing.T) {
@@ -120,3 +123,85 @@ func Test_ReadDocs_ErrorOnMissingFile(t *testing.T) {
 	assert.Nil(t, objs)
 	assert.Error(t, err)
 }
+
+func Test_ReadSourcedDocs_KeepsSources(t *testing.T) {
+	tmp := t.TempDir()
+	first := filepath.Join(tmp, "a.yaml")
+	second := filepath.Join(tmp, "b.yaml")
+	assert.NoError(t, os.WriteFile(first, []byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a2
+`), 0o600))
+	assert.NoError(t, os.WriteFile(second, []byte(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: b
+`), 0o600))
+
+	docs, err := ReadSourcedDocs([]string{tmp}, false)
+	assert.NoError(t, err)
+	assert.Len(t, docs, 3)
+	assert.Equal(t, []string{first, first, second}, []string{docs[0].Source, docs[1].Source, docs[2].Source})
+	assert.Equal(t, "a2", docs[1].Object.GetName())
+
+	assert.Nil(t, docs[0].Node, "nodes are only read for preservation")
+
+	objs := Objects(docs)
+	assert.Len(t, objs, 3)
+	assert.Same(t, docs[2].Object, objs[2])
+
+	docs, err = ReadPreservedDocs([]string{tmp}, false)
+	assert.NoError(t, err)
+	assert.Len(t, docs, 3)
+	for _, d := range docs {
+		assert.NotNil(t, d.Node)
+	}
+}
+
+func TestReadNodes(t *testing.T) {
+	content := []byte(`# comment
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+---
+---
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+metadata:
+  name: k
+---
+apiVersion: v1
+kind: List
+items:
+  - apiVersion: v1
+    kind: Secret
+    metadata:
+      name: b
+`)
+	objects, err := ReadObjects(bytes.NewReader(content))
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+
+	nodes := ReadNodes(content, objects)
+	require.Len(t, nodes, 2)
+	out, err := yaml.Marshal(nodes[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "# comment")
+	var item map[string]interface{}
+	require.NoError(t, nodes[1].Decode(&item))
+	assert.Equal(t, "Secret", item["kind"])
+
+	// objects that do not match the documents
+	assert.Nil(t, ReadNodes(content, objects[:1]))
+	objects[1].SetName("other")
+	assert.Nil(t, ReadNodes(content, objects))
+}
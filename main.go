@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -10,7 +11,15 @@ import (
 func main() {
 	rootCmd := cmd.NewRootCmd()
 	if err := rootCmd.Execute(); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "exit with error: %v\n", err)
-		os.Exit(1)
+		code := 1
+		var exitErr *cmd.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.Code
+			err = exitErr.Err
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "exit with error: %v\n", err)
+		}
+		os.Exit(code)
 	}
 }
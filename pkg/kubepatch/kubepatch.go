@@ -0,0 +1,132 @@
+// Package kubepatch renders Kubernetes manifests with kubepatch patch-files.
+// It is the library counterpart of "kubepatch patch":
+//
+//	r := kubepatch.NewRenderer(kubepatch.Options{
+//		Sources:    []string{"base/"},
+//		PatchFiles: []string{"patches/prod.yaml"},
+//	})
+//	objects, report, err := r.Render(ctx)
+package kubepatch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubepatch/kubepatch/internal/patch"
+	"github.com/kubepatch/kubepatch/internal/unstr"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Options configure a Renderer.
+type Options struct {
+	// Sources are files, directories, glob patterns or URLs the base
+	// manifests are read from, like the -f flag.
+	Sources []string
+	// Recursive walks directories of Sources recursively.
+	Recursive bool
+	// Objects are base manifests supplied by the caller, rendered after the
+	// objects of Sources. They are copied and never modified.
+	Objects []*unstructured.Unstructured
+
+	// PatchFiles are applied as one patch-file, layered in order: operations
+	// for the same app and resource are appended, a later podSpec replaces
+	// an earlier one.
+	PatchFiles []string
+	// EnvsubstPrefixes allow ${VAR} substitution in the patch-files for
+	// environment variables with these prefixes, like --envsubst-prefixes.
+	EnvsubstPrefixes []string
+	// EnvsubstVars allow ${VAR} substitution for these variables, like --envsubst-vars.
+	EnvsubstVars []string
+	// EnvFiles are dotenv files with values for the variables not set in the
+	// environment, like --env-file. A later file overrides an earlier one.
+	EnvFiles []string
+	// ProvidersConfig is a config file enabling provider references like
+	// ${vault:secret/data/app#key}, like --providers-config.
+	ProvidersConfig string
+	// AgeKeyFile holds the age identities patch-files encrypted with age or
+	// SOPS are decrypted with. By default the file named by
+	// KUBEPATCH_AGE_KEY_FILE or SOPS_AGE_KEY_FILE is used.
+	AgeKeyFile string
+
+	// KeepNames keeps metadata.name of patched objects instead of setting it
+	// to the app name.
+	KeepNames bool
+	// Labels are added to every patched object, next to the app name label.
+	Labels map[string]string
+
+	// DropAutoscaledReplicas removes spec.replicas from workloads targeted by
+	// a HorizontalPodAutoscaler or a KEDA ScaledObject.
+	DropAutoscaledReplicas bool
+	// ConfigChecksums annotates pod templates with a hash of the ConfigMaps
+	// and Secrets they reference.
+	ConfigChecksums bool
+}
+
+// Renderer renders base manifests with patch-files.
+type Renderer struct {
+	opts Options
+}
+
+// NewRenderer returns a Renderer for opts.
+func NewRenderer(opts Options) *Renderer {
+	return &Renderer{opts: opts}
+}
+
+// Render reads the sources and patch-files and returns the rendered objects in
+// input order together with a report of how each of them was produced. The
+// sources are read in the order of the CLI, files sorted by path. Canceling
+// ctx stops the reading, including the fetches of URLs and the exec commands
+// and Vault requests of provider references.
+func (r *Renderer) Render(ctx context.Context) ([]*unstructured.Unstructured, *Report, error) {
+	if len(r.opts.Sources) == 0 && len(r.opts.Objects) == 0 {
+		return nil, nil, fmt.Errorf("no sources or objects to render")
+	}
+
+	// read like the CLI: the files of all sources are read in one sorted order
+	docs, err := unstr.ReadSourcedDocsContext(ctx, r.opts.Sources, r.opts.Recursive)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, obj := range r.opts.Objects {
+		if obj == nil {
+			return nil, nil, fmt.Errorf("object %d is nil", i+1)
+		}
+		docs = append(docs, unstr.Doc{Object: obj.DeepCopy()})
+	}
+
+	files := make([]patch.FullPatchFile, 0, len(r.opts.PatchFiles))
+	for _, path := range r.opts.PatchFiles {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		f, err := patch.ReadPatchFileWithOptions(path, patch.ReadOptions{
+			EnvsubstPrefixes: r.opts.EnvsubstPrefixes,
+			EnvsubstVars:     r.opts.EnvsubstVars,
+			EnvFiles:         r.opts.EnvFiles,
+			ProvidersConfig:  r.opts.ProvidersConfig,
+			AgeKeyFile:       r.opts.AgeKeyFile,
+			Context:          ctx,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		files = append(files, f)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	trace := &patch.Trace{}
+	rendered, err := patch.Render(unstr.Objects(docs), patch.Merge(files...), patch.Options{
+		DropAutoscaledReplicas: r.opts.DropAutoscaledReplicas,
+		ConfigChecksums:        r.opts.ConfigChecksums,
+		KeepNames:              r.opts.KeepNames,
+		Labels:                 r.opts.Labels,
+		Trace:                  trace,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return rendered, newReport(trace, docs, rendered), nil
+}
@@ -0,0 +1,195 @@
+package kubepatch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const base = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: myapp
+spec:
+  replicas: 1
+`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	source := writeFile(t, dir, "base.yaml", base)
+	prod := writeFile(t, dir, "prod.yaml", `
+myapp-prod:
+  deployment/myapp:
+    - op: replace
+      path: /spec/replicas
+      value: 2
+`)
+	extra := writeFile(t, dir, "extra.yaml", `
+myapp-prod:
+  deployment/myapp:
+    - op: replace
+      path: /spec/replicas
+      value: ${KP_TEST_REPLICAS}
+    - op: add
+      path: /spec/paused
+      value: ${KP_TEST_PAUSED}
+`)
+	t.Setenv("KP_TEST_REPLICAS", "3")
+	t.Setenv("KP_TEST_PAUSED", "true")
+
+	objects, report, err := NewRenderer(Options{
+		Sources:          []string{source},
+		PatchFiles:       []string{prod, extra},
+		EnvsubstPrefixes: []string{"KP_TEST_"},
+		Labels:           map[string]string{"team": "web"},
+	}).Render(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, objects, 1)
+	assert.Equal(t, "myapp-prod", objects[0].GetName())
+	assert.Equal(t, map[string]string{"app.kubernetes.io/name": "myapp-prod", "team": "web"}, objects[0].GetLabels())
+	replicas, _, err := unstructured.NestedInt64(objects[0].Object, "spec", "replicas")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), replicas)
+	paused, _, err := unstructured.NestedBool(objects[0].Object, "spec", "paused")
+	require.NoError(t, err)
+	assert.True(t, paused)
+
+	require.Len(t, report.Objects, 1)
+	o := report.Objects[0]
+	assert.Equal(t, source, o.Source)
+	assert.Equal(t, "deployment/myapp", o.Base)
+	assert.Equal(t, "deployment/myapp-prod", o.Rendered)
+	require.True(t, o.Patched())
+	p := o.Patches[0]
+	assert.Equal(t, "myapp-prod", p.App)
+	assert.Equal(t, "myapp", p.RenamedFrom)
+	require.Len(t, p.Operations, 4)
+	assert.True(t, p.Operations[0].Injected)
+	assert.Equal(t, "/spec/replicas", p.Operations[2].Path)
+	assert.Equal(t, int64(2), p.Operations[2].Before)
+}
+
+func TestRender_DoesNotMutateObjects(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "cfg"},
+		"data":       map[string]interface{}{"a": "b"},
+	}}
+	before := obj.DeepCopy()
+	patchFile := writeFile(t, t.TempDir(), "patch.yaml", `
+app:
+  configmap/cfg:
+    - op: replace
+      path: /data/a
+      value: c
+`)
+
+	objects, report, err := NewRenderer(Options{
+		Objects:    []*unstructured.Unstructured{obj},
+		PatchFiles: []string{patchFile},
+		KeepNames:  true,
+	}).Render(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, before, obj)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "cfg", objects[0].GetName())
+	value, _, err := unstructured.NestedString(objects[0].Object, "data", "a")
+	require.NoError(t, err)
+	assert.Equal(t, "c", value)
+	assert.Empty(t, report.Objects[0].Source)
+	assert.Empty(t, report.Objects[0].Patches[0].RenamedTo)
+}
+
+func TestRender_Errors(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	source := writeFile(t, t.TempDir(), "base.yaml", base)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		opts Options
+	}{
+		{name: "no input", ctx: context.Background(), opts: Options{}},
+		{name: "nil object", ctx: context.Background(), opts: Options{Objects: []*unstructured.Unstructured{nil}}},
+		{name: "missing patch-file", ctx: context.Background(), opts: Options{Sources: []string{source}, PatchFiles: []string{"missing.yaml"}}},
+		{name: "canceled", ctx: canceled, opts: Options{Sources: []string{source}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := NewRenderer(tt.opts).Render(tt.ctx)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRender_SourceOrder(t *testing.T) {
+	dir := t.TempDir()
+	b := writeFile(t, dir, "b.yaml", strings.Replace(base, "name: myapp", "name: b", 1))
+	a := writeFile(t, dir, "a.yaml", strings.Replace(base, "name: myapp", "name: a", 1))
+
+	// files are read sorted by path, like the -f flags of the CLI
+	objects, _, err := NewRenderer(Options{Sources: []string{b, a}}).Render(context.Background())
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+	assert.Equal(t, "a", objects[0].GetName())
+	assert.Equal(t, "b", objects[1].GetName())
+}
+
+func TestRender_Canceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	config := writeFile(t, dir, "providers.yaml", "providers:\n  exec: {}\n")
+	patchFile := writeFile(t, dir, "patch.yaml", `
+myapp:
+  deployment/myapp:
+    - op: add
+      path: /metadata/annotations
+      value:
+        slow: ${exec:sleep 10}
+`)
+	source := writeFile(t, dir, "base.yaml", base)
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "fetch", opts: Options{Sources: []string{server.URL + "/base.yaml"}}},
+		{name: "provider", opts: Options{Sources: []string{source}, PatchFiles: []string{patchFile}, ProvidersConfig: config}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, _, err := NewRenderer(tt.opts).Render(ctx)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			assert.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package kubepatch
+
+import (
+	"strings"
+
+	"github.com/kubepatch/kubepatch/internal/patch"
+	"github.com/kubepatch/kubepatch/internal/unstr"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Report describes how Render produced every object.
+type Report struct {
+	// Objects is indexed like the rendered objects.
+	Objects []ObjectReport `json:"objects"`
+}
+
+// ObjectReport is the history of a single object.
+type ObjectReport struct {
+	// Source is the file or URL the object was read from; it is empty for
+	// objects supplied in Options.Objects.
+	Source string `json:"source,omitempty"`
+	// Base is the "kind/name" of the object before patching.
+	Base string `json:"base"`
+	// Rendered is the "kind/name" of the rendered object.
+	Rendered string `json:"rendered"`
+	// Patches are the patch-file entries applied to the object, in order.
+	Patches []PatchReport `json:"patches,omitempty"`
+	// Notes describe changes made outside of patch-file operations,
+	// e.g. by a podSpec overlay or DropAutoscaledReplicas.
+	Notes []string `json:"notes,omitempty"`
+}
+
+// Patched reports whether any patch-file entry was applied to the object.
+func (o ObjectReport) Patched() bool {
+	return len(o.Patches) > 0
+}
+
+// PatchReport describes one patch-file entry applied to an object.
+type PatchReport struct {
+	App         string `json:"app"`
+	ResourceKey string `json:"resourceKey"`
+	// Labels are the common labels added before the operations.
+	Labels map[string]string `json:"labels,omitempty"`
+	// RenamedFrom and RenamedTo are set when metadata.name was set to the app name.
+	RenamedFrom string            `json:"renamedFrom,omitempty"`
+	RenamedTo   string            `json:"renamedTo,omitempty"`
+	Operations  []OperationReport `json:"operations,omitempty"`
+}
+
+// OperationReport is an applied operation with the value at its path before and after.
+type OperationReport struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	// Injected marks operations added by kubepatch rather than the patch-file.
+	Injected bool        `json:"injected,omitempty"`
+	Before   interface{} `json:"before,omitempty"`
+	After    interface{} `json:"after,omitempty"`
+	// HadBefore and HasAfter tell whether the path existed before and after the operation.
+	HadBefore bool `json:"hadBefore"`
+	HasAfter  bool `json:"hasAfter"`
+}
+
+func newReport(trace *patch.Trace, docs []unstr.Doc, rendered []*unstructured.Unstructured) *Report {
+	report := &Report{Objects: make([]ObjectReport, len(trace.Objects))}
+	for i, t := range trace.Objects {
+		o := ObjectReport{Base: t.Base, Notes: t.Notes}
+		if i < len(docs) {
+			o.Source = docs[i].Source
+		}
+		if i < len(rendered) {
+			o.Rendered = strings.ToLower(rendered[i].GetKind()) + "/" + rendered[i].GetName()
+		}
+		for _, p := range t.Patches {
+			pr := PatchReport{
+				App:         p.App,
+				ResourceKey: p.ResourceKey,
+				Labels:      p.Labels,
+				RenamedFrom: p.RenamedFrom,
+				RenamedTo:   p.RenamedTo,
+			}
+			for _, op := range p.Ops {
+				pr.Operations = append(pr.Operations, OperationReport{
+					Op:        op.Op,
+					Path:      op.Path,
+					Injected:  op.Injected,
+					Before:    op.Before,
+					After:     op.After,
+					HadBefore: op.HadBefore,
+					HasAfter:  op.HasAfter,
+				})
+			}
+			o.Patches = append(o.Patches, pr)
+		}
+		report.Objects[i] = o
+	}
+	return report
+}